@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/rhuandantas/metrika/internal/models"
+	"github.com/rhuandantas/metrika/internal/repository"
+)
+
+const (
+	defaultTopAccounts = 10
+	maxTopAccounts     = 100
+)
+
+type flowResponse struct {
+	Count  int64         `json:"count"`
+	Volume models.Int128 `json:"volume"`
+	// Min and Max are null until the first transfer going that way.
+	Min *int64 `json:"min"`
+	Max *int64 `json:"max"`
+}
+
+type accountResponse struct {
+	ID         int64        `json:"id"`
+	Sent       flowResponse `json:"sent"`
+	Received   flowResponse `json:"received"`
+	FirstRound int64        `json:"first_round"`
+	LastRound  int64        `json:"last_round"`
+}
+
+type topAccountsResponse struct {
+	By       models.AccountRanking `json:"by"`
+	Accounts []accountResponse     `json:"accounts"`
+}
+
+func newFlowResponse(f models.Flow) flowResponse {
+	resp := flowResponse{Count: f.Count, Volume: f.Volume}
+	if f.Count > 0 {
+		resp.Min, resp.Max = &f.Min, &f.Max
+	}
+	return resp
+}
+
+func newAccountResponse(a models.Account) accountResponse {
+	return accountResponse{
+		ID:         a.ID,
+		Sent:       newFlowResponse(a.Sent),
+		Received:   newFlowResponse(a.Received),
+		FirstRound: a.FirstRound,
+		LastRound:  a.LastRound,
+	}
+}
+
+// handleAccount serves the sent and received aggregates of a single account.
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid account id %q", r.PathValue("id")))
+		return
+	}
+	a, err := s.store.LoadAccount(r.Context(), id)
+	if errors.Is(err, repository.ErrAccountNotFound) {
+		s.writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, newAccountResponse(a))
+}
+
+// handleTopAccounts serves the accounts leaderboard, ordered by ?by= (sent_volume by default) and
+// holding up to ?limit= accounts.
+func (s *Server) handleTopAccounts(w http.ResponseWriter, r *http.Request) {
+	by := models.RankSentVolume
+	if v := r.URL.Query().Get("by"); v != "" {
+		var err error
+		if by, err = models.ParseAccountRanking(v); err != nil {
+			s.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	limit := defaultTopAccounts
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxTopAccounts {
+			s.writeError(w, http.StatusBadRequest, fmt.Errorf("limit: must be between 1 and %d", maxTopAccounts))
+			return
+		}
+		limit = n
+	}
+
+	accounts, err := s.store.TopAccounts(r.Context(), by, limit)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	resp := topAccountsResponse{By: by, Accounts: make([]accountResponse, 0, len(accounts))}
+	for _, a := range accounts {
+		resp.Accounts = append(resp.Accounts, newAccountResponse(a))
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/rhuandantas/metrika/internal/models"
+	"github.com/rhuandantas/metrika/internal/repository"
+)
+
+// changeResponse holds how much each metric moved between two rounds.
+type changeResponse struct {
+	Count    int64         `json:"count"`
+	Sum      models.Int128 `json:"sum"`
+	Average  float64       `json:"average"`
+	Variance float64       `json:"variance"`
+	StdDev   float64       `json:"stddev"`
+}
+
+type diffResponse struct {
+	From   summaryResponse `json:"from"`
+	To     summaryResponse `json:"to"`
+	Change changeResponse  `json:"change"`
+}
+
+// handleDiff serves the metrics as of rounds ?from= and ?to= and the change between them, to minus from.
+// The count and sum of the change are those of the transfers in the rounds after from up to to.
+func (s *Server) handleDiff(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, ok := s.metricsAt(w, r, "from", q.Get("from"))
+	if !ok {
+		return
+	}
+	to, ok := s.metricsAt(w, r, "to", q.Get("to"))
+	if !ok {
+		return
+	}
+	if from.LastRound > to.LastRound {
+		s.writeError(w, http.StatusBadRequest, errors.New("from must not be after to"))
+		return
+	}
+
+	sum, err := to.Sum.Sub(from.Sum)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, diffResponse{
+		From: newSummaryResponse(from),
+		To:   newSummaryResponse(to),
+		Change: changeResponse{
+			Count:    to.Count - from.Count,
+			Sum:      sum,
+			Average:  to.Average() - from.Average(),
+			Variance: to.Variance() - from.Variance(),
+			StdDev:   to.StdDev() - from.StdDev(),
+		},
+	})
+}
+
+// metricsAt loads the metrics as of the round given in the named query parameter. It writes the error
+// response and returns false if the round is malformed or not available.
+func (s *Server) metricsAt(w http.ResponseWriter, r *http.Request, param, value string) (models.Metrics, bool) {
+	round, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("%s: invalid round %q", param, value))
+		return models.Metrics{}, false
+	}
+	m, err := s.store.MetricsAt(r.Context(), round)
+	if errors.Is(err, repository.ErrRoundNotAvailable) {
+		s.writeError(w, http.StatusNotFound, err)
+		return models.Metrics{}, false
+	}
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return models.Metrics{}, false
+	}
+	return m, true
+}
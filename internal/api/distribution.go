@@ -0,0 +1,79 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rhuandantas/metrika/internal/models"
+	"github.com/rhuandantas/metrika/internal/repository"
+)
+
+// defaultQuantiles are served when the request does not ask for specific ones.
+var defaultQuantiles = []float64{0.5, 0.9, 0.95, 0.99}
+
+type quantileResponse struct {
+	Quantile float64 `json:"quantile"`
+	// Value is null until the first transfer.
+	Value *float64 `json:"value"`
+}
+
+type distributionResponse struct {
+	Count     int64              `json:"count"`
+	Quantiles []quantileResponse `json:"quantiles"`
+	Buckets   []models.Bucket    `json:"buckets"`
+}
+
+// handleDistribution serves amount quantiles and the amount histogram, of the live metrics or of a
+// backfill scope when ?scope= is given. ?q= takes a comma-separated list of quantiles between 0 and 1.
+func (s *Server) handleDistribution(w http.ResponseWriter, r *http.Request) {
+	quantiles, err := parseQuantiles(r.URL.Query().Get("q"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var d *models.Distribution
+	if name := r.URL.Query().Get("scope"); name != "" {
+		sc, err := s.store.LoadScope(r.Context(), name)
+		if errors.Is(err, repository.ErrScopeNotFound) {
+			s.writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		d = sc.Distribution
+	} else if d, err = s.store.LoadDistribution(r.Context()); err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp := distributionResponse{Count: d.Count(), Buckets: d.Buckets()}
+	for _, q := range quantiles {
+		qr := quantileResponse{Quantile: q}
+		if v, ok := d.Quantile(q); ok {
+			qr.Value = &v
+		}
+		resp.Quantiles = append(resp.Quantiles, qr)
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+func parseQuantiles(v string) ([]float64, error) {
+	if v == "" {
+		return defaultQuantiles, nil
+	}
+	var out []float64
+	for _, part := range strings.Split(v, ",") {
+		q, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || q < 0 || q > 1 {
+			return nil, fmt.Errorf("q: %q is not a quantile between 0 and 1", part)
+		}
+		out = append(out, q)
+	}
+	return out, nil
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+type eventsResponse struct {
+	Events     []models.Event `json:"events"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// handleEvents lists stored transfer events in (round, sig) order, one page at a time.
+// Pass next_cursor back as cursor to fetch the following page.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Fetch one extra event to know whether another page exists.
+	limit := filter.Limit
+	filter.Limit++
+	events, err := s.store.QueryEvents(r.Context(), filter)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp := eventsResponse{Events: events}
+	if len(events) > limit {
+		resp.Events = events[:limit]
+		last := resp.Events[limit-1]
+		resp.NextCursor = encodeCursor(models.EventCursor{Round: last.Round, Sig: last.Sig})
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+func parseEventFilter(r *http.Request) (models.EventFilter, error) {
+	q := r.URL.Query()
+	f := models.EventFilter{Limit: defaultPageSize}
+
+	for name, dst := range map[string]**int64{
+		"from_round": &f.FromRound,
+		"to_round":   &f.ToRound,
+		"sender":     &f.Sender,
+		"recipient":  &f.Recipient,
+		"min_amount": &f.MinAmount,
+		"max_amount": &f.MaxAmount,
+	} {
+		v, err := optionalInt(q.Get(name))
+		if err != nil {
+			return f, fmt.Errorf("%s: %w", name, err)
+		}
+		*dst = v
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return f, fmt.Errorf("limit: must be between 1 and %d", maxPageSize)
+		}
+		f.Limit = limit
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		if err != nil {
+			return f, err
+		}
+		f.After = &c
+	}
+	return f, nil
+}
+
+func optionalInt(v string) (*int64, error) {
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid integer %q", v)
+	}
+	return &n, nil
+}
+
+func encodeCursor(c models.EventCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", c.Round, c.Sig)))
+}
+
+func decodeCursor(v string) (models.EventCursor, error) {
+	errInvalid := errors.New("cursor: invalid value")
+	raw, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return models.EventCursor{}, errInvalid
+	}
+	round, sig, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return models.EventCursor{}, errInvalid
+	}
+	n, err := strconv.ParseInt(round, 10, 64)
+	if err != nil {
+		return models.EventCursor{}, errInvalid
+	}
+	return models.EventCursor{Round: n, Sig: sig}, nil
+}
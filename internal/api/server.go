@@ -0,0 +1,279 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rhuandantas/metrika/internal/models"
+	"github.com/rhuandantas/metrika/internal/repository"
+	"github.com/rhuandantas/metrika/internal/smartblox"
+	"github.com/rs/zerolog"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+// Source provides the live ingestion state served by the API.
+type Source interface {
+	// CurrentMetrics returns the latest committed metrics.
+	CurrentMetrics(ctx context.Context) (models.Metrics, error)
+	// HeadRound returns the last round reported by the upstream node.
+	HeadRound() int64
+	// Windows returns the aggregates of the configured sliding windows.
+	Windows(ctx context.Context) ([]models.WindowStats, error)
+}
+
+// Store provides the persisted data served by the API.
+type Store interface {
+	// QueryEvents returns the events matching the filter, ordered by round and sig.
+	QueryEvents(ctx context.Context, filter models.EventFilter) ([]models.Event, error)
+	// LoadDistribution returns the distribution of the live amounts.
+	LoadDistribution(ctx context.Context) (*models.Distribution, error)
+	// LoadAccount returns the aggregates of the account, or repository.ErrAccountNotFound.
+	LoadAccount(ctx context.Context, id int64) (models.Account, error)
+	// TopAccounts returns up to limit accounts ordered by the ranking, highest first.
+	TopAccounts(ctx context.Context, by models.AccountRanking, limit int) ([]models.Account, error)
+	// MetricsAt returns the metrics as of the round, or an error wrapping repository.ErrRoundNotAvailable.
+	MetricsAt(ctx context.Context, round int64) (models.Metrics, error)
+	// QueryRounds returns the stats of the committed rounds matching the filter, ordered by round.
+	QueryRounds(ctx context.Context, filter models.RoundFilter) ([]models.RoundStats, error)
+	// QueryRollups returns up to limit rollups of the period starting in from..to, oldest first.
+	QueryRollups(ctx context.Context, period models.Period, from, to time.Time, limit int) ([]models.Rollup, error)
+	// LoadScope returns the named backfill scope, or repository.ErrScopeNotFound.
+	LoadScope(ctx context.Context, name string) (models.Scope, error)
+}
+
+// Upstream reports the availability of the SmartBlox node.
+type Upstream interface {
+	Health() smartblox.Health
+}
+
+// Option customizes a Server.
+type Option func(*Server)
+
+// WithFeed serves the rounds broadcast by the feed as a live stream on /stream.
+func WithFeed(f *Feed) Option {
+	return func(s *Server) { s.feed = f }
+}
+
+// WithUpstream reports the state of the upstream circuit breaker in /status and /health.
+func WithUpstream(u Upstream) Option {
+	return func(s *Server) { s.upstream = u }
+}
+
+// Server is the embedded HTTP query API.
+type Server struct {
+	srv      *http.Server
+	mux      *http.ServeMux
+	src      Source
+	store    Store
+	upstream Upstream
+	feed     *Feed
+	logger   zerolog.Logger
+	// shutdown is canceled when the server shuts down, ending the live streams that would keep it waiting.
+	shutdown    context.Context
+	stopStreams context.CancelFunc
+}
+
+func New(addr string, src Source, store Store, logger zerolog.Logger, opts ...Option) *Server {
+	s := &Server{src: src, store: store, logger: logger, mux: http.NewServeMux()}
+	s.shutdown, s.stopStreams = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.routes()
+	s.srv = &http.Server{
+		Addr:              addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	s.srv.RegisterOnShutdown(s.stopStreams)
+	return s
+}
+
+// Run serves the API until the provided context is canceled, then shuts the server down gracefully.
+func (s *Server) Run(ctx context.Context) error {
+	errCh := make(chan error, 1)
+	go func() {
+		s.logger.Info().Msgf("HTTP API listening on %s", s.srv.Addr)
+		errCh <- s.srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := s.srv.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+		if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return context.Canceled
+	}
+}
+
+// Handler exposes the API routes, mainly for tests.
+func (s *Server) Handler() http.Handler {
+	return s.srv.Handler
+}
+
+// Handle mounts an extra handler, such as the Prometheus exposition endpoint. It must be called before Run.
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+func (s *Server) routes() {
+	s.mux.HandleFunc("GET /metrics/summary", s.handleSummary)
+	s.mux.HandleFunc("GET /metrics/diff", s.handleDiff)
+	s.mux.HandleFunc("GET /metrics/distribution", s.handleDistribution)
+	s.mux.HandleFunc("GET /metrics/windows", s.handleWindows)
+	s.mux.HandleFunc("GET /status", s.handleStatus)
+	s.mux.HandleFunc("GET /health", s.handleHealth)
+	s.mux.HandleFunc("GET /events", s.handleEvents)
+	s.mux.HandleFunc("GET /stats", s.handleStats)
+	s.mux.HandleFunc("GET /accounts/top", s.handleTopAccounts)
+	s.mux.HandleFunc("GET /accounts/{id}", s.handleAccount)
+	if s.feed != nil {
+		s.mux.HandleFunc("GET /stream", s.handleStream)
+	}
+}
+
+type summaryResponse struct {
+	Count     int64         `json:"count"`
+	Sum       models.Int128 `json:"sum"`
+	Min       *int64        `json:"min"`
+	Max       *int64        `json:"max"`
+	Average   float64       `json:"average"`
+	Variance  float64       `json:"variance"`
+	StdDev    float64       `json:"stddev"`
+	LastRound int64         `json:"last_round"`
+}
+
+func newSummaryResponse(m models.Metrics) summaryResponse {
+	resp := summaryResponse{
+		Count:     m.Count,
+		Sum:       m.Sum,
+		Average:   m.Average(),
+		Variance:  m.Variance(),
+		StdDev:    m.StdDev(),
+		LastRound: m.LastRound,
+	}
+	// Min and max are meaningless until the first transfer is seen.
+	if m.Count > 0 {
+		resp.Min, resp.Max = &m.Min, &m.Max
+	}
+	return resp
+}
+
+// handleSummary serves the live metrics, those of a backfill scope when ?scope= is given, or the live
+// metrics as of a past round when ?round= is given.
+func (s *Server) handleSummary(w http.ResponseWriter, r *http.Request) {
+	var m models.Metrics
+	name, round := r.URL.Query().Get("scope"), r.URL.Query().Get("round")
+	if name != "" && round != "" {
+		s.writeError(w, http.StatusBadRequest, errors.New("scope and round cannot be combined"))
+		return
+	}
+	if round != "" {
+		var ok bool
+		if m, ok = s.metricsAt(w, r, "round", round); !ok {
+			return
+		}
+	} else if name != "" {
+		sc, err := s.store.LoadScope(r.Context(), name)
+		if errors.Is(err, repository.ErrScopeNotFound) {
+			s.writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		m = sc.Metrics
+	} else {
+		var err error
+		if m, err = s.src.CurrentMetrics(r.Context()); err != nil {
+			s.writeError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+	}
+	s.writeJSON(w, http.StatusOK, newSummaryResponse(m))
+}
+
+type statusResponse struct {
+	LastRound int64             `json:"last_round"`
+	HeadRound int64             `json:"head_round"`
+	Lag       int64             `json:"lag"`
+	Upstream  *upstreamResponse `json:"upstream,omitempty"`
+}
+
+type upstreamResponse struct {
+	State               string     `json:"state"`
+	UnavailableSince    *time.Time `json:"unavailable_since,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+// upstreamStatus describes the upstream circuit, or returns nil when no breaker is configured.
+func (s *Server) upstreamStatus() *upstreamResponse {
+	if s.upstream == nil {
+		return nil
+	}
+	h := s.upstream.Health()
+	resp := &upstreamResponse{State: h.State.String(), ConsecutiveFailures: h.ConsecutiveFailures, LastError: h.LastError}
+	if !h.UnavailableSince.IsZero() {
+		resp.UnavailableSince = &h.UnavailableSince
+	}
+	return resp
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	m, err := s.src.CurrentMetrics(r.Context())
+	if err != nil {
+		s.writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	head := s.src.HeadRound()
+	s.writeJSON(w, http.StatusOK, statusResponse{
+		LastRound: m.LastRound,
+		HeadRound: head,
+		Lag:       max(head-m.LastRound, 0),
+		Upstream:  s.upstreamStatus(),
+	})
+}
+
+type healthResponse struct {
+	Status   string            `json:"status"`
+	Upstream *upstreamResponse `json:"upstream,omitempty"`
+}
+
+// handleHealth reports "degraded" while the upstream circuit is not closed. It still answers 200:
+// the API keeps serving the committed metrics, so restarting the process would not help.
+func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	resp := healthResponse{Status: "ok", Upstream: s.upstreamStatus()}
+	if resp.Upstream != nil && resp.Upstream.State != smartblox.StateClosed.String() {
+		resp.Status = "degraded"
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Error().Msgf("Error encoding response: %v", err)
+	}
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		s.logger.Error().Msgf("API error: %v", err)
+	}
+	s.writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,607 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rhuandantas/metrika/internal/models"
+	"github.com/rhuandantas/metrika/internal/repository"
+	"github.com/rhuandantas/metrika/internal/smartblox"
+	"github.com/rs/zerolog"
+)
+
+func TestAPI(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "API Suite")
+}
+
+type fakeSource struct {
+	metrics models.Metrics
+	head    int64
+	windows []models.WindowStats
+	err     error
+}
+
+func (f *fakeSource) CurrentMetrics(context.Context) (models.Metrics, error) { return f.metrics, f.err }
+func (f *fakeSource) HeadRound() int64                                       { return f.head }
+func (f *fakeSource) Windows(context.Context) ([]models.WindowStats, error)  { return f.windows, f.err }
+
+type fakeUpstream struct {
+	health smartblox.Health
+}
+
+func (f *fakeUpstream) Health() smartblox.Health { return f.health }
+
+type fakeStore struct {
+	events   []models.Event
+	filter   models.EventFilter
+	scopes   map[string]models.Scope
+	dist     *models.Distribution
+	accounts []models.Account
+	ranking  models.AccountRanking
+	rounds   []models.RoundStats
+	rollups  []models.Rollup
+	roundsOf models.RoundFilter
+	period   models.Period
+	from, to time.Time
+	history  map[int64]models.Metrics
+}
+
+func (f *fakeStore) MetricsAt(_ context.Context, round int64) (models.Metrics, error) {
+	m, ok := f.history[round]
+	if !ok {
+		return models.Metrics{}, repository.ErrRoundNotAvailable
+	}
+	return m, nil
+}
+
+func (f *fakeStore) QueryRounds(_ context.Context, filter models.RoundFilter) ([]models.RoundStats, error) {
+	f.roundsOf = filter
+	return f.rounds, nil
+}
+
+func (f *fakeStore) QueryRollups(_ context.Context, period models.Period, from, to time.Time, _ int) ([]models.Rollup, error) {
+	f.period, f.from, f.to = period, from, to
+	return f.rollups, nil
+}
+
+func (f *fakeStore) LoadAccount(_ context.Context, id int64) (models.Account, error) {
+	for _, a := range f.accounts {
+		if a.ID == id {
+			return a, nil
+		}
+	}
+	return models.Account{}, repository.ErrAccountNotFound
+}
+
+func (f *fakeStore) TopAccounts(_ context.Context, by models.AccountRanking, limit int) ([]models.Account, error) {
+	f.ranking = by
+	return f.accounts[:min(limit, len(f.accounts))], nil
+}
+
+func (f *fakeStore) LoadDistribution(context.Context) (*models.Distribution, error) {
+	return f.dist, nil
+}
+
+func (f *fakeStore) LoadScope(_ context.Context, name string) (models.Scope, error) {
+	sc, ok := f.scopes[name]
+	if !ok {
+		return models.Scope{}, repository.ErrScopeNotFound
+	}
+	return sc, nil
+}
+
+func (f *fakeStore) QueryEvents(_ context.Context, filter models.EventFilter) ([]models.Event, error) {
+	f.filter = filter
+	if filter.Limit > 0 && len(f.events) > filter.Limit {
+		return f.events[:filter.Limit], nil
+	}
+	return f.events, nil
+}
+
+var _ = Describe("Server", func() {
+	var (
+		src   *fakeSource
+		store *fakeStore
+		srv   *Server
+	)
+
+	get := func(path string, out any) int {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if out != nil {
+			Expect(json.Unmarshal(rec.Body.Bytes(), out)).To(Succeed())
+		}
+		return rec.Code
+	}
+
+	BeforeEach(func() {
+		src = &fakeSource{metrics: models.NewMetrics()}
+		store = &fakeStore{dist: models.NewDistribution()}
+		srv = New(":0", src, store, zerolog.Nop())
+	})
+
+	It("should serve the metrics summary with the average and spread", func() {
+		src.metrics.Update(100, 3)
+		src.metrics.Update(300, 4)
+
+		var resp map[string]any
+		Expect(get("/metrics/summary", &resp)).To(Equal(http.StatusOK))
+		Expect(resp).To(HaveKeyWithValue("count", BeNumerically("==", 2)))
+		Expect(resp).To(HaveKeyWithValue("sum", BeNumerically("==", 400)))
+		Expect(resp).To(HaveKeyWithValue("min", BeNumerically("==", 100)))
+		Expect(resp).To(HaveKeyWithValue("max", BeNumerically("==", 300)))
+		Expect(resp).To(HaveKeyWithValue("average", BeNumerically("==", 200)))
+		Expect(resp).To(HaveKeyWithValue("variance", BeNumerically("==", 10000)))
+		Expect(resp).To(HaveKeyWithValue("stddev", BeNumerically("==", 100)))
+		Expect(resp).To(HaveKeyWithValue("last_round", BeNumerically("==", 4)))
+	})
+	It("should report null min and max before the first transfer", func() {
+		var resp map[string]any
+		Expect(get("/metrics/summary", &resp)).To(Equal(http.StatusOK))
+		Expect(resp).To(HaveKeyWithValue("min", BeNil()))
+		Expect(resp).To(HaveKeyWithValue("max", BeNil()))
+	})
+	It("should serve the summary of a backfill scope", func() {
+		sc := models.NewScope("history", 10, 20)
+		sc.Metrics.Update(40, 11)
+		store.scopes = map[string]models.Scope{"history": sc}
+
+		var resp summaryResponse
+		Expect(get("/metrics/summary?scope=history", &resp)).To(Equal(http.StatusOK))
+		Expect(resp.Count).To(Equal(int64(1)))
+		Expect(resp.LastRound).To(Equal(int64(11)))
+		Expect(get("/metrics/summary?scope=nope", nil)).To(Equal(http.StatusNotFound))
+	})
+	It("should serve amount quantiles and the histogram", func() {
+		for amount := int64(1); amount <= 100; amount++ {
+			store.dist.Add(amount)
+		}
+
+		var resp distributionResponse
+		Expect(get("/metrics/distribution?q=0.5,0.99", &resp)).To(Equal(http.StatusOK))
+		Expect(resp.Count).To(Equal(int64(100)))
+		Expect(resp.Quantiles).To(HaveLen(2))
+		Expect(*resp.Quantiles[0].Value).To(BeNumerically("~", 50, 1))
+		Expect(*resp.Quantiles[1].Value).To(BeNumerically("~", 99, 1))
+		// 1 | 2..10 | 11..100
+		Expect(resp.Buckets[1].Count).To(Equal(int64(1)))
+		Expect(resp.Buckets[2].Count).To(Equal(int64(9)))
+		Expect(resp.Buckets[3].Count).To(Equal(int64(90)))
+		Expect(*resp.Buckets[3].UpperBound).To(Equal(int64(100)))
+		Expect(resp.Buckets[len(resp.Buckets)-1].UpperBound).To(BeNil())
+	})
+	It("should serve null quantiles before the first transfer", func() {
+		var resp distributionResponse
+		Expect(get("/metrics/distribution", &resp)).To(Equal(http.StatusOK))
+		Expect(resp.Quantiles).To(HaveLen(4))
+		Expect(resp.Quantiles[0].Value).To(BeNil())
+		Expect(get("/metrics/distribution?q=2", nil)).To(Equal(http.StatusBadRequest))
+	})
+	It("should report the lag behind the upstream head", func() {
+		src.metrics.LastRound = 7
+		src.head = 10
+
+		var resp statusResponse
+		Expect(get("/status", &resp)).To(Equal(http.StatusOK))
+		Expect(resp).To(Equal(statusResponse{LastRound: 7, HeadRound: 10, Lag: 3}))
+	})
+	It("should report ok without an upstream breaker", func() {
+		var resp healthResponse
+		Expect(get("/health", &resp)).To(Equal(http.StatusOK))
+		Expect(resp).To(Equal(healthResponse{Status: "ok"}))
+	})
+	It("should report since when upstream is unavailable", func() {
+		since := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		up := &fakeUpstream{health: smartblox.Health{State: smartblox.StateOpen, UnavailableSince: since, ConsecutiveFailures: 5, LastError: "status code 503"}}
+		srv = New(":0", src, store, zerolog.Nop(), WithUpstream(up))
+
+		var health healthResponse
+		Expect(get("/health", &health)).To(Equal(http.StatusOK))
+		Expect(health.Status).To(Equal("degraded"))
+		Expect(health.Upstream.State).To(Equal("open"))
+		Expect(health.Upstream.UnavailableSince.Equal(since)).To(BeTrue())
+		Expect(health.Upstream.LastError).To(Equal("status code 503"))
+
+		var status statusResponse
+		Expect(get("/status", &status)).To(Equal(http.StatusOK))
+		Expect(status.Upstream.ConsecutiveFailures).To(Equal(5))
+
+		up.health = smartblox.Health{State: smartblox.StateClosed}
+		var recovered healthResponse
+		Expect(get("/health", &recovered)).To(Equal(http.StatusOK))
+		Expect(recovered.Status).To(Equal("ok"))
+		Expect(recovered.Upstream.UnavailableSince).To(BeNil())
+	})
+	It("should return 503 when metrics cannot be loaded", func() {
+		src.err = errors.New("fail")
+		Expect(get("/status", nil)).To(Equal(http.StatusServiceUnavailable))
+	})
+	It("should pass event filters to the store and paginate", func() {
+		store.events = []models.Event{
+			{Round: 100, Sig: "a", Sender: 7, Amount: 5},
+			{Round: 101, Sig: "b", Sender: 7, Amount: 6},
+			{Round: 102, Sig: "c", Sender: 7, Amount: 7},
+		}
+
+		var page eventsResponse
+		Expect(get("/events?sender=7&from_round=100&to_round=200&limit=2", &page)).To(Equal(http.StatusOK))
+		Expect(page.Events).To(HaveLen(2))
+		Expect(page.NextCursor).NotTo(BeEmpty())
+		Expect(*store.filter.Sender).To(Equal(int64(7)))
+		Expect(*store.filter.FromRound).To(Equal(int64(100)))
+		Expect(*store.filter.ToRound).To(Equal(int64(200)))
+		Expect(store.filter.Recipient).To(BeNil())
+
+		store.events = store.events[2:]
+		var next eventsResponse
+		Expect(get("/events?sender=7&limit=2&cursor="+page.NextCursor, &next)).To(Equal(http.StatusOK))
+		Expect(*store.filter.After).To(Equal(models.EventCursor{Round: 101, Sig: "b"}))
+		Expect(next.Events).To(HaveLen(1))
+		Expect(next.NextCursor).To(BeEmpty())
+	})
+	It("should reject malformed event filters", func() {
+		Expect(get("/events?min_amount=lots", nil)).To(Equal(http.StatusBadRequest))
+		Expect(get("/events?limit=0", nil)).To(Equal(http.StatusBadRequest))
+		Expect(get("/events?cursor=nope", nil)).To(Equal(http.StatusBadRequest))
+	})
+
+	Describe("accounts", func() {
+		BeforeEach(func() {
+			store.accounts = []models.Account{
+				{ID: 7, Sent: models.Flow{Count: 2, Volume: models.NewInt128(30), Min: 10, Max: 20}, FirstRound: 3, LastRound: 9},
+				{ID: 8, Received: models.Flow{Count: 1, Volume: models.NewInt128(5), Min: 5, Max: 5}, FirstRound: 4, LastRound: 4},
+			}
+		})
+
+		It("should serve an account with null bounds for a direction without transfers", func() {
+			var resp map[string]any
+			Expect(get("/accounts/7", &resp)).To(Equal(http.StatusOK))
+			Expect(resp).To(HaveKeyWithValue("sent", SatisfyAll(
+				HaveKeyWithValue("count", BeNumerically("==", 2)),
+				HaveKeyWithValue("volume", BeNumerically("==", 30)),
+				HaveKeyWithValue("min", BeNumerically("==", 10)),
+			)))
+			Expect(resp).To(HaveKeyWithValue("received", HaveKeyWithValue("min", BeNil())))
+			Expect(resp).To(HaveKeyWithValue("first_round", BeNumerically("==", 3)))
+		})
+		It("should return 404 for an unknown account and 400 for a malformed id", func() {
+			Expect(get("/accounts/99", nil)).To(Equal(http.StatusNotFound))
+			Expect(get("/accounts/abc", nil)).To(Equal(http.StatusBadRequest))
+		})
+		It("should serve the leaderboard", func() {
+			var resp struct {
+				By       string `json:"by"`
+				Accounts []struct {
+					ID int64 `json:"id"`
+				} `json:"accounts"`
+			}
+			Expect(get("/accounts/top?by=received_count&limit=1", &resp)).To(Equal(http.StatusOK))
+			Expect(store.ranking).To(Equal(models.RankReceivedCount))
+			Expect(resp.By).To(Equal("received_count"))
+			Expect(resp.Accounts).To(HaveLen(1))
+
+			Expect(get("/accounts/top?by=balance", nil)).To(Equal(http.StatusBadRequest))
+			Expect(get("/accounts/top?limit=1000", nil)).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	It("should serve the sliding windows with null bounds for empty ones", func() {
+		src.windows = []models.WindowStats{
+			{Window: models.WindowSpec{Rounds: 100}, Rounds: 100, Count: 4, Sum: models.NewInt128(100), Min: 5, Max: 40},
+			{Window: models.WindowSpec{Span: 15 * time.Minute}},
+		}
+		var resp struct {
+			Windows []map[string]any `json:"windows"`
+		}
+		Expect(get("/metrics/windows", &resp)).To(Equal(http.StatusOK))
+		Expect(resp.Windows).To(HaveLen(2))
+		Expect(resp.Windows[0]).To(SatisfyAll(
+			HaveKeyWithValue("window", "100"),
+			HaveKeyWithValue("average", BeNumerically("==", 25)),
+			HaveKeyWithValue("min", BeNumerically("==", 5)),
+		))
+		Expect(resp.Windows[1]).To(SatisfyAll(
+			HaveKeyWithValue("window", "15m0s"),
+			HaveKeyWithValue("max", BeNil()),
+		))
+	})
+
+	Describe("point-in-time metrics", func() {
+		BeforeEach(func() {
+			at10, at20 := models.NewMetrics(), models.NewMetrics()
+			at10.Update(100, 10)
+			at20.Update(100, 10)
+			at20.Update(300, 20)
+			store.history = map[int64]models.Metrics{10: at10, 20: at20}
+		})
+
+		It("should serve the summary as of a round", func() {
+			var resp map[string]any
+			Expect(get("/metrics/summary?round=10", &resp)).To(Equal(http.StatusOK))
+			Expect(resp).To(HaveKeyWithValue("count", BeNumerically("==", 1)))
+			Expect(resp).To(HaveKeyWithValue("last_round", BeNumerically("==", 10)))
+
+			Expect(get("/metrics/summary?round=11", nil)).To(Equal(http.StatusNotFound))
+			Expect(get("/metrics/summary?round=ten", nil)).To(Equal(http.StatusBadRequest))
+			Expect(get("/metrics/summary?round=10&scope=history", nil)).To(Equal(http.StatusBadRequest))
+		})
+		It("should serve the change between two rounds", func() {
+			var resp struct {
+				From   map[string]any `json:"from"`
+				To     map[string]any `json:"to"`
+				Change map[string]any `json:"change"`
+			}
+			Expect(get("/metrics/diff?from=10&to=20", &resp)).To(Equal(http.StatusOK))
+			Expect(resp.From).To(HaveKeyWithValue("average", BeNumerically("==", 100)))
+			Expect(resp.To).To(HaveKeyWithValue("average", BeNumerically("==", 200)))
+			Expect(resp.Change).To(HaveKeyWithValue("count", BeNumerically("==", 1)))
+			Expect(resp.Change).To(HaveKeyWithValue("sum", BeNumerically("==", 300)))
+			Expect(resp.Change).To(HaveKeyWithValue("average", BeNumerically("==", 100)))
+			Expect(resp.Change).To(HaveKeyWithValue("stddev", BeNumerically("==", 100)))
+
+			Expect(get("/metrics/diff?from=20&to=10", nil)).To(Equal(http.StatusBadRequest))
+			Expect(get("/metrics/diff?from=10", nil)).To(Equal(http.StatusBadRequest))
+			Expect(get("/metrics/diff?from=10&to=30", nil)).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("stats", func() {
+		ingestedAt := time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)
+
+		It("should serve the rounds in range with null bounds for rounds without transfers", func() {
+			store.rounds = []models.RoundStats{
+				{Round: 4, Hash: "h4", TxCount: 2, IngestedAt: ingestedAt},
+				{Round: 5, Hash: "h5", TxCount: 3, Count: 1, Sum: models.NewInt128(40), Min: 40, Max: 40},
+			}
+			var resp struct {
+				Granularity string           `json:"granularity"`
+				Rounds      []map[string]any `json:"rounds"`
+			}
+			Expect(get("/stats?from_round=4&to=2024-05-02T00:00:00Z", &resp)).To(Equal(http.StatusOK))
+			Expect(*store.roundsOf.FromRound).To(Equal(int64(4)))
+			Expect(store.roundsOf.To.Equal(time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC))).To(BeTrue())
+			Expect(store.roundsOf.Limit).To(Equal(defaultPageSize))
+			Expect(resp.Granularity).To(Equal("round"))
+			Expect(resp.Rounds).To(HaveLen(2))
+			Expect(resp.Rounds[0]).To(HaveKeyWithValue("min", BeNil()))
+			Expect(resp.Rounds[0]).To(HaveKeyWithValue("ingested_at", "2024-05-01T10:15:00Z"))
+			Expect(resp.Rounds[1]).To(HaveKeyWithValue("volume", BeNumerically("==", 40)))
+			Expect(resp.Rounds[1]).NotTo(HaveKey("ingested_at"))
+		})
+		It("should serve the rollups of a time range", func() {
+			store.rollups = []models.Rollup{
+				{Period: models.PeriodHour, Start: ingestedAt.Truncate(time.Hour), Rounds: 2, TxCount: 5, Count: 1, Volume: models.NewInt128(40), Min: 40, Max: 40},
+			}
+			var resp struct {
+				Granularity string           `json:"granularity"`
+				Rollups     []map[string]any `json:"rollups"`
+			}
+			Expect(get("/stats?granularity=hour&from=2024-05-01T00:00:00Z&to=2024-05-02T00:00:00Z", &resp)).To(Equal(http.StatusOK))
+			Expect(store.period).To(Equal(models.PeriodHour))
+			Expect(store.from.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))).To(BeTrue())
+			Expect(resp.Granularity).To(Equal("hour"))
+			Expect(resp.Rollups).To(ConsistOf(SatisfyAll(
+				HaveKeyWithValue("start", "2024-05-01T10:00:00Z"),
+				HaveKeyWithValue("rounds", BeNumerically("==", 2)),
+				HaveKeyWithValue("max", BeNumerically("==", 40)),
+			)))
+
+			Expect(get("/stats?granularity=day", nil)).To(Equal(http.StatusOK))
+			Expect(store.to.Sub(store.from)).To(Equal(30 * 24 * time.Hour))
+		})
+		It("should reject invalid parameters", func() {
+			Expect(get("/stats?granularity=week", nil)).To(Equal(http.StatusBadRequest))
+			Expect(get("/stats?granularity=day&from_round=3", nil)).To(Equal(http.StatusBadRequest))
+			Expect(get("/stats?from=yesterday", nil)).To(Equal(http.StatusBadRequest))
+			Expect(get("/stats?limit=5000", nil)).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
+
+var _ = Describe("Stream", func() {
+	var (
+		src   *fakeSource
+		store *fakeStore
+		feed  *Feed
+		srv   *Server
+		ts    *httptest.Server
+	)
+
+	type message struct{ id, event, data string }
+
+	// open connects to the stream and returns a function reading its next message, skipping comments.
+	open := func(path string, header http.Header) (*http.Response, func() message) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		DeferCleanup(cancel)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+path, nil)
+		Expect(err).To(BeNil())
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).To(BeNil())
+		DeferCleanup(resp.Body.Close)
+		r := bufio.NewReader(resp.Body)
+		return resp, func() message {
+			var msg message
+			for {
+				line, err := r.ReadString('\n')
+				Expect(err).To(BeNil())
+				line = strings.TrimSuffix(line, "\n")
+				switch {
+				case line == "" && msg.event != "":
+					return msg
+				case strings.HasPrefix(line, "id: "):
+					msg.id = strings.TrimPrefix(line, "id: ")
+				case strings.HasPrefix(line, "event: "):
+					msg.event = strings.TrimPrefix(line, "event: ")
+				case strings.HasPrefix(line, "data: "):
+					msg.data = strings.TrimPrefix(line, "data: ")
+				}
+			}
+		}
+	}
+	decode := func(msg message) streamRound {
+		var round streamRound
+		Expect(json.Unmarshal([]byte(msg.data), &round)).To(Succeed())
+		return round
+	}
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	BeforeEach(func() {
+		src = &fakeSource{metrics: models.NewMetrics()}
+		store = &fakeStore{dist: models.NewDistribution()}
+		feed = NewFeed(8)
+		srv = New(":0", src, store, zerolog.Nop(), WithFeed(feed))
+		ts = httptest.NewServer(srv.Handler())
+		DeferCleanup(ts.Close)
+	})
+
+	It("should push the committed rounds with the events matching the filters", func() {
+		resp, next := open("/stream?recipient=2&min_amount=10", nil)
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+
+		m := models.NewMetrics()
+		for _, amount := range []int64{20, 50, 5} {
+			Expect(m.Update(amount, 5)).To(Succeed())
+		}
+		feed.RoundCommitted(5, []models.Event{
+			{Round: 5, Sig: "a", Sender: 1, Recipient: 2, Amount: 20},
+			{Round: 5, Sig: "b", Sender: 1, Recipient: 3, Amount: 50},
+			{Round: 5, Sig: "c", Sender: 1, Recipient: 2, Amount: 5},
+		}, m)
+		msg := next()
+		Expect(msg.id).To(Equal("5"))
+		Expect(msg.event).To(Equal("round"))
+		round := decode(msg)
+		Expect(round.Round).To(Equal(int64(5)))
+		Expect(round.Events).To(ConsistOf(HaveField("Sig", "a")))
+		Expect(round.Metrics.Count).To(Equal(int64(3)))
+		Expect(round.Metrics.LastRound).To(Equal(int64(5)))
+
+		m.LastRound = 6
+		feed.RoundCommitted(6, nil, m)
+		msg = next()
+		Expect(msg.id).To(Equal("6"))
+		Expect(msg.data).To(ContainSubstring(`"events":[]`))
+	})
+	It("should replay the rounds missed since the Last-Event-ID before the live ones", func() {
+		at1 := models.NewMetrics()
+		Expect(at1.Update(10, 1)).To(Succeed())
+		store.history = map[int64]models.Metrics{1: at1}
+		store.rounds = []models.RoundStats{
+			{Round: 2, Count: 1, Sum: models.NewInt128(30), Min: 30, Max: 30, Mean: 30},
+			{Round: 3},
+		}
+		store.events = []models.Event{{Round: 2, Sig: "b", Sender: 4, Recipient: 5, Amount: 30}}
+		src.metrics = at1
+		Expect(src.metrics.Update(30, 2)).To(Succeed())
+		src.metrics.LastRound = 3
+
+		_, next := open("/stream?sender=4", http.Header{"Last-Event-Id": {"1"}})
+		round := decode(next())
+		Expect(round.Round).To(Equal(int64(2)))
+		Expect(round.Events).To(ConsistOf(HaveField("Sig", "b")))
+		Expect(round.Metrics.Count).To(Equal(int64(2)))
+		Expect(round.Metrics.Sum).To(Equal(models.NewInt128(40)))
+		Expect(*store.roundsOf.FromRound).To(Equal(int64(2)))
+		Expect(*store.roundsOf.ToRound).To(Equal(int64(3)))
+		Expect(*store.filter.Sender).To(Equal(int64(4)))
+
+		round = decode(next())
+		Expect(round.Round).To(Equal(int64(3)))
+		Expect(round.Events).To(BeEmpty())
+		Expect(round.Metrics.LastRound).To(Equal(int64(3)))
+
+		// Round 3 was replayed already, only the ones after it are pushed.
+		feed.RoundCommitted(3, nil, src.metrics)
+		src.metrics.LastRound = 4
+		feed.RoundCommitted(4, nil, src.metrics)
+		Expect(next().id).To(Equal("4"))
+	})
+	It("should forward the rollbacks and push the rounds again once re-ingested", func() {
+		_, next := open("/stream", nil)
+		m := models.Metrics{LastRound: 4}
+		feed.RoundCommitted(4, nil, m)
+		feed.RolledBack(3)
+		feed.RoundCommitted(4, nil, m)
+
+		Expect(next().id).To(Equal("4"))
+		msg := next()
+		Expect(msg.event).To(Equal("rollback"))
+		Expect(msg.id).To(Equal("3"))
+		Expect(msg.data).To(MatchJSON(`{"round":3}`))
+		Expect(next().id).To(Equal("4"))
+	})
+	It("should roll back a client resuming after rounds that were rolled back", func() {
+		src.metrics.LastRound = 2
+		_, next := open("/stream?after=5", nil)
+		msg := next()
+		Expect(msg.event).To(Equal("rollback"))
+		Expect(msg.id).To(Equal("2"))
+	})
+	It("should refuse to resume from rounds it cannot replay", func() {
+		src.metrics.LastRound = 5000
+		Expect(get("/stream?after=1")).To(Equal(http.StatusGone))
+
+		src.metrics.LastRound = 10
+		Expect(get("/stream?after=5")).To(Equal(http.StatusGone))
+
+		store.history = map[int64]models.Metrics{5: {LastRound: 5}}
+		store.rounds = []models.RoundStats{{Round: 6}}
+		Expect(get("/stream?after=5")).To(Equal(http.StatusGone))
+	})
+	It("should reject invalid parameters", func() {
+		Expect(get("/stream?after=-1")).To(Equal(http.StatusBadRequest))
+		Expect(get("/stream?after=latest")).To(Equal(http.StatusBadRequest))
+		Expect(get("/stream?min_amount=lots")).To(Equal(http.StatusBadRequest))
+	})
+	It("should end the streams when the server shuts down", func() {
+		resp, _ := open("/stream", nil)
+		srv.stopStreams()
+		body, err := io.ReadAll(resp.Body)
+		Expect(err).To(BeNil())
+		Expect(string(body)).To(Equal(": connected\n\n"))
+	})
+	It("should not serve the stream without a feed", func() {
+		srv = New(":0", src, store, zerolog.Nop())
+		Expect(get("/stream")).To(Equal(http.StatusNotFound))
+	})
+
+	Describe("Feed", func() {
+		It("should drop the clients whose buffer is full without blocking the others", func() {
+			f := NewFeed(1)
+			slow, fast := f.subscribe(), f.subscribe()
+			var msg feedMessage
+			f.RoundCommitted(1, nil, models.Metrics{})
+			Expect(fast).To(Receive(&msg))
+			Expect(msg.round).To(Equal(int64(1)))
+			f.RoundCommitted(2, nil, models.Metrics{})
+
+			Expect(slow).To(Receive(&msg))
+			Expect(msg.round).To(Equal(int64(1)))
+			Expect(slow).To(BeClosed())
+			Expect(fast).To(Receive(&msg))
+			Expect(msg.round).To(Equal(int64(2)))
+			f.unsubscribe(slow)
+			f.unsubscribe(fast)
+			Expect(f.subs).To(BeEmpty())
+		})
+	})
+})
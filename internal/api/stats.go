@@ -0,0 +1,157 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+// defaultRollupSpan is how many periods back /stats looks when no time range is given.
+var defaultRollupSpan = map[models.Period]int{
+	models.PeriodHour: 24,
+	models.PeriodDay:  30,
+}
+
+type roundResponse struct {
+	Round   int64         `json:"round"`
+	Hash    string        `json:"hash"`
+	TxCount int64         `json:"tx_count"`
+	Count   int64         `json:"count"`
+	Volume  models.Int128 `json:"volume"`
+	// Min and Max are null for rounds without transfers.
+	Min *int64 `json:"min"`
+	Max *int64 `json:"max"`
+	// IngestedAt is left out for rounds committed before it was recorded.
+	IngestedAt *time.Time `json:"ingested_at,omitempty"`
+}
+
+type rollupResponse struct {
+	Start   time.Time     `json:"start"`
+	Rounds  int64         `json:"rounds"`
+	TxCount int64         `json:"tx_count"`
+	Count   int64         `json:"count"`
+	Volume  models.Int128 `json:"volume"`
+	Min     *int64        `json:"min"`
+	Max     *int64        `json:"max"`
+}
+
+type roundsResponse struct {
+	Granularity string          `json:"granularity"`
+	Rounds      []roundResponse `json:"rounds"`
+}
+
+type rollupsResponse struct {
+	Granularity models.Period    `json:"granularity"`
+	Rollups     []rollupResponse `json:"rollups"`
+}
+
+func newRoundResponse(st models.RoundStats) roundResponse {
+	resp := roundResponse{Round: st.Round, Hash: st.Hash, TxCount: st.TxCount, Count: st.Count, Volume: st.Sum}
+	if st.Count > 0 {
+		resp.Min, resp.Max = &st.Min, &st.Max
+	}
+	if !st.IngestedAt.IsZero() {
+		resp.IngestedAt = &st.IngestedAt
+	}
+	return resp
+}
+
+func newRollupResponse(r models.Rollup) rollupResponse {
+	resp := rollupResponse{Start: r.Start, Rounds: r.Rounds, TxCount: r.TxCount, Count: r.Count, Volume: r.Volume}
+	if r.Count > 0 {
+		resp.Min, resp.Max = &r.Min, &r.Max
+	}
+	return resp
+}
+
+// handleStats serves the per-round stats (?granularity=round, the default) or the hourly and daily
+// rollups (?granularity=hour|day). Rounds are selected by from_round..to_round and by ingest time
+// from..to (RFC 3339, to exclusive), rollups by time only and default to the last 24 hours or 30 days.
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := defaultPageSize
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			s.writeError(w, http.StatusBadRequest, fmt.Errorf("limit: must be between 1 and %d", maxPageSize))
+			return
+		}
+		limit = n
+	}
+	var filter models.RoundFilter
+	for name, dst := range map[string]**int64{"from_round": &filter.FromRound, "to_round": &filter.ToRound} {
+		v, err := optionalInt(q.Get(name))
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		*dst = v
+	}
+	for name, dst := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		v, err := optionalTime(q.Get(name))
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		*dst = v
+	}
+
+	granularity := q.Get("granularity")
+	if granularity == "" || granularity == "round" {
+		filter.Limit = limit
+		stats, err := s.store.QueryRounds(r.Context(), filter)
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		resp := roundsResponse{Granularity: "round", Rounds: make([]roundResponse, 0, len(stats))}
+		for _, st := range stats {
+			resp.Rounds = append(resp.Rounds, newRoundResponse(st))
+		}
+		s.writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	period, err := models.ParsePeriod(granularity)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("granularity: unknown value %q, want round, hour or day", granularity))
+		return
+	}
+	if filter.FromRound != nil || filter.ToRound != nil {
+		s.writeError(w, http.StatusBadRequest, errors.New("from_round and to_round only apply to the round granularity"))
+		return
+	}
+	to := time.Now()
+	if filter.To != nil {
+		to = *filter.To
+	}
+	from := to.Add(-time.Duration(defaultRollupSpan[period]) * period.Duration())
+	if filter.From != nil {
+		from = *filter.From
+	}
+	rollups, err := s.store.QueryRollups(r.Context(), period, from, to, limit)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	resp := rollupsResponse{Granularity: period, Rollups: make([]rollupResponse, 0, len(rollups))}
+	for _, ru := range rollups {
+		resp.Rollups = append(resp.Rollups, newRollupResponse(ru))
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+func optionalTime(v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time %q, want RFC 3339", v)
+	}
+	return &t, nil
+}
@@ -0,0 +1,279 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rhuandantas/metrika/internal/models"
+	"github.com/rhuandantas/metrika/internal/repository"
+)
+
+const (
+	// maxStreamReplay is how many rounds behind the live one a stream can resume from.
+	maxStreamReplay = 1000
+	// streamKeepAlive is how often an idle stream sends a comment, so proxies do not close it.
+	streamKeepAlive = 15 * time.Second
+	// streamWriteTimeout is how long a client has to take each message before it is disconnected.
+	streamWriteTimeout = 10 * time.Second
+)
+
+// Feed broadcasts the committed rounds to the clients of the live stream. It implements ingest.Notifier
+// and never blocks the ingestor: a client that lets its buffer fill up is dropped, and resumes from
+// its last round when it reconnects. It is safe for concurrent use.
+type Feed struct {
+	buffer int
+	mu     sync.Mutex
+	subs   map[chan feedMessage]struct{}
+}
+
+// feedMessage is a committed round or, when rollback is set, the round everything after was rolled back to.
+type feedMessage struct {
+	round    int64
+	rollback bool
+	events   []models.Event
+	metrics  models.Metrics
+}
+
+// NewFeed buffers up to buffer rounds for each client.
+func NewFeed(buffer int) *Feed {
+	return &Feed{buffer: max(buffer, 1), subs: make(map[chan feedMessage]struct{})}
+}
+
+func (f *Feed) RoundCommitted(round int64, events []models.Event, metrics models.Metrics) {
+	f.broadcast(feedMessage{round: round, events: events, metrics: metrics})
+}
+
+func (f *Feed) RolledBack(round int64) {
+	f.broadcast(feedMessage{round: round, rollback: true})
+}
+
+// broadcast hands the message to every client, closing the channel of those whose buffer is full.
+func (f *Feed) broadcast(msg feedMessage) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subs {
+		select {
+		case ch <- msg:
+		default:
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (f *Feed) subscribe() chan feedMessage {
+	ch := make(chan feedMessage, f.buffer)
+	f.mu.Lock()
+	f.subs[ch] = struct{}{}
+	f.mu.Unlock()
+	return ch
+}
+
+func (f *Feed) unsubscribe(ch chan feedMessage) {
+	f.mu.Lock()
+	delete(f.subs, ch)
+	f.mu.Unlock()
+}
+
+type streamRound struct {
+	Round   int64           `json:"round"`
+	Events  []models.Event  `json:"events"`
+	Metrics summaryResponse `json:"metrics"`
+}
+
+type streamRollback struct {
+	Round int64 `json:"round"`
+}
+
+// handleStream pushes every committed round as a Server-Sent Event, "round", with its events matching the
+// sender, recipient and min_amount filters and the metrics as of the round. A rollback is an event of its
+// own, the rounds after it are sent again once they are re-ingested. The id of each event is its round,
+// so a client reconnecting with Last-Event-ID, or connecting with ?after=<round>, first receives the
+// rounds it missed.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseStreamFilter(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	after, err := optionalInt(r.URL.Query().Get("after"))
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		after, err = optionalInt(id)
+	}
+	if err != nil || (after != nil && *after < 0) {
+		s.writeError(w, http.StatusBadRequest, errors.New("after: must be a round"))
+		return
+	}
+
+	// Subscribe before reading the live round, so that no round committed in between is missed.
+	ch := s.feed.subscribe()
+	defer s.feed.unsubscribe(ch)
+	var replay []feedMessage
+	if after != nil {
+		var status int
+		if replay, status, err = s.replay(r.Context(), *after, filter); err != nil {
+			s.writeError(w, status, err)
+			return
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	send := func(format string, args ...any) error {
+		// A deadline per message bounds how long a client that stopped reading holds the handler.
+		_ = rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	if err := send(": connected\n\n"); err != nil {
+		return
+	}
+
+	// last is the last round the client has, the live rounds up to it were replayed or seen before reconnecting.
+	last := int64(-1)
+	if after != nil {
+		last = *after
+	}
+	deliver := func(msg feedMessage) error {
+		switch {
+		case msg.rollback:
+			last = min(last, msg.round)
+			return send("id: %d\nevent: rollback\ndata: %s\n\n", msg.round, mustJSON(streamRollback{Round: msg.round}))
+		case msg.round > last:
+			last = msg.round
+			return s.sendRound(send, msg, filter)
+		}
+		return nil
+	}
+	for _, msg := range replay {
+		if err := deliver(msg); err != nil {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.shutdown.Done():
+			return
+		case <-keepAlive.C:
+			if err := send(": keep-alive\n\n"); err != nil {
+				return
+			}
+		case msg, ok := <-ch:
+			if !ok {
+				s.logger.Warn().Msgf("Dropping a live stream client that fell %d rounds behind", s.feed.buffer)
+				_ = send("event: dropped\ndata: {\"error\":\"client too slow, reconnect to resume\"}\n\n")
+				return
+			}
+			if err := deliver(msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) sendRound(send func(string, ...any) error, msg feedMessage, filter models.EventFilter) error {
+	events := make([]models.Event, 0)
+	for _, e := range msg.events {
+		if filter.Match(e) {
+			events = append(events, e)
+		}
+	}
+	data := mustJSON(streamRound{Round: msg.round, Events: events, Metrics: newSummaryResponse(msg.metrics)})
+	return send("id: %d\nevent: round\ndata: %s\n\n", msg.round, data)
+}
+
+// replay loads the rounds committed after the given one from the repository, with the metrics as of each.
+// It fails with the status to answer if they cannot be replayed.
+func (s *Server) replay(ctx context.Context, after int64, filter models.EventFilter) ([]feedMessage, int, error) {
+	live, err := s.src.CurrentMetrics(ctx)
+	if err != nil {
+		return nil, http.StatusServiceUnavailable, err
+	}
+	if after > live.LastRound {
+		// The client saw rounds that were rolled back while it was away.
+		return []feedMessage{{round: live.LastRound, rollback: true}}, 0, nil
+	}
+	if live.LastRound-after > maxStreamReplay {
+		return nil, http.StatusGone, fmt.Errorf("after: round %d is more than %d rounds behind, reload the metrics and resume from their last_round", after, maxStreamReplay)
+	}
+	if after == live.LastRound {
+		return nil, 0, nil
+	}
+
+	m := models.NewMetrics()
+	if after > 0 {
+		m, err = s.store.MetricsAt(ctx, after)
+		if errors.Is(err, repository.ErrRoundNotAvailable) {
+			return nil, http.StatusGone, fmt.Errorf("after: %w", err)
+		}
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+	from, to := after+1, live.LastRound
+	stats, err := s.store.QueryRounds(ctx, models.RoundFilter{FromRound: &from, ToRound: &to})
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if int64(len(stats)) != to-after {
+		return nil, http.StatusGone, fmt.Errorf("after: the rounds after round %d were committed without stats", after)
+	}
+	filter.FromRound, filter.ToRound = &from, &to
+	events, err := s.store.QueryEvents(ctx, filter)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	replay := make([]feedMessage, 0, len(stats))
+	for _, st := range stats {
+		if err := m.AddRound(st); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		msg := feedMessage{round: st.Round, metrics: m}
+		for len(events) > 0 && events[0].Round == st.Round {
+			msg.events, events = append(msg.events, events[0]), events[1:]
+		}
+		replay = append(replay, msg)
+	}
+	return replay, 0, nil
+}
+
+func parseStreamFilter(r *http.Request) (models.EventFilter, error) {
+	var f models.EventFilter
+	for name, dst := range map[string]**int64{
+		"sender":     &f.Sender,
+		"recipient":  &f.Recipient,
+		"min_amount": &f.MinAmount,
+	} {
+		v, err := optionalInt(r.URL.Query().Get(name))
+		if err != nil {
+			return f, fmt.Errorf("%s: %w", name, err)
+		}
+		*dst = v
+	}
+	return f, nil
+}
+
+// mustJSON encodes the value of a type that always encodes.
+func mustJSON(v any) string {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(raw)
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+type windowResponse struct {
+	// Window is the configured extent, a round count such as "100" or a duration such as "15m0s".
+	Window  string        `json:"window"`
+	Rounds  int64         `json:"rounds"`
+	Count   int64         `json:"count"`
+	Sum     models.Int128 `json:"sum"`
+	Average float64       `json:"average"`
+	Min     *int64        `json:"min"`
+	Max     *int64        `json:"max"`
+}
+
+type windowsResponse struct {
+	Windows []windowResponse `json:"windows"`
+}
+
+// handleWindows serves the aggregates of the sliding windows, in the configured order.
+func (s *Server) handleWindows(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.src.Windows(r.Context())
+	if err != nil {
+		s.writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	resp := windowsResponse{Windows: make([]windowResponse, 0, len(stats))}
+	for _, st := range stats {
+		win := windowResponse{
+			Window:  st.Window.String(),
+			Rounds:  st.Rounds,
+			Count:   st.Count,
+			Sum:     st.Sum,
+			Average: st.Average(),
+		}
+		if st.Count > 0 {
+			win.Min, win.Max = &st.Min, &st.Max
+		}
+		resp.Windows = append(resp.Windows, win)
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+// Cache holds the live metrics, so that reading them does not hit the repository. The ingestor
+// writes them on every commit, the API and other processes read them.
+type Cache interface {
+	// Get returns the cached metrics, reporting whether there are any that have not expired.
+	Get(ctx context.Context) (models.Metrics, bool, error)
+	// Set caches the metrics, replacing the previous ones, until the cache's TTL elapses.
+	Set(ctx context.Context, m models.Metrics) error
+	// Invalidate drops the cached metrics, so that the next reader loads them from the repository.
+	Invalidate(ctx context.Context) error
+}
+
+// Memory caches the metrics in the process. It is safe for concurrent use.
+type Memory struct {
+	ttl time.Duration
+	// now tells when the metrics expire, it is replaced in tests.
+	now       func() time.Time
+	mu        sync.RWMutex
+	metrics   *models.Metrics
+	expiresAt time.Time
+}
+
+// NewMemory returns an empty cache whose metrics expire ttl after they are set, never if ttl is 0.
+func NewMemory(ttl time.Duration) *Memory {
+	return &Memory{ttl: ttl, now: time.Now}
+}
+
+func (c *Memory) Get(context.Context) (models.Metrics, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.metrics == nil || (c.ttl > 0 && !c.now().Before(c.expiresAt)) {
+		return models.Metrics{}, false, nil
+	}
+	return *c.metrics, true, nil
+}
+
+func (c *Memory) Set(_ context.Context, m models.Metrics) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metrics, c.expiresAt = &m, c.now().Add(c.ttl)
+	return nil
+}
+
+func (c *Memory) Invalidate(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metrics = nil
+	return nil
+}
@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+func TestCache(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cache Suite")
+}
+
+// cacheContract specifies what every Cache does. open returns an empty cache with the given TTL
+// and a function moving its clock forward.
+func cacheContract(open func(ttl time.Duration) (Cache, func(time.Duration))) {
+	ctx := context.Background()
+	sum, _ := models.ParseInt128("170141183460469231731687303715884105727")
+	metrics := models.Metrics{Count: 2, Sum: sum, Min: 10, Max: 20, Mean: 15, M2: 50, LastRound: 5}
+
+	It("should miss while empty", func() {
+		c, _ := open(time.Minute)
+		_, ok, err := c.Get(ctx)
+		Expect(err).To(BeNil())
+		Expect(ok).To(BeFalse())
+	})
+	It("should return the metrics last set", func() {
+		c, _ := open(time.Minute)
+		Expect(c.Set(ctx, models.NewMetrics())).To(Succeed())
+		m, ok, err := c.Get(ctx)
+		Expect(err).To(BeNil())
+		Expect(ok).To(BeTrue())
+		Expect(m).To(Equal(models.Metrics{Min: math.MaxInt64}))
+
+		Expect(c.Set(ctx, metrics)).To(Succeed())
+		m, ok, err = c.Get(ctx)
+		Expect(err).To(BeNil())
+		Expect(ok).To(BeTrue())
+		Expect(m).To(Equal(metrics))
+	})
+	It("should miss once invalidated", func() {
+		c, _ := open(time.Minute)
+		Expect(c.Set(ctx, metrics)).To(Succeed())
+		Expect(c.Invalidate(ctx)).To(Succeed())
+		_, ok, err := c.Get(ctx)
+		Expect(err).To(BeNil())
+		Expect(ok).To(BeFalse())
+		Expect(c.Invalidate(ctx)).To(Succeed())
+	})
+	It("should expire the metrics after the TTL", func() {
+		c, advance := open(time.Minute)
+		Expect(c.Set(ctx, metrics)).To(Succeed())
+		advance(59 * time.Second)
+		_, ok, err := c.Get(ctx)
+		Expect(err).To(BeNil())
+		Expect(ok).To(BeTrue())
+
+		advance(time.Second)
+		_, ok, err = c.Get(ctx)
+		Expect(err).To(BeNil())
+		Expect(ok).To(BeFalse())
+	})
+	It("should keep the metrics without a TTL", func() {
+		c, advance := open(0)
+		Expect(c.Set(ctx, metrics)).To(Succeed())
+		advance(24 * time.Hour)
+		m, ok, err := c.Get(ctx)
+		Expect(err).To(BeNil())
+		Expect(ok).To(BeTrue())
+		Expect(m).To(Equal(metrics))
+	})
+}
+
+var _ = Describe("Memory", func() {
+	cacheContract(func(ttl time.Duration) (Cache, func(time.Duration)) {
+		c := NewMemory(ttl)
+		now := time.Now()
+		c.now = func() time.Time { return now }
+		return c, func(d time.Duration) { now = now.Add(d) }
+	})
+})
+
+var _ = Describe("Redis", func() {
+	var server *miniredis.Miniredis
+
+	BeforeEach(func() {
+		server = miniredis.RunT(GinkgoT())
+	})
+
+	open := func(ttl time.Duration) *Redis {
+		client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1, DialerRetries: 1})
+		DeferCleanup(client.Close)
+		return NewRedis(client, "metrika:metrics", ttl)
+	}
+
+	Context("as a cache", func() {
+		cacheContract(func(ttl time.Duration) (Cache, func(time.Duration)) {
+			return open(ttl), server.FastForward
+		})
+	})
+
+	It("should share the metrics with other processes", func() {
+		ctx := context.Background()
+		writer, reader := open(time.Minute), open(time.Minute)
+		Expect(writer.Set(ctx, models.Metrics{Count: 1, LastRound: 3})).To(Succeed())
+		m, ok, err := reader.Get(ctx)
+		Expect(err).To(BeNil())
+		Expect(ok).To(BeTrue())
+		Expect(m.LastRound).To(Equal(int64(3)))
+		Expect(server.TTL("metrika:metrics")).To(Equal(time.Minute))
+	})
+	It("should fail while Redis is down", func() {
+		ctx := context.Background()
+		c := open(time.Minute)
+		server.Close()
+		_, _, err := c.Get(ctx)
+		Expect(err).To(HaveOccurred())
+		Expect(c.Set(ctx, models.Metrics{})).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+// Redis caches the metrics as JSON under a key of a Redis server, where other processes can read them.
+type Redis struct {
+	client redis.UniversalClient
+	key    string
+	ttl    time.Duration
+}
+
+// NewRedis caches the metrics under key, letting Redis expire them ttl after they are set, never if ttl is 0.
+// The caller owns client and closes it.
+func NewRedis(client redis.UniversalClient, key string, ttl time.Duration) *Redis {
+	return &Redis{client: client, key: key, ttl: ttl}
+}
+
+func (c *Redis) Get(ctx context.Context) (models.Metrics, bool, error) {
+	raw, err := c.client.Get(ctx, c.key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return models.Metrics{}, false, nil
+	}
+	if err != nil {
+		return models.Metrics{}, false, err
+	}
+	var m models.Metrics
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return models.Metrics{}, false, err
+	}
+	return m, true, nil
+}
+
+func (c *Redis) Set(ctx context.Context, m models.Metrics) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return c.client.Set(ctx, c.key, raw, c.ttl).Err()
+}
+
+func (c *Redis) Invalidate(ctx context.Context) error {
+	return c.client.Del(ctx, c.key).Err()
+}
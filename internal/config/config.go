@@ -0,0 +1,452 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/rhuandantas/metrika/internal/models"
+	"go.yaml.in/yaml/v3"
+)
+
+// envPrefix is prepended to every environment variable read by Load.
+const envPrefix = "METRIKA_"
+
+// Config holds every runtime setting of the service.
+type Config struct {
+	SmartBlox SmartBlox `yaml:"smartblox" toml:"smartblox"`
+	Database  Database  `yaml:"database" toml:"database"`
+	Cache     Cache     `yaml:"cache" toml:"cache"`
+	Ingest    Ingest    `yaml:"ingest" toml:"ingest"`
+	EventLog  EventLog  `yaml:"event_log" toml:"event_log"`
+	Sinks     Sinks     `yaml:"event_sinks" toml:"event_sinks"`
+	HTTP      HTTP      `yaml:"http" toml:"http"`
+}
+
+// SmartBlox configures the upstream SmartBlox API client.
+type SmartBlox struct {
+	BaseURL string        `yaml:"base_url" toml:"base_url"`
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+	// Mock serves status and blocks from the bundled SmartBlox simulator instead of BaseURL.
+	Mock    bool    `yaml:"mock" toml:"mock"`
+	Retry   Retry   `yaml:"retry" toml:"retry"`
+	Breaker Breaker `yaml:"breaker" toml:"breaker"`
+}
+
+// Retry configures how failed SmartBlox requests are retried.
+type Retry struct {
+	MaxAttempts int           `yaml:"max_attempts" toml:"max_attempts"`
+	BaseBackoff time.Duration `yaml:"base_backoff" toml:"base_backoff"`
+	MaxBackoff  time.Duration `yaml:"max_backoff" toml:"max_backoff"`
+	Jitter      float64       `yaml:"jitter" toml:"jitter"`
+}
+
+// Breaker configures the circuit breaker that stops polling a SmartBlox node that keeps failing.
+type Breaker struct {
+	// FailureThreshold is the number of consecutive failed requests that opens the circuit, 0 disables the breaker.
+	FailureThreshold int           `yaml:"failure_threshold" toml:"failure_threshold"`
+	OpenTimeout      time.Duration `yaml:"open_timeout" toml:"open_timeout"`
+	// HalfOpenSuccesses is the number of successful probes needed to close the circuit again.
+	HalfOpenSuccesses int `yaml:"half_open_successes" toml:"half_open_successes"`
+}
+
+// Database configures the metrics repository.
+type Database struct {
+	DSN string `yaml:"dsn" toml:"dsn"`
+	// Ephemeral keeps the metrics in memory instead, they are lost when the service stops.
+	Ephemeral bool `yaml:"ephemeral" toml:"ephemeral"`
+}
+
+// Cache configures where the live metrics are cached.
+type Cache struct {
+	// RedisURL shares the cache with other processes through Redis, empty keeps it in the process.
+	RedisURL string `yaml:"redis_url" toml:"redis_url"`
+	// Key is the Redis key the metrics are cached under.
+	Key string `yaml:"key" toml:"key"`
+	// TTL is how long the cached metrics are served before they are reloaded from the repository, 0 for ever.
+	TTL time.Duration `yaml:"ttl" toml:"ttl"`
+}
+
+// Ingest configures the polling loop.
+type Ingest struct {
+	PollEvery time.Duration `yaml:"poll_every" toml:"poll_every"`
+	// Concurrency is the number of blocks fetched in parallel while catching up.
+	Concurrency int `yaml:"concurrency" toml:"concurrency"`
+	// ReorgDepth is how many of the last committed rounds are re-checked for replacement on every poll, 0 disables it.
+	ReorgDepth int `yaml:"reorg_depth" toml:"reorg_depth"`
+	// MaxAmount is the largest valid transfer amount, 0 sets no limit. Negative amounts are always invalid.
+	MaxAmount int64 `yaml:"max_amount" toml:"max_amount"`
+	// InvalidAmounts is what happens to a transfer with an invalid amount: "reject" it or "flag" it and count it anyway.
+	InvalidAmounts string `yaml:"invalid_amounts" toml:"invalid_amounts"`
+	// SnapshotInterval is how many rounds apart the metrics are snapshotted for point-in-time queries, 0 disables snapshots.
+	SnapshotInterval int64 `yaml:"snapshot_interval" toml:"snapshot_interval"`
+	// Windows lists the sliding windows aggregated next to the cumulative metrics, separated by commas:
+	// round counts such as 100 and durations such as 15m.
+	Windows string `yaml:"windows" toml:"windows"`
+}
+
+// EventLog configures the rotated transfer event log.
+type EventLog struct {
+	Path       string `yaml:"path" toml:"path"`
+	MaxAgeDays int    `yaml:"max_age_days" toml:"max_age_days"`
+	Compress   bool   `yaml:"compress" toml:"compress"`
+}
+
+// Sinks configures where the transfer events are published besides the event log.
+type Sinks struct {
+	Stdout bool `yaml:"stdout" toml:"stdout"`
+	// SQLiteDSN is a database the events are copied into, empty disables it.
+	SQLiteDSN string `yaml:"sqlite_dsn" toml:"sqlite_dsn"`
+	// WebhookURL receives each round's events as a JSON array in a POST, empty disables it.
+	WebhookURL     string        `yaml:"webhook_url" toml:"webhook_url"`
+	WebhookTimeout time.Duration `yaml:"webhook_timeout" toml:"webhook_timeout"`
+	// QueueSize is the number of rounds buffered for each sink.
+	QueueSize int `yaml:"queue_size" toml:"queue_size"`
+	// Overflow is what happens when an optional sink's queue is full: "block" ingestion or "drop" the round for
+	// that sink. The event log always blocks.
+	Overflow string `yaml:"overflow" toml:"overflow"`
+	// Attempts is the number of tries per round before a sink gives it up.
+	Attempts int `yaml:"attempts" toml:"attempts"`
+}
+
+// HTTP configures the embedded query API.
+type HTTP struct {
+	Addr string `yaml:"addr" toml:"addr"`
+	// StreamBuffer is the number of rounds buffered for each live stream client, which is dropped once it is full.
+	StreamBuffer int `yaml:"stream_buffer" toml:"stream_buffer"`
+}
+
+// Defaults returns the configuration used when nothing else is provided.
+func Defaults() Config {
+	return Config{
+		SmartBlox: SmartBlox{
+			BaseURL: "http://localhost:8080",
+			Timeout: 60 * time.Second,
+			Mock:    true,
+			Retry: Retry{
+				MaxAttempts: 4,
+				BaseBackoff: 200 * time.Millisecond,
+				MaxBackoff:  5 * time.Second,
+				Jitter:      0.2,
+			},
+			Breaker: Breaker{
+				FailureThreshold:  5,
+				OpenTimeout:       30 * time.Second,
+				HalfOpenSuccesses: 1,
+			},
+		},
+		Database: Database{
+			DSN: "file:data/db/metrika.db?cache=shared&_journal=WAL&_busy_timeout=5000",
+		},
+		Cache: Cache{
+			Key: "metrika:metrics",
+			TTL: 5 * time.Minute,
+		},
+		Ingest: Ingest{
+			PollEvery:        5 * time.Second,
+			Concurrency:      4,
+			ReorgDepth:       10,
+			InvalidAmounts:   "reject",
+			SnapshotInterval: 1000,
+			Windows:          "100,1000,15m,1h",
+		},
+		EventLog: EventLog{
+			Path:       "./data/events.log",
+			MaxAgeDays: 30,
+			Compress:   true,
+		},
+		Sinks: Sinks{
+			WebhookTimeout: 5 * time.Second,
+			QueueSize:      1024,
+			Overflow:       "block",
+			Attempts:       3,
+		},
+		HTTP: HTTP{
+			Addr:         ":8081",
+			StreamBuffer: 64,
+		},
+	}
+}
+
+// setting binds a configuration field to its command-line flag and environment variable.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	field func(c *Config) any
+}
+
+var settings = []setting{
+	{"smartblox-url", "SMARTBLOX_BASE_URL", "SmartBlox API base URL", func(c *Config) any { return &c.SmartBlox.BaseURL }},
+	{"smartblox-timeout", "SMARTBLOX_TIMEOUT", "SmartBlox HTTP request timeout", func(c *Config) any { return &c.SmartBlox.Timeout }},
+	{"smartblox-mock", "SMARTBLOX_MOCK", "use the bundled SmartBlox simulator instead of the HTTP API", func(c *Config) any { return &c.SmartBlox.Mock }},
+	{"smartblox-max-attempts", "SMARTBLOX_RETRY_MAX_ATTEMPTS", "tries per SmartBlox request, 1 disables retries", func(c *Config) any { return &c.SmartBlox.Retry.MaxAttempts }},
+	{"smartblox-base-backoff", "SMARTBLOX_RETRY_BASE_BACKOFF", "wait before the first retry, doubled on each retry", func(c *Config) any { return &c.SmartBlox.Retry.BaseBackoff }},
+	{"smartblox-max-backoff", "SMARTBLOX_RETRY_MAX_BACKOFF", "longest wait between retries", func(c *Config) any { return &c.SmartBlox.Retry.MaxBackoff }},
+	{"smartblox-jitter", "SMARTBLOX_RETRY_JITTER", "randomized fraction of each backoff, between 0 and 1", func(c *Config) any { return &c.SmartBlox.Retry.Jitter }},
+	{"smartblox-breaker-failures", "SMARTBLOX_BREAKER_FAILURE_THRESHOLD", "consecutive failed requests that open the circuit, 0 disables", func(c *Config) any { return &c.SmartBlox.Breaker.FailureThreshold }},
+	{"smartblox-breaker-open-timeout", "SMARTBLOX_BREAKER_OPEN_TIMEOUT", "wait before probing an open circuit", func(c *Config) any { return &c.SmartBlox.Breaker.OpenTimeout }},
+	{"smartblox-breaker-half-open-successes", "SMARTBLOX_BREAKER_HALF_OPEN_SUCCESSES", "successful probes that close the circuit", func(c *Config) any { return &c.SmartBlox.Breaker.HalfOpenSuccesses }},
+	{"db-dsn", "DATABASE_DSN", "metrics repository DSN, a postgres:// URL, a bolt:// file path or a SQLite DSN", func(c *Config) any { return &c.Database.DSN }},
+	{"ephemeral", "DATABASE_EPHEMERAL", "keep the metrics in memory, lost on exit, instead of the database", func(c *Config) any { return &c.Database.Ephemeral }},
+	{"cache-redis-url", "CACHE_REDIS_URL", "share the live metrics cache through this Redis URL, such as redis://localhost:6379/0", func(c *Config) any { return &c.Cache.RedisURL }},
+	{"cache-key", "CACHE_KEY", "Redis key the live metrics are cached under", func(c *Config) any { return &c.Cache.Key }},
+	{"cache-ttl", "CACHE_TTL", "how long the cached metrics are served before a reload, 0 for ever", func(c *Config) any { return &c.Cache.TTL }},
+	{"poll-every", "INGEST_POLL_EVERY", "interval between SmartBlox polls", func(c *Config) any { return &c.Ingest.PollEvery }},
+	{"concurrency", "INGEST_CONCURRENCY", "blocks fetched in parallel while catching up", func(c *Config) any { return &c.Ingest.Concurrency }},
+	{"reorg-depth", "INGEST_REORG_DEPTH", "committed rounds re-checked for chain reorganizations, 0 disables", func(c *Config) any { return &c.Ingest.ReorgDepth }},
+	{"max-amount", "INGEST_MAX_AMOUNT", "largest valid transfer amount, 0 for no limit", func(c *Config) any { return &c.Ingest.MaxAmount }},
+	{"invalid-amounts", "INGEST_INVALID_AMOUNTS", "what to do with invalid amounts: reject or flag", func(c *Config) any { return &c.Ingest.InvalidAmounts }},
+	{"windows", "INGEST_WINDOWS", "sliding windows, comma-separated round counts and durations such as 100,15m", func(c *Config) any { return &c.Ingest.Windows }},
+	{"snapshot-interval", "INGEST_SNAPSHOT_INTERVAL", "rounds between metrics snapshots for point-in-time queries, 0 disables", func(c *Config) any { return &c.Ingest.SnapshotInterval }},
+	{"event-log-path", "EVENT_LOG_PATH", "transfer event log file", func(c *Config) any { return &c.EventLog.Path }},
+	{"event-log-max-age", "EVENT_LOG_MAX_AGE_DAYS", "days to keep rotated event logs", func(c *Config) any { return &c.EventLog.MaxAgeDays }},
+	{"event-log-compress", "EVENT_LOG_COMPRESS", "gzip rotated event logs", func(c *Config) any { return &c.EventLog.Compress }},
+	{"event-sink-stdout", "EVENT_SINKS_STDOUT", "also publish events to the standard output", func(c *Config) any { return &c.Sinks.Stdout }},
+	{"event-sink-sqlite-dsn", "EVENT_SINKS_SQLITE_DSN", "also copy events into this SQLite database", func(c *Config) any { return &c.Sinks.SQLiteDSN }},
+	{"event-sink-webhook-url", "EVENT_SINKS_WEBHOOK_URL", "also POST events to this URL", func(c *Config) any { return &c.Sinks.WebhookURL }},
+	{"event-sink-webhook-timeout", "EVENT_SINKS_WEBHOOK_TIMEOUT", "webhook request timeout", func(c *Config) any { return &c.Sinks.WebhookTimeout }},
+	{"event-sink-queue-size", "EVENT_SINKS_QUEUE_SIZE", "rounds buffered for each event sink", func(c *Config) any { return &c.Sinks.QueueSize }},
+	{"event-sink-overflow", "EVENT_SINKS_OVERFLOW", "when an optional sink's queue is full: block or drop, the event log always blocks", func(c *Config) any { return &c.Sinks.Overflow }},
+	{"event-sink-attempts", "EVENT_SINKS_ATTEMPTS", "tries per round before a sink gives it up", func(c *Config) any { return &c.Sinks.Attempts }},
+	{"http-addr", "HTTP_ADDR", "listen address of the query API", func(c *Config) any { return &c.HTTP.Addr }},
+	{"http-stream-buffer", "HTTP_STREAM_BUFFER", "rounds buffered for each live stream client before it is dropped", func(c *Config) any { return &c.HTTP.StreamBuffer }},
+}
+
+// Load resolves the configuration from defaults, an optional YAML or TOML file,
+// METRIKA_* environment variables and command-line flags, each layer overriding the previous one.
+// The config file is taken from the -config flag or METRIKA_CONFIG.
+// lookupEnv reads the environment like os.LookupEnv, so a variable set empty clears the file's value.
+// Flags are registered on fs, so callers may add their own before calling Load.
+func Load(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg := Defaults()
+
+	envConfigPath, _ := lookupEnv(envPrefix + "CONFIG")
+	configPath := fs.String("config", envConfigPath, "path to a YAML or TOML config file (env "+envPrefix+"CONFIG)")
+	flagValues := make(map[string]string)
+	for _, s := range settings {
+		name := s.flag
+		usage := fmt.Sprintf("%s (env %s%s)", s.usage, envPrefix, s.env)
+		record := func(v string) error {
+			flagValues[name] = v
+			return nil
+		}
+		// Boolean flags can be given without a value, like -smartblox-mock.
+		if _, ok := s.field(&cfg).(*bool); ok {
+			fs.BoolFunc(name, usage, record)
+		} else {
+			fs.Func(name, usage, record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if *configPath != "" {
+		if err := loadFile(*configPath, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := lookupEnv(envPrefix + s.env); ok {
+			if err := set(s.field(&cfg), v); err != nil {
+				return Config{}, fmt.Errorf("env %s%s: %w", envPrefix, s.env, err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := flagValues[s.flag]; ok {
+			if err := set(s.field(&cfg), v); err != nil {
+				return Config{}, fmt.Errorf("flag -%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+	var errs []error
+	if !c.SmartBlox.Mock {
+		u, err := url.Parse(c.SmartBlox.BaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("smartblox.base_url: %q is not an absolute http(s) URL", c.SmartBlox.BaseURL))
+		}
+	}
+	if c.SmartBlox.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("smartblox.timeout: must be positive, got %s", c.SmartBlox.Timeout))
+	}
+	if r := c.SmartBlox.Retry; r.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("smartblox.retry.max_attempts: must be at least 1, got %d", r.MaxAttempts))
+	} else if r.MaxAttempts > 1 && (r.BaseBackoff <= 0 || r.MaxBackoff < r.BaseBackoff) {
+		errs = append(errs, fmt.Errorf("smartblox.retry: backoffs must satisfy 0 < base_backoff <= max_backoff, got %s and %s", r.BaseBackoff, r.MaxBackoff))
+	}
+	if j := c.SmartBlox.Retry.Jitter; j < 0 || j > 1 {
+		errs = append(errs, fmt.Errorf("smartblox.retry.jitter: must be between 0 and 1, got %g", j))
+	}
+	if b := c.SmartBlox.Breaker; b.FailureThreshold < 0 {
+		errs = append(errs, fmt.Errorf("smartblox.breaker.failure_threshold: must not be negative, got %d", b.FailureThreshold))
+	} else if b.FailureThreshold > 0 {
+		if b.OpenTimeout <= 0 {
+			errs = append(errs, fmt.Errorf("smartblox.breaker.open_timeout: must be positive, got %s", b.OpenTimeout))
+		}
+		if b.HalfOpenSuccesses < 1 {
+			errs = append(errs, fmt.Errorf("smartblox.breaker.half_open_successes: must be at least 1, got %d", b.HalfOpenSuccesses))
+		}
+	}
+	if c.Database.DSN == "" && !c.Database.Ephemeral {
+		errs = append(errs, errors.New("database.dsn: must not be empty"))
+	}
+	if ch := c.Cache; ch.RedisURL != "" {
+		u, err := url.Parse(ch.RedisURL)
+		if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("cache.redis_url: %q is not a redis:// or rediss:// URL", ch.RedisURL))
+		}
+		if ch.Key == "" {
+			errs = append(errs, errors.New("cache.key: must not be empty"))
+		}
+	}
+	if c.Cache.TTL < 0 {
+		errs = append(errs, fmt.Errorf("cache.ttl: must not be negative, got %s", c.Cache.TTL))
+	}
+	if c.Ingest.PollEvery <= 0 {
+		errs = append(errs, fmt.Errorf("ingest.poll_every: must be positive, got %s", c.Ingest.PollEvery))
+	}
+	if c.Ingest.Concurrency < 1 {
+		errs = append(errs, fmt.Errorf("ingest.concurrency: must be at least 1, got %d", c.Ingest.Concurrency))
+	}
+	if c.Ingest.ReorgDepth < 0 {
+		errs = append(errs, fmt.Errorf("ingest.reorg_depth: must not be negative, got %d", c.Ingest.ReorgDepth))
+	}
+	if c.Ingest.MaxAmount < 0 {
+		errs = append(errs, fmt.Errorf("ingest.max_amount: must not be negative, got %d", c.Ingest.MaxAmount))
+	}
+	if _, err := models.ParseWindowSpecs(c.Ingest.Windows); err != nil {
+		errs = append(errs, fmt.Errorf("ingest.windows: %w", err))
+	}
+	if c.Ingest.SnapshotInterval < 0 {
+		errs = append(errs, fmt.Errorf("ingest.snapshot_interval: must not be negative, got %d", c.Ingest.SnapshotInterval))
+	}
+	if p := c.Ingest.InvalidAmounts; p != "reject" && p != "flag" {
+		errs = append(errs, fmt.Errorf("ingest.invalid_amounts: must be reject or flag, got %q", p))
+	}
+	if c.EventLog.Path == "" {
+		errs = append(errs, errors.New("event_log.path: must not be empty"))
+	}
+	if c.EventLog.MaxAgeDays < 0 {
+		errs = append(errs, fmt.Errorf("event_log.max_age_days: must not be negative, got %d", c.EventLog.MaxAgeDays))
+	}
+	if sk := c.Sinks; sk.WebhookURL != "" {
+		u, err := url.Parse(sk.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("event_sinks.webhook_url: %q is not an absolute http(s) URL", sk.WebhookURL))
+		}
+		if sk.WebhookTimeout <= 0 {
+			errs = append(errs, fmt.Errorf("event_sinks.webhook_timeout: must be positive, got %s", sk.WebhookTimeout))
+		}
+	}
+	if c.Sinks.QueueSize < 0 {
+		errs = append(errs, fmt.Errorf("event_sinks.queue_size: must not be negative, got %d", c.Sinks.QueueSize))
+	}
+	if o := c.Sinks.Overflow; o != "block" && o != "drop" {
+		errs = append(errs, fmt.Errorf("event_sinks.overflow: must be block or drop, got %q", o))
+	}
+	if c.Sinks.Attempts < 1 {
+		errs = append(errs, fmt.Errorf("event_sinks.attempts: must be at least 1, got %d", c.Sinks.Attempts))
+	}
+	if c.HTTP.Addr == "" {
+		errs = append(errs, errors.New("http.addr: must not be empty"))
+	}
+	if c.HTTP.StreamBuffer < 1 {
+		errs = append(errs, fmt.Errorf("http.stream_buffer: must be at least 1, got %d", c.HTTP.StreamBuffer))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// loadFile decodes a YAML or TOML file, chosen by extension, on top of cfg.
+// Unknown keys are rejected so typos do not silently fall back to defaults.
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("parsing config file %s: unknown key %q", path, undecoded[0].String())
+		}
+	default:
+		return fmt.Errorf("config file %s: unsupported extension %q, use .yaml, .yml or .toml", path, ext)
+	}
+	return nil
+}
+
+// set parses raw into the field pointed to by ptr.
+func set(ptr any, raw string) error {
+	switch p := ptr.(type) {
+	case *string:
+		*p = raw
+	case *bool:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		*p = v
+	case *int:
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		*p = v
+	case *int64:
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		*p = v
+	case *float64:
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		*p = v
+	case *time.Duration:
+		v, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		*p = v
+	default:
+		return fmt.Errorf("unsupported setting type %T", ptr)
+	}
+	return nil
+}
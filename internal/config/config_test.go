@@ -0,0 +1,153 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestConfig(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Config Suite")
+}
+
+var _ = Describe("Load", func() {
+	var (
+		fs  *flag.FlagSet
+		env map[string]string
+		dir string
+	)
+
+	lookupEnv := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+		return path
+	}
+
+	BeforeEach(func() {
+		fs = flag.NewFlagSet("metrika", flag.ContinueOnError)
+		fs.SetOutput(GinkgoWriter)
+		env = map[string]string{}
+		dir = GinkgoT().TempDir()
+	})
+
+	It("should return the defaults when nothing is set", func() {
+		cfg, err := Load(fs, nil, lookupEnv)
+		Expect(err).To(BeNil())
+		Expect(cfg).To(Equal(Defaults()))
+	})
+	It("should layer file, env vars and flags in order of precedence", func() {
+		path := writeFile("metrika.yaml", `
+smartblox:
+  base_url: http://file:8080
+  timeout: 10s
+ingest:
+  poll_every: 1s
+`)
+		env["METRIKA_CONFIG"] = path
+		env["METRIKA_INGEST_POLL_EVERY"] = "3s"
+		env["METRIKA_SMARTBLOX_BASE_URL"] = "http://env:8080"
+
+		cfg, err := Load(fs, []string{"-smartblox-url", "http://flag:8080"}, lookupEnv)
+		Expect(err).To(BeNil())
+		Expect(cfg.SmartBlox.BaseURL).To(Equal("http://flag:8080"))
+		Expect(cfg.SmartBlox.Timeout).To(Equal(10 * time.Second))
+		Expect(cfg.Ingest.PollEvery).To(Equal(3 * time.Second))
+		Expect(cfg.Database.DSN).To(Equal(Defaults().Database.DSN))
+	})
+	It("should let an env var set empty clear the file's value", func() {
+		path := writeFile("metrika.yaml", `
+event_sinks:
+  webhook_url: http://hooks:8080/events
+`)
+		env["METRIKA_CONFIG"] = path
+		env["METRIKA_EVENT_SINKS_WEBHOOK_URL"] = ""
+
+		cfg, err := Load(fs, nil, lookupEnv)
+		Expect(err).To(BeNil())
+		Expect(cfg.Sinks.WebhookURL).To(BeEmpty())
+
+		delete(env, "METRIKA_EVENT_SINKS_WEBHOOK_URL")
+		cfg, err = Load(flag.NewFlagSet("test", flag.ContinueOnError), nil, lookupEnv)
+		Expect(err).To(BeNil())
+		Expect(cfg.Sinks.WebhookURL).To(Equal("http://hooks:8080/events"))
+	})
+	It("should read TOML files", func() {
+		path := writeFile("metrika.toml", `
+[database]
+dsn = "file:other.db"
+
+[event_log]
+compress = false
+`)
+		cfg, err := Load(fs, []string{"-config", path}, lookupEnv)
+		Expect(err).To(BeNil())
+		Expect(cfg.Database.DSN).To(Equal("file:other.db"))
+		Expect(cfg.EventLog.Compress).To(BeFalse())
+	})
+	It("should reject unknown keys in the config file", func() {
+		path := writeFile("metrika.yaml", "ingest:\n  pool_every: 1s\n")
+		_, err := Load(fs, []string{"-config", path}, lookupEnv)
+		Expect(err).To(MatchError(ContainSubstring("pool_every")))
+	})
+	It("should name the env var holding a malformed value", func() {
+		env["METRIKA_SMARTBLOX_TIMEOUT"] = "soon"
+		_, err := Load(fs, nil, lookupEnv)
+		Expect(err).To(MatchError(ContainSubstring("METRIKA_SMARTBLOX_TIMEOUT")))
+	})
+	It("should report every invalid setting", func() {
+		_, err := Load(fs, []string{"-smartblox-mock=false", "-smartblox-url", "localhost", "-poll-every", "0s", "-db-dsn", ""}, lookupEnv)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("smartblox.base_url"))
+		Expect(err.Error()).To(ContainSubstring("ingest.poll_every"))
+		Expect(err.Error()).To(ContainSubstring("database.dsn"))
+	})
+	It("should report an invalid cache", func() {
+		_, err := Load(fs, []string{"-cache-redis-url", "localhost:6379", "-cache-key", "", "-cache-ttl", "-1s"}, lookupEnv)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("cache.redis_url"))
+		Expect(err.Error()).To(ContainSubstring("cache.key"))
+		Expect(err.Error()).To(ContainSubstring("cache.ttl"))
+
+		cfg, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-cache-redis-url", "redis://localhost:6379/0"}, lookupEnv)
+		Expect(err).To(BeNil())
+		Expect(cfg.Cache).To(Equal(Cache{RedisURL: "redis://localhost:6379/0", Key: "metrika:metrics", TTL: 5 * time.Minute}))
+	})
+	It("should not need a DSN in ephemeral mode", func() {
+		cfg, err := Load(fs, []string{"-ephemeral", "-db-dsn", ""}, lookupEnv)
+		Expect(err).To(BeNil())
+		Expect(cfg.Database.Ephemeral).To(BeTrue())
+	})
+	It("should accept boolean flags without a value", func() {
+		cfg, err := Load(fs, []string{"-event-sink-stdout", "-smartblox-mock=false", "-smartblox-url", "http://node:8080"}, lookupEnv)
+		Expect(err).To(BeNil())
+		Expect(cfg.Sinks.Stdout).To(BeTrue())
+		Expect(cfg.SmartBlox.Mock).To(BeFalse())
+	})
+	It("should reject an unknown event sink overflow policy", func() {
+		_, err := Load(fs, []string{"-event-sink-overflow", "spill"}, lookupEnv)
+		Expect(err).To(MatchError(ContainSubstring("event_sinks.overflow")))
+	})
+	It("should need a stream buffer of at least one round", func() {
+		_, err := Load(fs, []string{"-http-stream-buffer", "0"}, lookupEnv)
+		Expect(err).To(MatchError(ContainSubstring("http.stream_buffer")))
+	})
+	It("should check the breaker timings when the breaker is enabled", func() {
+		_, err := Load(fs, []string{"-smartblox-breaker-open-timeout", "0s"}, lookupEnv)
+		Expect(err).To(MatchError(ContainSubstring("smartblox.breaker.open_timeout")))
+	})
+	It("should ignore the breaker timings when the breaker is disabled", func() {
+		_, err := Load(fs, []string{"-smartblox-breaker-failures", "0", "-smartblox-breaker-open-timeout", "0s"}, lookupEnv)
+		Expect(err).To(BeNil())
+	})
+})
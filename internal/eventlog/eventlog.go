@@ -0,0 +1,189 @@
+package eventlog
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+// backupTimeFormat is the timestamp lumberjack puts in the name of rotated files.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// Files returns the rotated backups of the event log at path, oldest first, followed by the log
+// itself. Backups are named <name>-<timestamp><ext>, optionally gzipped, next to the log.
+func Files(path string) ([]string, error) {
+	dir, base := filepath.Split(path)
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+	if dir == "" {
+		dir = "."
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type backup struct {
+		path string
+		at   time.Time
+	}
+	var backups []backup
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ext)
+		at, err := time.Parse(backupTimeFormat, strings.TrimPrefix(stamp, prefix))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{path: filepath.Join(dir, name), at: at})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].at.Before(backups[j].at) })
+
+	files := make([]string, 0, len(backups)+1)
+	for _, b := range backups {
+		files = append(files, b.path)
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return files, nil
+}
+
+// line is a single entry written by the ingestor's event logger: a JSON array of the round's events,
+// or an empty one with the checkpoint left by a rollback.
+type line struct {
+	Message      string `json:"message"`
+	RolledBackTo *int64 `json:"rolled_back_to"`
+}
+
+// Batch is an entry of the event log: the events of a committed round, or a rollback marker.
+type Batch struct {
+	Events []models.Event
+	// RolledBack is set on rollback markers, the rounds after LastRound were rolled back.
+	RolledBack bool
+	LastRound  int64
+}
+
+// Scanner reads event batches from the log files in order, decompressing gzipped rotations.
+// Lines that cannot be decoded are skipped and reported by Malformed.
+type Scanner struct {
+	files     []string
+	file      *os.File
+	gz        *gzip.Reader
+	lines     *bufio.Scanner
+	name      string
+	lineNo    int
+	malformed []string
+}
+
+func NewScanner(files []string) *Scanner {
+	return &Scanner{files: files}
+}
+
+// Next returns the next rollback marker or non-empty batch of events, or io.EOF once every file is read.
+func (s *Scanner) Next() (Batch, error) {
+	for {
+		if s.lines == nil {
+			if len(s.files) == 0 {
+				return Batch{}, io.EOF
+			}
+			if err := s.open(s.files[0]); err != nil {
+				return Batch{}, err
+			}
+			s.files = s.files[1:]
+		}
+
+		if !s.lines.Scan() {
+			if err := s.lines.Err(); err != nil {
+				return Batch{}, fmt.Errorf("%s: %w", s.name, err)
+			}
+			if err := s.closeFile(); err != nil {
+				return Batch{}, err
+			}
+			continue
+		}
+		s.lineNo++
+
+		raw := s.lines.Bytes()
+		if len(bytes.TrimSpace(raw)) == 0 {
+			continue
+		}
+		var l line
+		var events []models.Event
+		if err := json.Unmarshal(raw, &l); err != nil {
+			s.malformed = append(s.malformed, fmt.Sprintf("%s:%d: %v", s.name, s.lineNo, err))
+			continue
+		}
+		if l.RolledBackTo != nil {
+			return Batch{RolledBack: true, LastRound: *l.RolledBackTo}, nil
+		}
+		if err := json.Unmarshal([]byte(l.Message), &events); err != nil {
+			s.malformed = append(s.malformed, fmt.Sprintf("%s:%d: %v", s.name, s.lineNo, err))
+			continue
+		}
+		if len(events) > 0 {
+			return Batch{Events: events}, nil
+		}
+	}
+}
+
+// Malformed lists the skipped lines as file:line: reason.
+func (s *Scanner) Malformed() []string {
+	return s.malformed
+}
+
+// Close releases the file being read, if any.
+func (s *Scanner) Close() error {
+	return s.closeFile()
+}
+
+func (s *Scanner) open(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		s.gz, r = gz, gz
+	}
+	s.file, s.name, s.lineNo = f, path, 0
+	s.lines = bufio.NewScanner(r)
+	// A busy round is a single line, allow it to be much longer than the default 64KiB.
+	s.lines.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	return nil
+}
+
+func (s *Scanner) closeFile() error {
+	if s.file == nil {
+		return nil
+	}
+	var err error
+	if s.gz != nil {
+		err = s.gz.Close()
+	}
+	err = errors.Join(err, s.file.Close())
+	s.file, s.gz, s.lines = nil, nil, nil
+	return err
+}
@@ -0,0 +1,169 @@
+package eventlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rhuandantas/metrika/internal/models"
+	"github.com/rs/zerolog"
+)
+
+func TestEventLog(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "EventLog Suite")
+}
+
+// logLines renders batches the way the ingestor's event logger writes them.
+func logLines(batches ...[]models.Event) []byte {
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf)
+	for _, events := range batches {
+		marshal, _ := json.Marshal(events)
+		logger.Println(string(marshal))
+	}
+	return buf.Bytes()
+}
+
+// rollbackLine renders a rollback marker the way the ingestor's event logger writes it.
+func rollbackLine(lastRound int64) []byte {
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf)
+	logger.Log().Int64("rolled_back_to", lastRound).Msg("[]")
+	return buf.Bytes()
+}
+
+func batch(round int64, amounts ...int64) []models.Event {
+	events := make([]models.Event, 0, len(amounts))
+	for i, a := range amounts {
+		events = append(events, models.Event{Round: round, Sig: string(rune('a' + i)), Amount: a})
+	}
+	return events
+}
+
+// metricsOf returns the metrics of the amounts applied in order, up to lastRound.
+func metricsOf(lastRound int64, amounts ...int64) models.Metrics {
+	m := models.NewMetrics()
+	for _, a := range amounts {
+		m.Update(a, lastRound)
+	}
+	return m
+}
+
+var _ = Describe("Event log", func() {
+	var dir string
+
+	write := func(name string, content []byte) {
+		Expect(os.WriteFile(filepath.Join(dir, name), content, 0o600)).To(Succeed())
+	}
+	writeGz := func(name string, content []byte) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write(content)
+		Expect(err).To(BeNil())
+		Expect(gz.Close()).To(Succeed())
+		write(name, buf.Bytes())
+	}
+	rebuild := func(files []string, upTo int64, depth int) Result {
+		sc := NewScanner(files)
+		defer sc.Close()
+		res, err := Rebuild(sc, upTo, depth)
+		Expect(err).To(BeNil())
+		return res
+	}
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+	})
+
+	It("should list rotations oldest first, then the current log", func() {
+		write("events-2024-03-01T10-00-00.000.log.gz", nil)
+		write("events-2024-01-15T08-30-00.000.log", nil)
+		write("events-2024-02-01T00-00-00.000.log.gz", nil)
+		write("events.log", nil)
+		write("other.log", nil)
+		write("events-notatime.log", nil)
+
+		files, err := Files(filepath.Join(dir, "events.log"))
+		Expect(err).To(BeNil())
+		Expect(files).To(Equal([]string{
+			filepath.Join(dir, "events-2024-01-15T08-30-00.000.log"),
+			filepath.Join(dir, "events-2024-02-01T00-00-00.000.log.gz"),
+			filepath.Join(dir, "events-2024-03-01T10-00-00.000.log.gz"),
+			filepath.Join(dir, "events.log"),
+		}))
+	})
+	It("should read gzipped rotations and skip malformed lines", func() {
+		writeGz("events-2024-01-01T00-00-00.000.log.gz", logLines(batch(1, 10), batch(2, 20)))
+		write("events.log", append([]byte("not json\n"), logLines(batch(3, 30, 40))...))
+
+		files, err := Files(filepath.Join(dir, "events.log"))
+		Expect(err).To(BeNil())
+		sc := NewScanner(files)
+		defer sc.Close()
+
+		var rounds []int64
+		for {
+			b, err := sc.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			Expect(err).To(BeNil())
+			rounds = append(rounds, b.Events[0].Round)
+		}
+		Expect(rounds).To(Equal([]int64{1, 2, 3}))
+		Expect(sc.Malformed()).To(ConsistOf(ContainSubstring("events.log:1")))
+	})
+	It("should recompute the metrics up to the checkpoint", func() {
+		write("events.log", logLines(batch(1, 10), batch(2, 5, 50), batch(9, 1000)))
+
+		res := rebuild([]string{filepath.Join(dir, "events.log")}, 5, 10)
+		Expect(res.Metrics).To(Equal(metricsOf(2, 10, 5, 50)))
+		Expect(res.Rounds).To(Equal(2))
+		Expect(res.Beyond).To(Equal(1))
+	})
+	It("should let re-ingested rounds replace the ones a reorganization rolled back", func() {
+		// Rounds 3 and 4 were rolled back, then round 3 was re-ingested with other transfers.
+		write("events.log", logLines(batch(1, 10), batch(3, 30), batch(4, 40), batch(3, 33), batch(5, 50)))
+
+		res := rebuild([]string{filepath.Join(dir, "events.log")}, 5, 3)
+		Expect(res.Metrics).To(Equal(metricsOf(5, 10, 33, 50)))
+		Expect(res.Superseded).To(Equal(2))
+	})
+	It("should drop a rolled-back round re-ingested empty", func() {
+		// Rounds 10 to 12 were rolled back and re-ingested without transfers, only round 13 has some.
+		var log []byte
+		log = append(log, logLines(batch(1, 10), batch(10, 100), batch(11, 110), batch(12, 120))...)
+		log = append(log, rollbackLine(9)...)
+		log = append(log, logLines(batch(13, 130))...)
+		write("events.log", log)
+
+		res := rebuild([]string{filepath.Join(dir, "events.log")}, 13, 5)
+		Expect(res.Metrics).To(Equal(metricsOf(13, 10, 130)))
+		Expect(res.Rounds).To(Equal(2))
+		Expect(res.Superseded).To(Equal(3))
+	})
+	It("should not reconcile batches older than the reorganization window", func() {
+		write("events.log", logLines(batch(1, 10), batch(5, 50), batch(1, 99)))
+
+		res := rebuild([]string{filepath.Join(dir, "events.log")}, 5, 2)
+		Expect(res.Metrics.Sum).To(Equal(models.NewInt128(60)))
+		Expect(res.Stale).To(Equal(1))
+	})
+	It("should report the aggregates that differ", func() {
+		stored := models.Metrics{Count: 3, Sum: models.NewInt128(60), Min: 10, Max: 30, LastRound: 8}
+		rebuilt := models.Metrics{Count: 3, Sum: models.NewInt128(65), Min: 10, Max: 35, LastRound: 8}
+		Expect(Compare(stored, stored)).To(BeEmpty())
+		Expect(Compare(stored, rebuilt)).To(Equal([]Discrepancy{
+			{Field: "sum", Stored: "60", Rebuilt: "65"},
+			{Field: "max", Stored: "30", Rebuilt: "35"},
+		}))
+	})
+})
@@ -0,0 +1,140 @@
+package eventlog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+// Result is the outcome of replaying the event log.
+type Result struct {
+	// Metrics is recomputed from the replayed events. LastRound is the last round that had transfers.
+	Metrics models.Metrics
+	// Rounds is the number of rounds whose events were counted.
+	Rounds int
+	// Superseded counts the batches dropped because a reorganization rolled their round back.
+	Superseded int
+	// Stale counts the batches for a round, and the rollbacks to a round, older than the reorganization
+	// window, which could not be reconciled.
+	Stale int
+	// Beyond counts the batches after the upTo round, which were ignored.
+	Beyond int
+}
+
+// Rebuild replays the batches read by sc and recomputes the metrics from scratch, counting rounds
+// up to upTo only. The ingestor logs every committed round with transfers, in commit order. When a
+// reorganization rolls rounds back, their old batches stay in the log, followed by a rollback marker
+// and the re-ingested rounds. So a marker drops every batch after its round, and a batch for a round
+// at or below one already seen replaces every batch from that round on, for logs written before the
+// markers. Rollbacks never go deeper than depth rounds, so only the last depth rounds are kept
+// pending and older ones are folded into the metrics as the replay moves on.
+func Rebuild(sc *Scanner, upTo int64, depth int) (Result, error) {
+	res := Result{Metrics: models.NewMetrics()}
+	var (
+		pending  [][]models.Event
+		foldedTo int64
+	)
+	fold := func(events []models.Event) error {
+		for _, e := range events {
+			if err := res.Metrics.Update(e.Amount, e.Round); err != nil {
+				return fmt.Errorf("round %d: %w", e.Round, err)
+			}
+		}
+		res.Rounds++
+		foldedTo = events[0].Round
+		return nil
+	}
+	// drop removes the pending rounds after last.
+	drop := func(last int64) {
+		keep := len(pending)
+		for keep > 0 && pending[keep-1][0].Round > last {
+			keep--
+		}
+		res.Superseded += len(pending) - keep
+		pending = pending[:keep]
+	}
+
+	for {
+		batch, err := sc.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Result{}, err
+		}
+		if batch.RolledBack {
+			if batch.LastRound < foldedTo {
+				res.Stale++
+			}
+			drop(batch.LastRound)
+			continue
+		}
+		events := batch.Events
+		round := events[0].Round
+		for _, e := range events[1:] {
+			if e.Round != round {
+				return Result{}, fmt.Errorf("batch mixes rounds %d and %d", round, e.Round)
+			}
+		}
+
+		switch {
+		case round > upTo:
+			res.Beyond++
+			continue
+		case round <= foldedTo:
+			res.Stale++
+			continue
+		}
+
+		// Drop the pending rounds this batch rolled back.
+		drop(round - 1)
+		pending = append(pending, events)
+
+		for len(pending) > 0 && pending[0][0].Round <= round-int64(depth) {
+			if err := fold(pending[0]); err != nil {
+				return Result{}, err
+			}
+			pending = pending[1:]
+		}
+	}
+
+	for _, events := range pending {
+		if err := fold(events); err != nil {
+			return Result{}, err
+		}
+	}
+	return res, nil
+}
+
+// Discrepancy is a metrics field whose stored value differs from the rebuilt one.
+type Discrepancy struct {
+	Field   string
+	Stored  string
+	Rebuilt string
+}
+
+func (d Discrepancy) String() string {
+	return fmt.Sprintf("%s: stored %s, rebuilt %s", d.Field, d.Stored, d.Rebuilt)
+}
+
+// Compare lists the aggregates that differ between the stored and the rebuilt metrics. The last
+// round is not compared, the log has no trace of rounds without transfers.
+func Compare(stored, rebuilt models.Metrics) []Discrepancy {
+	var diffs []Discrepancy
+	for _, f := range []struct {
+		name            string
+		stored, rebuilt string
+	}{
+		{"count", fmt.Sprint(stored.Count), fmt.Sprint(rebuilt.Count)},
+		{"sum", stored.Sum.String(), rebuilt.Sum.String()},
+		{"min", fmt.Sprint(stored.Min), fmt.Sprint(rebuilt.Min)},
+		{"max", fmt.Sprint(stored.Max), fmt.Sprint(rebuilt.Max)},
+	} {
+		if f.stored != f.rebuilt {
+			diffs = append(diffs, Discrepancy{Field: f.name, Stored: f.stored, Rebuilt: f.rebuilt})
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,65 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+// backfillProgressEvery is how often Backfill logs its progress.
+const backfillProgressEvery = 5 * time.Second
+
+// Backfill re-ingests the scope's round range with the same logic as the live loop, committing each
+// round into the scope instead of the live metrics. It resumes after the scope's last applied round,
+// so an interrupted backfill can be started again. Events are neither stored nor published, the
+// live loop owns them. A sig repeated in a round is counted once, like live, but a sig already
+// counted in an earlier round is only left out live, where the stored events tell. It returns the
+// scope as of the last committed round.
+func (i *Ingestor) Backfill(ctx context.Context, scope models.Scope) (models.Scope, error) {
+	from := max(scope.Metrics.LastRound+1, scope.FromRound)
+	total := scope.ToRound - scope.FromRound + 1
+	if from > scope.ToRound {
+		i.logger.Info().Msgf("Backfill %q already covers rounds %d to %d", scope.Name, scope.FromRound, scope.ToRound)
+		return scope, nil
+	}
+	i.logger.Info().Msgf("Backfilling rounds %d to %d into scope %q", from, scope.ToRound, scope.Name)
+
+	start := time.Now()
+	lastReport := start
+	blocks, stop := i.prefetch(ctx, from, scope.ToRound)
+	defer stop()
+
+	for f := range blocks {
+		if f.err != nil {
+			i.logger.Error().Msgf("Error getting block %d (%s): %v", f.round, failureKind(f.err), f.err)
+			return scope, fmt.Errorf("backfill round %d: %w", f.round, f.err)
+		}
+
+		commit, err := i.buildCommit(f.round, f.block, scope.Metrics, nil)
+		if err != nil {
+			i.logger.Error().Msgf("Error applying round %d: %v", f.round, err)
+			return scope, fmt.Errorf("backfill round %d: %w", f.round, err)
+		}
+		if err := i.repo.CommitScopeRound(ctx, scope.Name, commit); err != nil {
+			i.logger.Error().Msgf("Error committing round %d to scope %q: %v", f.round, scope.Name, err)
+			return scope, fmt.Errorf("backfill round %d: %w", f.round, err)
+		}
+		scope.Metrics = commit.Metrics
+		if scope.Distribution != nil {
+			for _, e := range commit.Events {
+				scope.Distribution.Add(e.Amount)
+			}
+		}
+
+		if now := time.Now(); now.Sub(lastReport) >= backfillProgressEvery || scope.Done() {
+			lastReport = now
+			done := f.round - scope.FromRound + 1
+			rate := float64(f.round-from+1) / now.Sub(start).Seconds()
+			i.logger.Info().Msgf("Backfill %q: round %d, %d/%d rounds (%.1f%%), %.1f rounds/s, %d transfers so far",
+				scope.Name, f.round, done, total, 100*float64(done)/float64(total), rate, scope.Metrics.Count)
+		}
+	}
+	return scope, ctx.Err()
+}
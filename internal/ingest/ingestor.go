@@ -2,66 +2,201 @@ package ingest
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"sync"
 	"time"
 
+	"github.com/rhuandantas/metrika/internal/cache"
 	"github.com/rhuandantas/metrika/internal/models"
 	"github.com/rhuandantas/metrika/internal/repository"
+	"github.com/rhuandantas/metrika/internal/sink"
 	"github.com/rhuandantas/metrika/internal/smartblox"
 	"github.com/rs/zerolog"
 )
 
 const transactionType = "txfer"
 
+// DefaultCacheTTL is how long the live metrics are cached in the process unless WithCache says otherwise.
+const DefaultCacheTTL = 5 * time.Minute
+
+// Recorder receives operational measurements from the ingestor.
+type Recorder interface {
+	// UpstreamError counts a failed SmartBlox call, op is "get_status" or "get_block".
+	UpstreamError(op string)
+	// PersistFailure counts a failed write to the repository.
+	PersistFailure()
+	// RoundProcessed observes the time spent fetching and applying a single round.
+	RoundProcessed(elapsed time.Duration)
+	// PassCompleted observes the time spent on a whole polling pass.
+	PassCompleted(elapsed time.Duration)
+	// ReorgDetected counts a chain reorganization that rolled back the given number of rounds.
+	ReorgDetected(rounds int64)
+	// InvalidAmount counts a transfer with an invalid amount, action is "rejected" or "flagged".
+	InvalidAmount(action string)
+	// DuplicateTransfer counts a transfer left out because its sig was already counted.
+	DuplicateTransfer()
+}
+
+type nopRecorder struct{}
+
+func (nopRecorder) UpstreamError(string)         {}
+func (nopRecorder) PersistFailure()              {}
+func (nopRecorder) RoundProcessed(time.Duration) {}
+func (nopRecorder) PassCompleted(time.Duration)  {}
+func (nopRecorder) ReorgDetected(int64)          {}
+func (nopRecorder) InvalidAmount(string)         {}
+func (nopRecorder) DuplicateTransfer()           {}
+
+// Notifier is told about the rounds as they are committed and rolled back, after the repository
+// has stored the change. It must not block the ingestor.
+type Notifier interface {
+	// RoundCommitted receives a committed round's events and the metrics as of the round.
+	RoundCommitted(round int64, events []models.Event, metrics models.Metrics)
+	// RolledBack reports that every round after the given one was removed, to be ingested again.
+	RolledBack(round int64)
+}
+
+type nopNotifier struct{}
+
+func (nopNotifier) RoundCommitted(int64, []models.Event, models.Metrics) {}
+func (nopNotifier) RolledBack(int64)                                     {}
+
+// Option customizes an Ingestor.
+type Option func(*Ingestor)
+
+// WithRecorder reports operational measurements to r.
+func WithRecorder(r Recorder) Option {
+	return func(i *Ingestor) { i.recorder = r }
+}
+
+// WithCache keeps the live metrics in c, which can be shared with other processes, instead of in the process.
+func WithCache(c cache.Cache) Option {
+	return func(i *Ingestor) { i.cache = c }
+}
+
+// WithNotifier tells n about every round committed and rolled back.
+func WithNotifier(n Notifier) Option {
+	return func(i *Ingestor) { i.notifier = n }
+}
+
+// WithConcurrency fetches up to n blocks in parallel while catching up. Rounds are still committed one at a time, in order.
+func WithConcurrency(n int) Option {
+	return func(i *Ingestor) { i.concurrency = max(n, 1) }
+}
+
+// WithReorgDepth re-checks the last depth committed rounds on every pass and rolls back
+// the ones upstream has replaced. A depth of 0 disables reorganization detection.
+func WithReorgDepth(depth int) Option {
+	return func(i *Ingestor) { i.reorgDepth = depth }
+}
+
+// WithSnapshotInterval snapshots the metrics every interval rounds, so point-in-time queries only
+// add up the stats of the rounds since the last snapshot. 0 disables snapshots.
+func WithSnapshotInterval(interval int64) Option {
+	return func(i *Ingestor) { i.snapshotInterval = interval }
+}
+
+// WithWindows keeps sliding-window aggregates over the given windows, next to the cumulative metrics.
+func WithWindows(specs ...models.WindowSpec) Option {
+	return func(i *Ingestor) {
+		for _, spec := range specs {
+			i.windows = append(i.windows, models.NewWindow(spec))
+		}
+	}
+}
+
+// AmountPolicy decides what happens to a transfer whose amount is invalid.
+type AmountPolicy int
+
+const (
+	// RejectAmounts leaves the transfer out of the metrics and the events.
+	RejectAmounts AmountPolicy = iota
+	// FlagAmounts counts the transfer anyway, it is only logged and reported.
+	FlagAmounts
+)
+
+// ParseAmountPolicy reads "reject" or "flag".
+func ParseAmountPolicy(s string) (AmountPolicy, error) {
+	switch s {
+	case "reject":
+		return RejectAmounts, nil
+	case "flag":
+		return FlagAmounts, nil
+	}
+	return 0, fmt.Errorf("unknown amount policy %q, want reject or flag", s)
+}
+
+// WithAmountValidation treats negative amounts and amounts above maxAmount as invalid, and applies
+// the policy to them. A maxAmount of 0 sets no upper limit. By default negative amounts are rejected.
+func WithAmountValidation(maxAmount int64, policy AmountPolicy) Option {
+	return func(i *Ingestor) { i.maxAmount, i.amountPolicy = maxAmount, policy }
+}
+
 type Ingestor struct {
 	cli          smartblox.Client
 	poolEvery    time.Duration
-	persistEvery time.Duration
 	logger       zerolog.Logger
-	eventLogger  zerolog.Logger
+	events       sink.EventSink
 	repo         repository.Repository
-	metricsCache *models.Metrics
-	cacheTime    time.Time
-	mu           sync.RWMutex
+	recorder     Recorder
+	notifier     Notifier
+	reorgDepth   int
+	concurrency  int
+	maxAmount    int64
+	amountPolicy AmountPolicy
+	// snapshotInterval is how many rounds apart the metrics are snapshotted, 0 disables snapshots.
+	snapshotInterval int64
+	// cache holds the live metrics, it is read before every pass and written on every commit.
+	cache     cache.Cache
+	headRound int64
+	mu        sync.RWMutex
+	// now stamps committed rounds with their ingest time, it is replaced in tests.
+	now func() time.Time
+	// windows are rebuilt from the round stats when stale: on start, and after a rollback or a round
+	// committed behind the ingestor's back. windowsMu is held across the rebuild, so no committed round is missed.
+	windows      []*models.Window
+	windowsStale bool
+	windowsMu    sync.Mutex
 }
 
-func New(cli smartblox.Client, poolEvery, persistEvery time.Duration, logger, eventLogger zerolog.Logger, repo repository.Repository) *Ingestor {
-	return &Ingestor{cli: cli, poolEvery: poolEvery, persistEvery: persistEvery, logger: logger, repo: repo, eventLogger: eventLogger}
+// New returns an ingestor that publishes the events of every committed round to events.
+// The caller owns events and closes it once Run has returned.
+func New(cli smartblox.Client, poolEvery time.Duration, logger zerolog.Logger, events sink.EventSink, repo repository.Repository, opts ...Option) *Ingestor {
+	i := &Ingestor{cli: cli, poolEvery: poolEvery, logger: logger, repo: repo, events: events, recorder: nopRecorder{}, notifier: nopNotifier{}, concurrency: 1, now: time.Now, windowsStale: true,
+		cache: cache.NewMemory(DefaultCacheTTL)}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
 }
 
 // Run starts the ingestor process, polling the SmartBlox API at regular intervals defined by poolEvery.
 // It continues to run until the provided context is canceled, at which point it returns context.Canceled.
+// Every round is checkpointed as it is committed, so there is nothing left to flush on shutdown.
 func (i *Ingestor) Run(ctx context.Context) error {
 	i.logger.Info().Msg("Starting Ingestor...")
 	i.logger.Debug().Msg("Tick interval: " + i.poolEvery.String())
 	ticker := time.NewTicker(i.poolEvery)
 	defer ticker.Stop()
-
-	// Persistence goroutine
-	persistTicker := time.NewTicker(i.persistEvery)
-	defer persistTicker.Stop()
+	// A shared cache may still hold the metrics of an earlier run, possibly against another repository.
+	i.invalidateCache(ctx)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return context.Canceled
-		case <-persistTicker.C:
-			// Persist current metrics to the database
-			i.logger.Info().Msg("Persisting metrics...")
-			i.mu.RLock()
-			metrics := i.metricsCache
-			i.mu.RUnlock()
-			if metrics != nil {
-				_ = i.updateMetrics(ctx, *metrics)
-			}
 		case <-ticker.C:
 			i.logger.Info().Msg("Polling SmartBlox API...")
 			if err := i.process(ctx); err != nil {
 				if errors.Is(err, context.Canceled) {
 					return err
 				}
+				if errors.Is(err, smartblox.ErrCircuitOpen) {
+					// The breaker already logged the outage, don't repeat it on every tick.
+					i.logger.Debug().Msg("SmartBlox circuit is open, skipping this poll")
+					continue
+				}
 				i.logger.Error().Msgf("processing error: %v", err)
 			}
 		}
@@ -70,38 +205,144 @@ func (i *Ingestor) Run(ctx context.Context) error {
 
 // process fetches the latest status from the SmartBlox API and processes any new rounds
 func (i *Ingestor) process(ctx context.Context) error {
+	start := time.Now()
+	defer func() { i.recorder.PassCompleted(time.Since(start)) }()
+
 	status, err := i.cli.GetStatus(ctx)
+	if errors.Is(err, smartblox.ErrCircuitOpen) {
+		return err
+	}
 	if err != nil {
-		i.logger.Error().Msgf("Error getting status: %v", err)
+		i.recorder.UpstreamError("get_status")
+		i.logger.Error().Msgf("Error getting status (%s): %v", failureKind(err), err)
 		return err
 	}
 
+	i.mu.Lock()
+	i.headRound = status.LastRound
+	i.mu.Unlock()
+
 	metrics, err := i.getMetrics(ctx)
 	if err != nil {
 		i.logger.Error().Msgf("Error loading metrics: %v", err)
 		return err
 	}
 
-	for r := metrics.LastRound + 1; r <= status.LastRound; r++ {
-		if err = i.processRound(ctx, r, metrics); err != nil {
+	if err = i.checkReorg(ctx, metrics); err != nil {
+		return err
+	}
+
+	blocks, stop := i.prefetch(ctx, metrics.LastRound+1, status.LastRound)
+	defer stop()
+
+	for f := range blocks {
+		if errors.Is(f.err, smartblox.ErrRoundNotFound) {
+			// The status ran ahead of the blocks the node serves, pick the round up on the next poll.
+			i.logger.Info().Msgf("Round %d is not available yet", f.round)
+			return nil
+		}
+		if errors.Is(f.err, smartblox.ErrCircuitOpen) {
+			return f.err
+		}
+		if f.err != nil {
+			i.recorder.UpstreamError("get_block")
+			i.logger.Error().Msgf("Error getting block %d (%s): %v", f.round, failureKind(f.err), f.err)
+			return f.err
+		}
+		if err = i.processRound(ctx, f, metrics); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	// The channel also closes early when ctx is canceled.
+	return ctx.Err()
 }
 
-// processRound processes a single round, extracting relevant events and updating metrics
-func (i *Ingestor) processRound(ctx context.Context, round int64, metrics *models.Metrics) error {
-	b, err := i.cli.GetBlock(ctx, round)
+// processRound processes a fetched round, extracting relevant events and committing them together with the updated metrics.
+// metrics is only advanced once the commit succeeds, so a failed round can be retried without double counting.
+func (i *Ingestor) processRound(ctx context.Context, f fetchedBlock, metrics *models.Metrics) error {
+	start := time.Now()
+	round := f.round
+
+	commit, err := i.commitRound(ctx, round, f.block, *metrics)
+	if errors.Is(err, repository.ErrRoundCommitted) {
+		// The stored checkpoint is ahead of the cache, reload it on the next pass instead of counting the round twice.
+		i.invalidateCache(ctx)
+		i.invalidateWindows()
+		i.logger.Warn().Msgf("Round %d was already committed, reloading metrics", round)
+		return err
+	}
 	if err != nil {
-		i.logger.Error().Msgf("Error getting block %d: %v", round, err)
 		return err
 	}
+	next, events := commit.Metrics, commit.Events
+
+	*metrics = next
+	i.setCache(ctx, next)
+	i.addToWindows(commit.Stats())
+	i.notifier.RoundCommitted(round, events, next)
+
+	if len(events) > 0 {
+		if err := i.events.Publish(ctx, events); err != nil {
+			// The round is committed, its events stay queryable from the repository.
+			i.logger.Error().Msgf("Error publishing events of round %d: %v", round, err)
+		}
+	}
+
+	i.recorder.RoundProcessed(f.elapsed + time.Since(start))
+	return nil
+}
+
+// commitRound builds the round's commit on top of metrics and stores it. A transfer whose sig the
+// repository already holds from an earlier round is left out and the commit built again, so one
+// replayed transfer does not stop ingestion at that round.
+func (i *Ingestor) commitRound(ctx context.Context, round int64, b smartblox.Block, metrics models.Metrics) (models.RoundCommit, error) {
+	skip := make(map[string]bool)
+	for {
+		commit, err := i.buildCommit(round, b, metrics, skip)
+		if err != nil {
+			i.logger.Error().Msgf("Error applying round %d: %v", round, err)
+			return models.RoundCommit{}, err
+		}
+		commit.IngestedAt = i.now()
+		commit.Snapshot = i.snapshotInterval > 0 && round%i.snapshotInterval == 0
+		err = i.repo.CommitRound(ctx, commit)
+		var dup *repository.DuplicateEventError
+		if errors.As(err, &dup) && !skip[dup.Sig] {
+			i.recorder.DuplicateTransfer()
+			i.logger.Warn().Msgf("Transfer %s in round %d is already stored, leaving it out", dup.Sig, round)
+			skip[dup.Sig] = true
+			continue
+		}
+		if errors.Is(err, repository.ErrRoundCommitted) {
+			return models.RoundCommit{}, err
+		}
+		if err != nil {
+			i.recorder.PersistFailure()
+			i.logger.Error().Msgf("Error committing round %d: %v", round, err)
+			return models.RoundCommit{}, err
+		}
+		return commit, nil
+	}
+}
 
+// buildCommit extracts the round's valid transfer events and applies them on top of metrics, leaving
+// out the transfers in skip and the repeats of a sig already seen in the round so each is counted once.
+// It fails if the metrics would overflow, so the round is retried rather than corrupting them.
+func (i *Ingestor) buildCommit(round int64, b smartblox.Block, metrics models.Metrics, skip map[string]bool) (models.RoundCommit, error) {
 	events := make([]models.Event, 0)
+	seen := make(map[string]bool)
 	for _, env := range b.Txs {
-		if env.Tx.Type != transactionType {
+		if env.Tx.Type != transactionType || skip[env.Sig] {
+			continue
+		}
+		if seen[env.Sig] {
+			i.recorder.DuplicateTransfer()
+			i.logger.Warn().Msgf("Transfer %s appears again in round %d, counting it once", env.Sig, round)
+			continue
+		}
+		seen[env.Sig] = true
+		if !i.validAmount(round, env) {
 			continue
 		}
 		recipient := env.Tx.Receipient
@@ -114,49 +355,213 @@ func (i *Ingestor) processRound(ctx context.Context, round int64, metrics *model
 			Amount:    env.Tx.Amount,
 		})
 
-		metrics.Update(env.Tx.Amount, round)
+		if err := metrics.Update(env.Tx.Amount, round); err != nil {
+			return models.RoundCommit{}, fmt.Errorf("transfer %s: %w", env.Sig, err)
+		}
+	}
+	// Rounds without transfers still move the checkpoint forward.
+	metrics.LastRound = round
+
+	return models.RoundCommit{Round: round, Hash: b.Hash(), TxCount: int64(len(b.Txs)), Events: events, Metrics: metrics}, nil
+}
+
+// validAmount reports whether the transfer is to be counted, logging it when its amount is invalid.
+func (i *Ingestor) validAmount(round int64, env smartblox.TransactionSig) bool {
+	amount := env.Tx.Amount
+	if amount >= 0 && (i.maxAmount == 0 || amount <= i.maxAmount) {
+		return true
 	}
+	if i.amountPolicy == FlagAmounts {
+		i.recorder.InvalidAmount("flagged")
+		i.logger.Warn().Msgf("Transfer %s in round %d has an invalid amount %d, counting it anyway", env.Sig, round, amount)
+		return true
+	}
+	i.recorder.InvalidAmount("rejected")
+	i.logger.Warn().Msgf("Transfer %s in round %d has an invalid amount %d, leaving it out", env.Sig, round, amount)
+	return false
+}
 
-	err = i.updateMetrics(ctx, *metrics)
+// checkReorg compares the last committed rounds with what upstream serves now. From the first round
+// whose block changed, every committed round is rolled back so the loop in process re-ingests it.
+// Events already published to the event sink cannot be retracted.
+func (i *Ingestor) checkReorg(ctx context.Context, metrics *models.Metrics) error {
+	if i.reorgDepth <= 0 {
+		return nil
+	}
+
+	recent, err := i.repo.RecentRounds(ctx, i.reorgDepth)
 	if err != nil {
+		i.logger.Error().Msgf("Error loading recent rounds: %v", err)
 		return err
 	}
 
-	if len(events) > 0 {
-		marshal, _ := json.Marshal(events)
-		i.eventLogger.Println(string(marshal))
-	}
+	for _, st := range recent {
+		b, err := i.cli.GetBlock(ctx, st.Round)
+		if errors.Is(err, smartblox.ErrCircuitOpen) {
+			return err
+		}
+		// A round that upstream no longer serves was dropped by the reorganization.
+		if err != nil && !errors.Is(err, smartblox.ErrRoundNotFound) {
+			i.recorder.UpstreamError("get_block")
+			i.logger.Error().Msgf("Error getting block %d (%s): %v", st.Round, failureKind(err), err)
+			return err
+		}
+		if err == nil && b.Hash() == st.Hash {
+			continue
+		}
+
+		i.logger.Warn().Msgf("Round %d was replaced upstream, rolling back rounds %d to %d", st.Round, st.Round, metrics.LastRound)
+		rolledBack, err := i.repo.RollbackTo(ctx, st.Round-1)
+		if err != nil {
+			i.recorder.PersistFailure()
+			i.logger.Error().Msgf("Error rolling back to round %d: %v", st.Round-1, err)
+			i.invalidateCache(ctx)
+			return err
+		}
+		i.recorder.ReorgDetected(metrics.LastRound - rolledBack.LastRound)
+		// Rounds that slid out of the windows are back in them, they are rebuilt.
+		i.invalidateWindows()
 
+		*metrics = rolledBack
+		i.setCache(ctx, rolledBack)
+		i.notifier.RolledBack(rolledBack.LastRound)
+		// The event log keeps the batches of the rolled back rounds, mark them so a rebuild drops them
+		// even when the rounds are re-ingested without transfers.
+		if rl, ok := i.events.(sink.RollbackLogger); ok {
+			if err := rl.RolledBack(ctx, rolledBack.LastRound); err != nil {
+				i.logger.Error().Msgf("Error logging the rollback to round %d: %v", rolledBack.LastRound, err)
+			}
+		}
+		return nil
+	}
 	return nil
 }
 
-// getMetrics retrieves the current metrics from the repository, using a simple in-memory cache to avoid frequent database hits
+// getMetrics returns the live metrics from the cache, loading them from the repository and caching them
+// on a miss. A cache that cannot be read is bypassed. It returns a copy so callers can update it freely.
 func (i *Ingestor) getMetrics(ctx context.Context) (*models.Metrics, error) {
-	i.mu.Lock()
-	defer i.mu.Unlock()
-	// TODO improve caching strategy using redis or similar
-	if i.metricsCache == nil || time.Since(i.cacheTime) > 5*time.Minute {
-		metrics, err := i.repo.LoadMetrics(ctx)
-		if err != nil {
+	metrics, ok, err := i.cache.Get(ctx)
+	if err != nil {
+		i.logger.Warn().Msgf("Error reading the metrics cache, loading metrics from the repository: %v", err)
+	}
+	if !ok {
+		if metrics, err = i.repo.LoadMetrics(ctx); err != nil {
 			i.logger.Error().Msgf("Error loading metrics: %v", err)
 			return nil, err
 		}
-		i.metricsCache = &metrics
-		i.cacheTime = time.Now()
+		i.setCache(ctx, metrics)
 	}
-	return i.metricsCache, nil
+	return &metrics, nil
 }
 
-// updateMetrics saves the updated metrics to the repository and updates the in-memory cache
-func (i *Ingestor) updateMetrics(ctx context.Context, metrics models.Metrics) error {
-	err := i.repo.SaveMetrics(ctx, metrics)
+// failureKind labels an upstream error for the logs once the client has given up retrying it.
+func failureKind(err error) string {
+	if smartblox.IsTransient(err) {
+		return "transient"
+	}
+	return "permanent"
+}
+
+// setCache caches the metrics just committed or loaded. If that fails, the cached ones are dropped
+// rather than left behind the repository.
+func (i *Ingestor) setCache(ctx context.Context, metrics models.Metrics) {
+	if err := i.cache.Set(ctx, metrics); err != nil {
+		i.logger.Warn().Msgf("Error caching metrics of round %d: %v", metrics.LastRound, err)
+		i.invalidateCache(ctx)
+	}
+}
+
+// invalidateCache forces the next getMetrics call to reload the checkpoint from the repository.
+func (i *Ingestor) invalidateCache(ctx context.Context) {
+	if err := i.cache.Invalidate(ctx); err != nil {
+		i.logger.Warn().Msgf("Error invalidating the metrics cache: %v", err)
+	}
+}
+
+// CurrentMetrics returns a copy of the cached metrics, loading them from the repository on a miss.
+func (i *Ingestor) CurrentMetrics(ctx context.Context) (models.Metrics, error) {
+	metrics, err := i.getMetrics(ctx)
 	if err != nil {
-		return err
+		return models.Metrics{}, err
 	}
+	return *metrics, nil
+}
 
-	i.mu.Lock()
-	i.metricsCache = &metrics
-	i.mu.Unlock()
+// HeadRound returns the last round reported by the SmartBlox API, or 0 before the first successful poll.
+func (i *Ingestor) HeadRound() int64 {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.headRound
+}
+
+// Windows returns the aggregates of the configured sliding windows, rebuilding them from the
+// repository first when they are stale.
+func (i *Ingestor) Windows(ctx context.Context) ([]models.WindowStats, error) {
+	i.windowsMu.Lock()
+	defer i.windowsMu.Unlock()
+	if i.windowsStale {
+		if err := i.loadWindows(ctx); err != nil {
+			i.logger.Error().Msgf("Error loading windows: %v", err)
+			return nil, err
+		}
+		i.windowsStale = false
+	}
 
+	now := i.now()
+	stats := make([]models.WindowStats, 0, len(i.windows))
+	for _, w := range i.windows {
+		w.Expire(now)
+		stats = append(stats, w.Stats())
+	}
+	return stats, nil
+}
+
+// loadWindows refills every window with the last rounds it covers. The caller holds windowsMu.
+func (i *Ingestor) loadWindows(ctx context.Context) error {
+	for n, w := range i.windows {
+		var (
+			rounds []models.RoundStats
+			err    error
+		)
+		spec := w.Spec()
+		if spec.Rounds > 0 {
+			rounds, err = i.repo.RecentRounds(ctx, int(spec.Rounds))
+		} else {
+			from := i.now().Add(-spec.Span)
+			rounds, err = i.repo.QueryRounds(ctx, models.RoundFilter{From: &from})
+		}
+		if err != nil {
+			return err
+		}
+		fresh := models.NewWindow(spec)
+		for _, st := range rounds {
+			if err := fresh.Add(st); err != nil {
+				return err
+			}
+		}
+		i.windows[n] = fresh
+	}
 	return nil
 }
+
+// addToWindows moves the windows forward to a committed round, unless they are to be rebuilt anyway.
+func (i *Ingestor) addToWindows(st models.RoundStats) {
+	i.windowsMu.Lock()
+	defer i.windowsMu.Unlock()
+	if i.windowsStale {
+		return
+	}
+	for _, w := range i.windows {
+		if err := w.Add(st); err != nil {
+			i.logger.Error().Msgf("Error adding round %d to window %s: %v", st.Round, w.Spec(), err)
+			i.windowsStale = true
+			return
+		}
+	}
+}
+
+func (i *Ingestor) invalidateWindows() {
+	i.windowsMu.Lock()
+	i.windowsStale = true
+	i.windowsMu.Unlock()
+}
@@ -3,9 +3,18 @@ package ingest
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/rhuandantas/metrika/internal/cache"
 	mock_ingest "github.com/rhuandantas/metrika/internal/mocks/ingest"
 	mock_repo "github.com/rhuandantas/metrika/internal/mocks/repository"
 	"github.com/rhuandantas/metrika/internal/models"
+	"github.com/rhuandantas/metrika/internal/repository"
+	"github.com/rhuandantas/metrika/internal/sink"
+	"math"
+	"net/http"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -22,14 +31,55 @@ func TestIngestor(t *testing.T) {
 	defer GinkgoRecover()
 }
 
+// recordingSink keeps the published batches and rollbacks and fails publishing with err when it is set.
+type recordingSink struct {
+	sink.Discard
+	batches   [][]models.Event
+	rollbacks []int64
+	err       error
+}
+
+func (s *recordingSink) Publish(_ context.Context, events []models.Event) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.batches = append(s.batches, events)
+	return nil
+}
+
+func (s *recordingSink) RolledBack(_ context.Context, lastRound int64) error {
+	s.rollbacks = append(s.rollbacks, lastRound)
+	return nil
+}
+
+// recordingNotifier records the notifications of the ingestor, and the events and metrics last committed per round.
+type recordingNotifier struct {
+	calls   []string
+	events  map[int64][]models.Event
+	metrics map[int64]models.Metrics
+}
+
+func (n *recordingNotifier) RoundCommitted(round int64, events []models.Event, metrics models.Metrics) {
+	if n.events == nil {
+		n.events, n.metrics = make(map[int64][]models.Event), make(map[int64]models.Metrics)
+	}
+	n.calls = append(n.calls, fmt.Sprintf("commit %d", round))
+	n.events[round], n.metrics[round] = events, metrics
+}
+
+func (n *recordingNotifier) RolledBack(round int64) {
+	n.calls = append(n.calls, fmt.Sprintf("rollback %d", round))
+}
+
 var _ = Describe("Ingestor", func() {
+	ingestedAt := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
 	var (
-		ctrl        *gomock.Controller
-		mockClient  *mock_ingest.MockClient
-		mockRepo    *mock_repo.MockRepository
-		logger      zerolog.Logger
-		eventLogger zerolog.Logger
-		ing         *Ingestor
+		ctrl       *gomock.Controller
+		mockClient *mock_ingest.MockClient
+		mockRepo   *mock_repo.MockRepository
+		logger     zerolog.Logger
+		events     *recordingSink
+		ing        *Ingestor
 	)
 
 	BeforeEach(func() {
@@ -37,8 +87,12 @@ var _ = Describe("Ingestor", func() {
 		mockClient = mock_ingest.NewMockClient(ctrl)
 		mockRepo = mock_repo.NewMockRepository(ctrl)
 		logger = zerolog.Nop()
-		eventLogger = zerolog.Nop()
-		ing = New(mockClient, time.Millisecond*1, time.Millisecond*2, logger, eventLogger, mockRepo)
+		events = &recordingSink{}
+		ing = New(mockClient, time.Millisecond*1, logger, events, mockRepo)
+	})
+
+	JustBeforeEach(func() {
+		ing.now = func() time.Time { return ingestedAt }
 	})
 
 	AfterEach(func() {
@@ -48,7 +102,6 @@ var _ = Describe("Ingestor", func() {
 	It("should return context.Canceled when context is canceled", func() {
 		ctx, cancel := context.WithCancel(context.Background())
 		cancel()
-		mockRepo.EXPECT().SaveMetrics(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
 		err := ing.Run(ctx)
 		Expect(err).To(Equal(context.Canceled))
 	})
@@ -76,6 +129,18 @@ var _ = Describe("Ingestor", func() {
 		err := ing.process(context.Background())
 		Expect(err).To(HaveOccurred())
 	})
+	It("should skip the pass while the upstream circuit is open", func() {
+		mockClient.EXPECT().GetStatus(gomock.Any()).Return(smartblox.Status{}, smartblox.ErrCircuitOpen)
+		err := ing.process(context.Background())
+		Expect(err).To(MatchError(smartblox.ErrCircuitOpen))
+	})
+	It("should end the pass quietly when a round is not served yet", func() {
+		mockClient.EXPECT().GetStatus(gomock.Any()).Return(smartblox.Status{LastRound: 2}, nil)
+		mockRepo.EXPECT().LoadMetrics(gomock.Any()).Return(models.Metrics{LastRound: 1}, nil)
+		mockClient.EXPECT().GetBlock(gomock.Any(), int64(2)).Return(smartblox.Block{}, &smartblox.StatusError{StatusCode: http.StatusNotFound})
+		err := ing.process(context.Background())
+		Expect(err).To(BeNil())
+	})
 	It("should return error updating metrics", func() {
 		mockClient.EXPECT().GetStatus(gomock.Any()).Return(smartblox.Status{LastRound: 2}, nil)
 		mockRepo.EXPECT().LoadMetrics(gomock.Any()).Return(models.Metrics{LastRound: 1}, nil)
@@ -102,14 +167,62 @@ var _ = Describe("Ingestor", func() {
 				},
 			},
 		}, nil)
-		mockRepo.EXPECT().SaveMetrics(gomock.Any(), gomock.Any()).Return(errors.New("fail"))
+		mockRepo.EXPECT().CommitRound(gomock.Any(), gomock.Any()).Return(errors.New("fail"))
 		err := ing.process(context.Background())
 		Expect(err).To(HaveOccurred())
+
+		// The failed round must not leak into the cached metrics.
+		metrics, err := ing.CurrentMetrics(context.Background())
+		Expect(err).To(BeNil())
+		Expect(metrics).To(Equal(models.Metrics{LastRound: 1}))
 	})
-	It("should process rounds and return nil", func() {
+	It("should reload metrics when the round was already committed", func() {
+		mockClient.EXPECT().GetStatus(gomock.Any()).Return(smartblox.Status{LastRound: 2}, nil).Times(2)
+		mockRepo.EXPECT().LoadMetrics(gomock.Any()).Return(models.Metrics{LastRound: 1}, nil)
+		mockClient.EXPECT().GetBlock(gomock.Any(), int64(2)).Return(smartblox.Block{Round: 2}, nil)
+		mockRepo.EXPECT().CommitRound(gomock.Any(), gomock.Any()).Return(repository.ErrRoundCommitted)
+		err := ing.process(context.Background())
+		Expect(err).To(MatchError(repository.ErrRoundCommitted))
+
+		mockRepo.EXPECT().LoadMetrics(gomock.Any()).Return(models.Metrics{LastRound: 2}, nil)
+		err = ing.process(context.Background())
+		Expect(err).To(BeNil())
+	})
+	It("should move the checkpoint past rounds without transfers", func() {
 		mockClient.EXPECT().GetStatus(gomock.Any()).Return(smartblox.Status{LastRound: 2}, nil)
 		mockRepo.EXPECT().LoadMetrics(gomock.Any()).Return(models.Metrics{LastRound: 1}, nil)
-		mockClient.EXPECT().GetBlock(gomock.Any(), int64(2)).Return(smartblox.Block{
+		mockClient.EXPECT().GetBlock(gomock.Any(), int64(2)).Return(smartblox.Block{Round: 2}, nil)
+		mockRepo.EXPECT().CommitRound(gomock.Any(), models.RoundCommit{
+			Round:      2,
+			Hash:       smartblox.Block{Round: 2}.Hash(),
+			IngestedAt: ingestedAt,
+			Events:     []models.Event{},
+			Metrics:    models.Metrics{LastRound: 2},
+		}).Return(nil)
+		err := ing.process(context.Background())
+		Expect(err).To(BeNil())
+	})
+	It("should snapshot the metrics every snapshot interval rounds", func() {
+		ing = New(mockClient, time.Millisecond*1, logger, events, mockRepo, WithSnapshotInterval(2))
+		ing.now = func() time.Time { return ingestedAt }
+		mockClient.EXPECT().GetStatus(gomock.Any()).Return(smartblox.Status{LastRound: 3}, nil)
+		mockRepo.EXPECT().LoadMetrics(gomock.Any()).Return(models.Metrics{LastRound: 1}, nil)
+		for _, round := range []int64{2, 3} {
+			mockClient.EXPECT().GetBlock(gomock.Any(), round).Return(smartblox.Block{Round: round}, nil)
+			mockRepo.EXPECT().CommitRound(gomock.Any(), models.RoundCommit{
+				Round:      round,
+				Hash:       smartblox.Block{Round: round}.Hash(),
+				IngestedAt: ingestedAt,
+				Snapshot:   round == 2,
+				Events:     []models.Event{},
+				Metrics:    models.Metrics{LastRound: round},
+			}).Return(nil)
+		}
+		err := ing.process(context.Background())
+		Expect(err).To(BeNil())
+	})
+	It("should process rounds and return nil", func() {
+		block := smartblox.Block{
 			Round: 2,
 			Txs: []smartblox.TransactionSig{
 				{
@@ -131,9 +244,444 @@ var _ = Describe("Ingestor", func() {
 					},
 				},
 			},
-		}, nil)
-		mockRepo.EXPECT().SaveMetrics(gomock.Any(), gomock.Any()).Return(nil)
+		}
+		mockClient.EXPECT().GetStatus(gomock.Any()).Return(smartblox.Status{LastRound: 2}, nil)
+		mockRepo.EXPECT().LoadMetrics(gomock.Any()).Return(models.Metrics{LastRound: 1}, nil)
+		mockClient.EXPECT().GetBlock(gomock.Any(), int64(2)).Return(block, nil)
+		mockRepo.EXPECT().CommitRound(gomock.Any(), models.RoundCommit{
+			Round:      2,
+			Hash:       block.Hash(),
+			TxCount:    2,
+			IngestedAt: ingestedAt,
+			Events: []models.Event{
+				{Round: 2, Sig: "mock_sig", Sender: 2, Recipient: 1, Amount: 1000},
+			},
+			Metrics: models.Metrics{Count: 1, Sum: models.NewInt128(1000), Max: 1000, Mean: 1000, LastRound: 2},
+		}).Return(nil)
 		err := ing.process(context.Background())
 		Expect(err).To(BeNil())
+
+		metrics, err := ing.CurrentMetrics(context.Background())
+		Expect(err).To(BeNil())
+		Expect(metrics.LastRound).To(Equal(int64(2)))
+		Expect(metrics.Count).To(Equal(int64(1)))
+		Expect(ing.HeadRound()).To(Equal(int64(2)))
+		Expect(events.batches).To(Equal([][]models.Event{
+			{{Round: 2, Sig: "mock_sig", Sender: 2, Recipient: 1, Amount: 1000}},
+		}))
+	})
+	It("should keep a committed round when its events cannot be published", func() {
+		events.err = errors.New("sink down")
+		block := smartblox.Block{Round: 2, Txs: []smartblox.TransactionSig{
+			{Sig: "s", Tx: smartblox.Transaction{Amount: 5, Type: transactionType}},
+		}}
+		mockClient.EXPECT().GetStatus(gomock.Any()).Return(smartblox.Status{LastRound: 2}, nil)
+		mockRepo.EXPECT().LoadMetrics(gomock.Any()).Return(models.Metrics{LastRound: 1}, nil)
+		mockClient.EXPECT().GetBlock(gomock.Any(), int64(2)).Return(block, nil)
+		mockRepo.EXPECT().CommitRound(gomock.Any(), gomock.Any()).Return(nil)
+
+		Expect(ing.process(context.Background())).To(Succeed())
+		metrics, err := ing.CurrentMetrics(context.Background())
+		Expect(err).To(BeNil())
+		Expect(metrics.LastRound).To(Equal(int64(2)))
+	})
+
+	Describe("with invalid amounts", func() {
+		block := smartblox.Block{Round: 2, Txs: []smartblox.TransactionSig{
+			{Sig: "ok", Tx: smartblox.Transaction{Amount: 10, Type: transactionType}},
+			{Sig: "negative", Tx: smartblox.Transaction{Amount: -5, Type: transactionType}},
+			{Sig: "huge", Tx: smartblox.Transaction{Amount: 1_000_000, Type: transactionType}},
+		}}
+		var committed models.RoundCommit
+
+		BeforeEach(func() {
+			mockClient.EXPECT().GetStatus(gomock.Any()).Return(smartblox.Status{LastRound: 2}, nil)
+			m := models.NewMetrics()
+			m.LastRound = 1
+			mockRepo.EXPECT().LoadMetrics(gomock.Any()).Return(m, nil)
+			mockClient.EXPECT().GetBlock(gomock.Any(), int64(2)).Return(block, nil)
+			mockRepo.EXPECT().CommitRound(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, c models.RoundCommit) error {
+				committed = c
+				return nil
+			})
+		})
+
+		It("should leave them out when rejecting", func() {
+			ing = New(mockClient, time.Millisecond*1, logger, events, mockRepo, WithAmountValidation(1000, RejectAmounts))
+			Expect(ing.process(context.Background())).To(Succeed())
+			Expect(committed.Events).To(HaveLen(1))
+			Expect(committed.Metrics.Count).To(Equal(int64(1)))
+			Expect(committed.Metrics.Sum).To(Equal(models.NewInt128(10)))
+		})
+		It("should count them when flagging", func() {
+			ing = New(mockClient, time.Millisecond*1, logger, events, mockRepo, WithAmountValidation(1000, FlagAmounts))
+			Expect(ing.process(context.Background())).To(Succeed())
+			Expect(committed.Events).To(HaveLen(3))
+			Expect(committed.Metrics.Sum).To(Equal(models.NewInt128(1_000_005)))
+			Expect(committed.Metrics.Min).To(Equal(int64(-5)))
+		})
+	})
+	It("should fail the round instead of overflowing the count", func() {
+		block := smartblox.Block{Round: 2, Txs: []smartblox.TransactionSig{
+			{Sig: "s", Tx: smartblox.Transaction{Amount: 5, Type: transactionType}},
+		}}
+		mockClient.EXPECT().GetStatus(gomock.Any()).Return(smartblox.Status{LastRound: 2}, nil)
+		mockRepo.EXPECT().LoadMetrics(gomock.Any()).Return(models.Metrics{Count: math.MaxInt64, LastRound: 1}, nil)
+		mockClient.EXPECT().GetBlock(gomock.Any(), int64(2)).Return(block, nil)
+
+		Expect(ing.process(context.Background())).To(MatchError(models.ErrOverflow))
+	})
+
+	Describe("with concurrent fetching", func() {
+		BeforeEach(func() {
+			ing = New(mockClient, time.Millisecond*1, logger, events, mockRepo, WithConcurrency(3))
+		})
+
+		It("should fetch in parallel but commit in round order", func() {
+			var inFlight, maxInFlight atomic.Int32
+			mockClient.EXPECT().GetStatus(gomock.Any()).Return(smartblox.Status{LastRound: 8}, nil)
+			mockRepo.EXPECT().LoadMetrics(gomock.Any()).Return(models.Metrics{LastRound: 0}, nil)
+			mockClient.EXPECT().GetBlock(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, round int64) (smartblox.Block, error) {
+				n := inFlight.Add(1)
+				defer inFlight.Add(-1)
+				for {
+					m := maxInFlight.Load()
+					if n <= m || maxInFlight.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				// Later rounds finish first.
+				time.Sleep(time.Duration(10-round) * time.Millisecond)
+				return smartblox.Block{Round: round}, nil
+			}).Times(8)
+
+			var committed []int64
+			mockRepo.EXPECT().CommitRound(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, c models.RoundCommit) error {
+				committed = append(committed, c.Round)
+				return nil
+			}).Times(8)
+
+			err := ing.process(context.Background())
+			Expect(err).To(BeNil())
+			Expect(committed).To(Equal([]int64{1, 2, 3, 4, 5, 6, 7, 8}))
+			Expect(maxInFlight.Load()).To(BeNumerically("<=", 3))
+			Expect(maxInFlight.Load()).To(BeNumerically(">", 1))
+		})
+		It("should stop at the first round that fails to fetch", func() {
+			mockClient.EXPECT().GetStatus(gomock.Any()).Return(smartblox.Status{LastRound: 5}, nil)
+			mockRepo.EXPECT().LoadMetrics(gomock.Any()).Return(models.Metrics{LastRound: 0}, nil)
+			mockClient.EXPECT().GetBlock(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, round int64) (smartblox.Block, error) {
+				if round == 2 {
+					return smartblox.Block{}, errors.New("fail")
+				}
+				return smartblox.Block{Round: round}, nil
+			}).MinTimes(2).MaxTimes(5)
+			mockRepo.EXPECT().CommitRound(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+
+			err := ing.process(context.Background())
+			Expect(err).To(HaveOccurred())
+			metrics, err := ing.CurrentMetrics(context.Background())
+			Expect(err).To(BeNil())
+			Expect(metrics.LastRound).To(Equal(int64(1)))
+		})
+	})
+
+	Describe("backfill", func() {
+		txfer := func(round, amount int64) smartblox.Block {
+			return smartblox.Block{Round: round, Txs: []smartblox.TransactionSig{
+				{Sig: fmt.Sprintf("sig-%d", round), Tx: smartblox.Transaction{Type: transactionType, Amount: amount}},
+			}}
+		}
+
+		It("should commit the range into the scope without touching the live checkpoint", func() {
+			mockClient.EXPECT().GetBlock(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, round int64) (smartblox.Block, error) {
+				return txfer(round, round*10), nil
+			}).Times(3)
+			var committed []int64
+			mockRepo.EXPECT().CommitScopeRound(gomock.Any(), "history", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, c models.RoundCommit) error {
+				committed = append(committed, c.Round)
+				return nil
+			}).Times(3)
+
+			sc, err := ing.Backfill(context.Background(), models.NewScope("history", 10, 12))
+			Expect(err).To(BeNil())
+			Expect(committed).To(Equal([]int64{10, 11, 12}))
+			Expect(sc.Done()).To(BeTrue())
+			Expect(sc.Metrics).To(Equal(models.Metrics{Count: 3, Sum: models.NewInt128(330), Min: 100, Max: 120, Mean: 110, M2: 200, LastRound: 12}))
+		})
+		It("should count a sig repeated in a round once, like live ingestion", func() {
+			mockClient.EXPECT().GetBlock(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, round int64) (smartblox.Block, error) {
+				b := txfer(round, round*10)
+				b.Txs = append(b.Txs, b.Txs...)
+				return b, nil
+			}).Times(2)
+			var committed []models.RoundCommit
+			mockRepo.EXPECT().CommitScopeRound(gomock.Any(), "history", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, c models.RoundCommit) error {
+				committed = append(committed, c)
+				return nil
+			}).Times(2)
+
+			sc, err := ing.Backfill(context.Background(), models.NewScope("history", 10, 11))
+			Expect(err).To(BeNil())
+			Expect(committed).To(HaveLen(2))
+			Expect(committed[1].Events).To(Equal([]models.Event{{Round: 11, Sig: "sig-11", Amount: 110}}))
+			Expect(sc.Metrics.LastRound).To(Equal(int64(11)))
+			Expect(sc.Metrics.Count).To(Equal(int64(2)))
+			Expect(sc.Metrics.Sum).To(Equal(models.NewInt128(210)))
+		})
+		It("should resume after the last applied round", func() {
+			sc := models.NewScope("history", 10, 12)
+			sc.Metrics.Update(100, 10)
+			mockClient.EXPECT().GetBlock(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, round int64) (smartblox.Block, error) {
+				return txfer(round, round*10), nil
+			}).Times(2)
+			mockRepo.EXPECT().CommitScopeRound(gomock.Any(), "history", gomock.Any()).Return(nil).Times(2)
+
+			sc, err := ing.Backfill(context.Background(), sc)
+			Expect(err).To(BeNil())
+			Expect(sc.Metrics.Count).To(Equal(int64(3)))
+		})
+		It("should stop at the first round that fails", func() {
+			mockClient.EXPECT().GetBlock(gomock.Any(), int64(1)).Return(smartblox.Block{}, &smartblox.StatusError{StatusCode: http.StatusNotFound})
+
+			sc, err := ing.Backfill(context.Background(), models.NewScope("history", 1, 1))
+			Expect(err).To(MatchError(smartblox.ErrRoundNotFound))
+			Expect(sc.Metrics.LastRound).To(BeZero())
+		})
+	})
+
+	Describe("with sliding windows", func() {
+		var now time.Time
+
+		BeforeEach(func() {
+			now = ingestedAt
+			ing = New(mockClient, time.Millisecond*1, logger, events, mockRepo,
+				WithWindows(models.WindowSpec{Rounds: 2}, models.WindowSpec{Span: time.Hour}))
+		})
+		JustBeforeEach(func() {
+			ing.now = func() time.Time { return now }
+		})
+
+		It("should rebuild the windows from the round stats and slide them forward", func() {
+			r4 := models.RoundStats{Round: 4, Count: 1, Sum: models.NewInt128(10), Min: 10, Max: 10, IngestedAt: now.Add(-90 * time.Minute)}
+			r5 := models.RoundStats{Round: 5, Count: 2, Sum: models.NewInt128(50), Min: 5, Max: 45, IngestedAt: now.Add(-30 * time.Minute)}
+			mockRepo.EXPECT().RecentRounds(gomock.Any(), 2).Return([]models.RoundStats{r4, r5}, nil)
+			from := now.Add(-time.Hour)
+			mockRepo.EXPECT().QueryRounds(gomock.Any(), models.RoundFilter{From: &from}).Return([]models.RoundStats{r5}, nil)
+
+			windows, err := ing.Windows(context.Background())
+			Expect(err).To(BeNil())
+			Expect(windows).To(Equal([]models.WindowStats{
+				{Window: models.WindowSpec{Rounds: 2}, Rounds: 2, Count: 3, Sum: models.NewInt128(60), Min: 5, Max: 45},
+				{Window: models.WindowSpec{Span: time.Hour}, Rounds: 1, Count: 2, Sum: models.NewInt128(50), Min: 5, Max: 45},
+			}))
+
+			block := smartblox.Block{Round: 6, Txs: []smartblox.TransactionSig{
+				{Sig: "s1", Tx: smartblox.Transaction{Amount: 100, Type: transactionType}},
+				{Sig: "s2", Tx: smartblox.Transaction{Amount: 20, Type: transactionType}},
+			}}
+			mockClient.EXPECT().GetStatus(gomock.Any()).Return(smartblox.Status{LastRound: 6}, nil)
+			mockRepo.EXPECT().LoadMetrics(gomock.Any()).Return(models.Metrics{LastRound: 5}, nil)
+			mockClient.EXPECT().GetBlock(gomock.Any(), int64(6)).Return(block, nil)
+			mockRepo.EXPECT().CommitRound(gomock.Any(), gomock.Any()).Return(nil)
+			Expect(ing.process(context.Background())).To(Succeed())
+
+			// Round 4 slid out of the round window, round 5 out of the time window once 45 minutes passed.
+			now = now.Add(45 * time.Minute)
+			windows, err = ing.Windows(context.Background())
+			Expect(err).To(BeNil())
+			Expect(windows).To(Equal([]models.WindowStats{
+				{Window: models.WindowSpec{Rounds: 2}, Rounds: 2, Count: 4, Sum: models.NewInt128(170), Min: 5, Max: 100},
+				{Window: models.WindowSpec{Span: time.Hour}, Rounds: 1, Count: 2, Sum: models.NewInt128(120), Min: 20, Max: 100},
+			}))
+		})
+	})
+
+	Describe("with reorganization detection", func() {
+		BeforeEach(func() {
+			ing = New(mockClient, time.Millisecond*1, logger, events, mockRepo, WithReorgDepth(2))
+		})
+
+		It("should keep rounds whose blocks did not change", func() {
+			b4, b5 := smartblox.Block{Round: 4}, smartblox.Block{Round: 5}
+			mockClient.EXPECT().GetStatus(gomock.Any()).Return(smartblox.Status{LastRound: 5}, nil)
+			mockRepo.EXPECT().LoadMetrics(gomock.Any()).Return(models.Metrics{LastRound: 5}, nil)
+			mockRepo.EXPECT().RecentRounds(gomock.Any(), 2).Return([]models.RoundStats{
+				{Round: 4, Hash: b4.Hash()},
+				{Round: 5, Hash: b5.Hash()},
+			}, nil)
+			mockClient.EXPECT().GetBlock(gomock.Any(), int64(4)).Return(b4, nil)
+			mockClient.EXPECT().GetBlock(gomock.Any(), int64(5)).Return(b5, nil)
+			err := ing.process(context.Background())
+			Expect(err).To(BeNil())
+		})
+		It("should roll back and re-ingest replaced rounds", func() {
+			b4 := smartblox.Block{Round: 4}
+			replaced := smartblox.Block{Round: 5, Txs: []smartblox.TransactionSig{
+				{Sig: "new_sig", Tx: smartblox.Transaction{Amount: 40, Type: transactionType}},
+			}}
+			mockClient.EXPECT().GetStatus(gomock.Any()).Return(smartblox.Status{LastRound: 5}, nil)
+			mockRepo.EXPECT().LoadMetrics(gomock.Any()).Return(models.Metrics{Count: 3, Sum: models.NewInt128(30), Min: 10, Max: 10, Mean: 10, LastRound: 5}, nil)
+			mockRepo.EXPECT().RecentRounds(gomock.Any(), 2).Return([]models.RoundStats{
+				{Round: 4, Hash: b4.Hash()},
+				{Round: 5, Hash: "old_hash", Count: 1, Sum: models.NewInt128(10), Min: 10, Max: 10},
+			}, nil)
+			mockClient.EXPECT().GetBlock(gomock.Any(), int64(4)).Return(b4, nil)
+			mockClient.EXPECT().GetBlock(gomock.Any(), int64(5)).Return(replaced, nil).Times(2)
+			mockRepo.EXPECT().RollbackTo(gomock.Any(), int64(4)).Return(models.Metrics{Count: 2, Sum: models.NewInt128(20), Min: 10, Max: 10, Mean: 10, LastRound: 4}, nil)
+			mockRepo.EXPECT().CommitRound(gomock.Any(), models.RoundCommit{
+				Round:      5,
+				Hash:       replaced.Hash(),
+				TxCount:    1,
+				IngestedAt: ingestedAt,
+				Events:     []models.Event{{Round: 5, Sig: "new_sig", Amount: 40}},
+				Metrics:    models.Metrics{Count: 3, Sum: models.NewInt128(60), Min: 10, Max: 40, Mean: 20, M2: 600, LastRound: 5},
+			}).Return(nil)
+			err := ing.process(context.Background())
+			Expect(err).To(BeNil())
+			Expect(events.rollbacks).To(Equal([]int64{4}))
+			Expect(events.batches).To(Equal([][]models.Event{{{Round: 5, Sig: "new_sig", Amount: 40}}}))
+		})
+	})
+
+	Describe("with a shared cache", func() {
+		var (
+			server *miniredis.Miniredis
+			shared *cache.Redis
+		)
+		openCache := func() *cache.Redis {
+			client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1, DialerRetries: 1})
+			DeferCleanup(client.Close)
+			return cache.NewRedis(client, "metrika:metrics", time.Minute)
+		}
+
+		BeforeEach(func() {
+			server = miniredis.RunT(GinkgoT())
+			shared = openCache()
+			ing = New(mockClient, time.Millisecond*1, logger, events, mockRepo, WithCache(openCache()))
+		})
+
+		It("should share the metrics of every commit", func() {
+			mockClient.EXPECT().GetStatus(gomock.Any()).Return(smartblox.Status{LastRound: 2}, nil)
+			mockRepo.EXPECT().LoadMetrics(gomock.Any()).Return(models.Metrics{LastRound: 1}, nil)
+			mockClient.EXPECT().GetBlock(gomock.Any(), int64(2)).Return(smartblox.Block{Round: 2}, nil)
+			mockRepo.EXPECT().CommitRound(gomock.Any(), gomock.Any()).Return(nil)
+			Expect(ing.process(context.Background())).To(Succeed())
+
+			m, ok, err := shared.Get(context.Background())
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeTrue())
+			Expect(m).To(Equal(models.Metrics{LastRound: 2}))
+			m, err = ing.CurrentMetrics(context.Background())
+			Expect(err).To(BeNil())
+			Expect(m).To(Equal(models.Metrics{LastRound: 2}))
+		})
+		It("should invalidate the shared metrics when the round was already committed", func() {
+			Expect(shared.Set(context.Background(), models.Metrics{LastRound: 1})).To(Succeed())
+			mockClient.EXPECT().GetStatus(gomock.Any()).Return(smartblox.Status{LastRound: 2}, nil)
+			mockClient.EXPECT().GetBlock(gomock.Any(), int64(2)).Return(smartblox.Block{Round: 2}, nil)
+			mockRepo.EXPECT().CommitRound(gomock.Any(), gomock.Any()).Return(repository.ErrRoundCommitted)
+			Expect(ing.process(context.Background())).To(MatchError(repository.ErrRoundCommitted))
+
+			_, ok, err := shared.Get(context.Background())
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeFalse())
+		})
+		It("should keep ingesting from the repository while the cache is down", func() {
+			server.Close()
+			mockClient.EXPECT().GetStatus(gomock.Any()).Return(smartblox.Status{LastRound: 2}, nil)
+			mockRepo.EXPECT().LoadMetrics(gomock.Any()).Return(models.Metrics{LastRound: 1}, nil)
+			mockClient.EXPECT().GetBlock(gomock.Any(), int64(2)).Return(smartblox.Block{Round: 2}, nil)
+			mockRepo.EXPECT().CommitRound(gomock.Any(), gomock.Any()).Return(nil)
+			Expect(ing.process(context.Background())).To(Succeed())
+
+			mockRepo.EXPECT().LoadMetrics(gomock.Any()).Return(models.Metrics{LastRound: 2}, nil)
+			m, err := ing.CurrentMetrics(context.Background())
+			Expect(err).To(BeNil())
+			Expect(m.LastRound).To(Equal(int64(2)))
+		})
+	})
+
+	Describe("with an in-memory repository", func() {
+		var (
+			repo   repository.Repository
+			blocks map[int64]smartblox.Block
+		)
+		block := func(round int64, sig string, amount int64) smartblox.Block {
+			return smartblox.Block{Round: round, Txs: []smartblox.TransactionSig{
+				{Sig: sig, Tx: smartblox.Transaction{Sender: 1, Receipient: 2, Amount: amount, Type: transactionType}},
+			}}
+		}
+
+		BeforeEach(func() {
+			repo = repository.NewMemoryMetrics()
+			blocks = map[int64]smartblox.Block{1: block(1, "a", 10), 2: block(2, "b", 20), 3: block(3, "c", 30)}
+			ing = New(mockClient, time.Millisecond*1, logger, events, repo, WithReorgDepth(2))
+			mockClient.EXPECT().GetStatus(gomock.Any()).Return(smartblox.Status{LastRound: 3}, nil).AnyTimes()
+			mockClient.EXPECT().GetBlock(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, round int64) (smartblox.Block, error) {
+				return blocks[round], nil
+			}).AnyTimes()
+		})
+
+		It("should store what it ingests and roll replaced rounds back", func() {
+			ctx := context.Background()
+			Expect(ing.process(ctx)).To(Succeed())
+			m, err := repo.LoadMetrics(ctx)
+			Expect(err).To(BeNil())
+			Expect(m).To(Equal(models.Metrics{Count: 3, Sum: models.NewInt128(60), Min: 10, Max: 30, Mean: 20, M2: 200, LastRound: 3}))
+
+			blocks[3] = block(3, "d", 5)
+			Expect(ing.process(ctx)).To(Succeed())
+			m, err = repo.LoadMetrics(ctx)
+			Expect(err).To(BeNil())
+			Expect(m.Mean).To(BeNumerically("~", 35.0/3, 1e-9))
+			m.Mean, m.M2 = 0, 0
+			Expect(m).To(Equal(models.Metrics{Count: 3, Sum: models.NewInt128(35), Min: 5, Max: 20, LastRound: 3}))
+			from := int64(3)
+			stored, err := repo.QueryEvents(ctx, models.EventFilter{FromRound: &from})
+			Expect(err).To(BeNil())
+			Expect(stored).To(Equal([]models.Event{{Round: 3, Sig: "d", Sender: 1, Recipient: 2, Amount: 5}}))
+			a, err := repo.LoadAccount(ctx, 2)
+			Expect(err).To(BeNil())
+			Expect(a.Received).To(Equal(models.Flow{Count: 3, Volume: models.NewInt128(35), Min: 5, Max: 20}))
+		})
+		It("should notify the committed rounds and the rollbacks", func() {
+			n := &recordingNotifier{}
+			ing = New(mockClient, time.Millisecond*1, logger, events, repo, WithReorgDepth(2), WithNotifier(n))
+			ctx := context.Background()
+			Expect(ing.process(ctx)).To(Succeed())
+			blocks[3] = block(3, "d", 5)
+			Expect(ing.process(ctx)).To(Succeed())
+
+			Expect(n.calls).To(Equal([]string{"commit 1", "commit 2", "commit 3", "rollback 2", "commit 3"}))
+			Expect(n.events[3]).To(Equal([]models.Event{{Round: 3, Sig: "d", Sender: 1, Recipient: 2, Amount: 5}}))
+			Expect(n.metrics[3].Sum).To(Equal(models.NewInt128(35)))
+		})
+		It("should count a sig repeated in a round once and move the checkpoint past it", func() {
+			blocks[2] = smartblox.Block{Round: 2, Txs: append(block(2, "b", 20).Txs, block(2, "b", 20).Txs...)}
+			ctx := context.Background()
+			Expect(ing.process(ctx)).To(Succeed())
+
+			m, err := repo.LoadMetrics(ctx)
+			Expect(err).To(BeNil())
+			Expect(m.LastRound).To(Equal(int64(3)))
+			Expect(m.Count).To(Equal(int64(3)))
+			Expect(m.Sum).To(Equal(models.NewInt128(60)))
+		})
+		It("should leave out a sig stored by an earlier round and move the checkpoint past it", func() {
+			blocks[3] = smartblox.Block{Round: 3, Txs: append(block(3, "a", 10).Txs, block(3, "c", 30).Txs...)}
+			ctx := context.Background()
+			Expect(ing.process(ctx)).To(Succeed())
+
+			m, err := repo.LoadMetrics(ctx)
+			Expect(err).To(BeNil())
+			Expect(m.LastRound).To(Equal(int64(3)))
+			Expect(m.Count).To(Equal(int64(3)))
+			Expect(m.Sum).To(Equal(models.NewInt128(60)))
+			from := int64(3)
+			stored, err := repo.QueryEvents(ctx, models.EventFilter{FromRound: &from})
+			Expect(err).To(BeNil())
+			Expect(stored).To(Equal([]models.Event{{Round: 3, Sig: "c", Sender: 1, Recipient: 2, Amount: 30}}))
+		})
 	})
 })
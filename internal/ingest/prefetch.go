@@ -0,0 +1,72 @@
+package ingest
+
+import (
+	"context"
+	"time"
+
+	"github.com/rhuandantas/metrika/internal/smartblox"
+)
+
+// fetchedBlock is the outcome of fetching a single round.
+type fetchedBlock struct {
+	round   int64
+	block   smartblox.Block
+	elapsed time.Duration
+	err     error
+}
+
+// prefetch fetches the rounds from..to with up to i.concurrency blocks in flight or waiting to be
+// consumed, and delivers them strictly in round order. The returned stop function must be called
+// once the caller is done, it cancels outstanding requests.
+func (i *Ingestor) prefetch(ctx context.Context, from, to int64) (<-chan fetchedBlock, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	slots := make(chan struct{}, i.concurrency)
+	ordered := make(chan chan fetchedBlock, i.concurrency)
+	out := make(chan fetchedBlock)
+
+	// Producer: start one fetch per round, never more than the free slots allow.
+	go func() {
+		defer close(ordered)
+		for r := from; r <= to; r++ {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			res := make(chan fetchedBlock, 1)
+			go func(round int64) {
+				start := time.Now()
+				b, err := i.cli.GetBlock(ctx, round)
+				res <- fetchedBlock{round: round, block: b, elapsed: time.Since(start), err: err}
+			}(r)
+
+			select {
+			case ordered <- res:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Sequencer: hand results over in the order the fetches were started, freeing a slot for each.
+	go func() {
+		defer close(out)
+		for res := range ordered {
+			var f fetchedBlock
+			select {
+			case f = <-res:
+			case <-ctx.Done():
+				return
+			}
+			<-slots
+			select {
+			case out <- f:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, cancel
+}
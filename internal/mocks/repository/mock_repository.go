@@ -1,9 +1,9 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: internal/repository/sqlite.go
+// Source: internal/repository/repository.go
 //
 // Generated by this command:
 //
-//	mockgen -source=internal/repository/sqlite.go -destination=internal/mocks/repository/mock_repository.go -package=mock_repo
+//	mockgen -source=internal/repository/repository.go -destination=internal/mocks/repository/mock_repository.go -package=mock_repo
 //
 
 // Package mock_repo is a generated GoMock package.
@@ -12,6 +12,7 @@ package mock_repo
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	models "github.com/rhuandantas/metrika/internal/models"
 	gomock "go.uber.org/mock/gomock"
@@ -41,6 +42,34 @@ func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
 	return m.recorder
 }
 
+// CommitRound mocks base method.
+func (m *MockRepository) CommitRound(ctx context.Context, commit models.RoundCommit) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CommitRound", ctx, commit)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CommitRound indicates an expected call of CommitRound.
+func (mr *MockRepositoryMockRecorder) CommitRound(ctx, commit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CommitRound", reflect.TypeOf((*MockRepository)(nil).CommitRound), ctx, commit)
+}
+
+// CommitScopeRound mocks base method.
+func (m *MockRepository) CommitScopeRound(ctx context.Context, name string, commit models.RoundCommit) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CommitScopeRound", ctx, name, commit)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CommitScopeRound indicates an expected call of CommitScopeRound.
+func (mr *MockRepositoryMockRecorder) CommitScopeRound(ctx, name, commit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CommitScopeRound", reflect.TypeOf((*MockRepository)(nil).CommitScopeRound), ctx, name, commit)
+}
+
 // Init mocks base method.
 func (m *MockRepository) Init(ctx context.Context) error {
 	m.ctrl.T.Helper()
@@ -55,6 +84,36 @@ func (mr *MockRepositoryMockRecorder) Init(ctx any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Init", reflect.TypeOf((*MockRepository)(nil).Init), ctx)
 }
 
+// LoadAccount mocks base method.
+func (m *MockRepository) LoadAccount(ctx context.Context, id int64) (models.Account, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadAccount", ctx, id)
+	ret0, _ := ret[0].(models.Account)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoadAccount indicates an expected call of LoadAccount.
+func (mr *MockRepositoryMockRecorder) LoadAccount(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadAccount", reflect.TypeOf((*MockRepository)(nil).LoadAccount), ctx, id)
+}
+
+// LoadDistribution mocks base method.
+func (m *MockRepository) LoadDistribution(ctx context.Context) (*models.Distribution, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadDistribution", ctx)
+	ret0, _ := ret[0].(*models.Distribution)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoadDistribution indicates an expected call of LoadDistribution.
+func (mr *MockRepositoryMockRecorder) LoadDistribution(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadDistribution", reflect.TypeOf((*MockRepository)(nil).LoadDistribution), ctx)
+}
+
 // LoadMetrics mocks base method.
 func (m *MockRepository) LoadMetrics(ctx context.Context) (models.Metrics, error) {
 	m.ctrl.T.Helper()
@@ -70,6 +129,154 @@ func (mr *MockRepositoryMockRecorder) LoadMetrics(ctx any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadMetrics", reflect.TypeOf((*MockRepository)(nil).LoadMetrics), ctx)
 }
 
+// LoadScope mocks base method.
+func (m *MockRepository) LoadScope(ctx context.Context, name string) (models.Scope, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadScope", ctx, name)
+	ret0, _ := ret[0].(models.Scope)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoadScope indicates an expected call of LoadScope.
+func (mr *MockRepositoryMockRecorder) LoadScope(ctx, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadScope", reflect.TypeOf((*MockRepository)(nil).LoadScope), ctx, name)
+}
+
+// MetricsAt mocks base method.
+func (m *MockRepository) MetricsAt(ctx context.Context, round int64) (models.Metrics, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MetricsAt", ctx, round)
+	ret0, _ := ret[0].(models.Metrics)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MetricsAt indicates an expected call of MetricsAt.
+func (mr *MockRepositoryMockRecorder) MetricsAt(ctx, round any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MetricsAt", reflect.TypeOf((*MockRepository)(nil).MetricsAt), ctx, round)
+}
+
+// QueryEvents mocks base method.
+func (m *MockRepository) QueryEvents(ctx context.Context, filter models.EventFilter) ([]models.Event, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryEvents", ctx, filter)
+	ret0, _ := ret[0].([]models.Event)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryEvents indicates an expected call of QueryEvents.
+func (mr *MockRepositoryMockRecorder) QueryEvents(ctx, filter any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryEvents", reflect.TypeOf((*MockRepository)(nil).QueryEvents), ctx, filter)
+}
+
+// QueryRollups mocks base method.
+func (m *MockRepository) QueryRollups(ctx context.Context, period models.Period, from, to time.Time, limit int) ([]models.Rollup, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryRollups", ctx, period, from, to, limit)
+	ret0, _ := ret[0].([]models.Rollup)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryRollups indicates an expected call of QueryRollups.
+func (mr *MockRepositoryMockRecorder) QueryRollups(ctx, period, from, to, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryRollups", reflect.TypeOf((*MockRepository)(nil).QueryRollups), ctx, period, from, to, limit)
+}
+
+// QueryRounds mocks base method.
+func (m *MockRepository) QueryRounds(ctx context.Context, filter models.RoundFilter) ([]models.RoundStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryRounds", ctx, filter)
+	ret0, _ := ret[0].([]models.RoundStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryRounds indicates an expected call of QueryRounds.
+func (mr *MockRepositoryMockRecorder) QueryRounds(ctx, filter any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryRounds", reflect.TypeOf((*MockRepository)(nil).QueryRounds), ctx, filter)
+}
+
+// RecentRounds mocks base method.
+func (m *MockRepository) RecentRounds(ctx context.Context, n int) ([]models.RoundStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecentRounds", ctx, n)
+	ret0, _ := ret[0].([]models.RoundStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RecentRounds indicates an expected call of RecentRounds.
+func (mr *MockRepositoryMockRecorder) RecentRounds(ctx, n any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecentRounds", reflect.TypeOf((*MockRepository)(nil).RecentRounds), ctx, n)
+}
+
+// RepairMetrics mocks base method.
+func (m_2 *MockRepository) RepairMetrics(ctx context.Context, m models.Metrics) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "RepairMetrics", ctx, m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RepairMetrics indicates an expected call of RepairMetrics.
+func (mr *MockRepositoryMockRecorder) RepairMetrics(ctx, m any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RepairMetrics", reflect.TypeOf((*MockRepository)(nil).RepairMetrics), ctx, m)
+}
+
+// ResetScope mocks base method.
+func (m *MockRepository) ResetScope(ctx context.Context, name string, from, to int64) (models.Scope, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetScope", ctx, name, from, to)
+	ret0, _ := ret[0].(models.Scope)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResetScope indicates an expected call of ResetScope.
+func (mr *MockRepositoryMockRecorder) ResetScope(ctx, name, from, to any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetScope", reflect.TypeOf((*MockRepository)(nil).ResetScope), ctx, name, from, to)
+}
+
+// RollbackTo mocks base method.
+func (m *MockRepository) RollbackTo(ctx context.Context, round int64) (models.Metrics, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RollbackTo", ctx, round)
+	ret0, _ := ret[0].(models.Metrics)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RollbackTo indicates an expected call of RollbackTo.
+func (mr *MockRepositoryMockRecorder) RollbackTo(ctx, round any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RollbackTo", reflect.TypeOf((*MockRepository)(nil).RollbackTo), ctx, round)
+}
+
+// SaveEvents mocks base method.
+func (m *MockRepository) SaveEvents(ctx context.Context, events []models.Event) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveEvents", ctx, events)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveEvents indicates an expected call of SaveEvents.
+func (mr *MockRepositoryMockRecorder) SaveEvents(ctx, events any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveEvents", reflect.TypeOf((*MockRepository)(nil).SaveEvents), ctx, events)
+}
+
 // SaveMetrics mocks base method.
 func (m *MockRepository) SaveMetrics(ctx context.Context, metrics models.Metrics) error {
 	m.ctrl.T.Helper()
@@ -83,3 +290,18 @@ func (mr *MockRepositoryMockRecorder) SaveMetrics(ctx, metrics any) *gomock.Call
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveMetrics", reflect.TypeOf((*MockRepository)(nil).SaveMetrics), ctx, metrics)
 }
+
+// TopAccounts mocks base method.
+func (m *MockRepository) TopAccounts(ctx context.Context, by models.AccountRanking, limit int) ([]models.Account, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TopAccounts", ctx, by, limit)
+	ret0, _ := ret[0].([]models.Account)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TopAccounts indicates an expected call of TopAccounts.
+func (mr *MockRepositoryMockRecorder) TopAccounts(ctx, by, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TopAccounts", reflect.TypeOf((*MockRepository)(nil).TopAccounts), ctx, by, limit)
+}
@@ -0,0 +1,157 @@
+package models
+
+import "fmt"
+
+// Account aggregates the transfers an account took part in, as sender and as recipient.
+type Account struct {
+	ID       int64 `json:"id"`
+	Sent     Flow  `json:"sent"`
+	Received Flow  `json:"received"`
+	// FirstRound and LastRound are the rounds of the account's first and last transfer.
+	FirstRound int64 `json:"first_round"`
+	LastRound  int64 `json:"last_round"`
+}
+
+// Flow sums up the transfers going one way.
+type Flow struct {
+	Count  int64  `json:"count"`
+	Volume Int128 `json:"volume"`
+	// Min and Max are only meaningful when Count > 0.
+	Min int64 `json:"min"`
+	Max int64 `json:"max"`
+}
+
+// AccountRanking is what the accounts leaderboard is ordered by.
+type AccountRanking string
+
+const (
+	RankSentVolume     AccountRanking = "sent_volume"
+	RankReceivedVolume AccountRanking = "received_volume"
+	RankSentCount      AccountRanking = "sent_count"
+	RankReceivedCount  AccountRanking = "received_count"
+)
+
+// ParseAccountRanking reads one of the AccountRanking values.
+func ParseAccountRanking(s string) (AccountRanking, error) {
+	switch r := AccountRanking(s); r {
+	case RankSentVolume, RankReceivedVolume, RankSentCount, RankReceivedCount:
+		return r, nil
+	}
+	return "", fmt.Errorf("unknown ranking %q, want sent_volume, received_volume, sent_count or received_count", s)
+}
+
+// Transfers returns the number of transfers the account took part in. A transfer to itself counts twice.
+func (a *Account) Transfers() int64 {
+	return a.Sent.Count + a.Received.Count
+}
+
+// Add counts the event for the account, as sent, received or both.
+func (a *Account) Add(e Event) error {
+	first := a.Transfers() == 0
+	if e.Sender == a.ID {
+		if err := a.Sent.add(e.Amount); err != nil {
+			return fmt.Errorf("account %d sent: %w", a.ID, err)
+		}
+	}
+	if e.Recipient == a.ID {
+		if err := a.Received.add(e.Amount); err != nil {
+			return fmt.Errorf("account %d received: %w", a.ID, err)
+		}
+	}
+	if first || e.Round < a.FirstRound {
+		a.FirstRound = e.Round
+	}
+	if e.Round > a.LastRound {
+		a.LastRound = e.Round
+	}
+	return nil
+}
+
+// Merge adds the transfers counted in other, an aggregate of the same account.
+func (a *Account) Merge(other Account) error {
+	if other.Transfers() == 0 {
+		return nil
+	}
+	if a.Transfers() == 0 {
+		*a = other
+		return nil
+	}
+	if err := a.Sent.merge(other.Sent); err != nil {
+		return fmt.Errorf("account %d sent: %w", a.ID, err)
+	}
+	if err := a.Received.merge(other.Received); err != nil {
+		return fmt.Errorf("account %d received: %w", a.ID, err)
+	}
+	a.FirstRound = min(a.FirstRound, other.FirstRound)
+	a.LastRound = max(a.LastRound, other.LastRound)
+	return nil
+}
+
+// Accounts holds account aggregates by ID.
+type Accounts map[int64]*Account
+
+// AccountsOf aggregates the events per account.
+func AccountsOf(events []Event) (Accounts, error) {
+	accounts := make(Accounts)
+	for _, e := range events {
+		if err := accounts.Add(e); err != nil {
+			return nil, err
+		}
+	}
+	return accounts, nil
+}
+
+// Add counts the event for both its sender and its recipient.
+func (as Accounts) Add(e Event) error {
+	if err := as.get(e.Sender).Add(e); err != nil {
+		return err
+	}
+	if e.Recipient != e.Sender {
+		return as.get(e.Recipient).Add(e)
+	}
+	return nil
+}
+
+func (as Accounts) get(id int64) *Account {
+	a, ok := as[id]
+	if !ok {
+		a = &Account{ID: id}
+		as[id] = a
+	}
+	return a
+}
+
+func (f *Flow) add(amount int64) error {
+	volume, err := f.Volume.Add(NewInt128(amount))
+	if err != nil {
+		return err
+	}
+	if f.Count == 0 || amount < f.Min {
+		f.Min = amount
+	}
+	if f.Count == 0 || amount > f.Max {
+		f.Max = amount
+	}
+	f.Count++
+	f.Volume = volume
+	return nil
+}
+
+func (f *Flow) merge(other Flow) error {
+	if other.Count == 0 {
+		return nil
+	}
+	if f.Count == 0 {
+		*f = other
+		return nil
+	}
+	volume, err := f.Volume.Add(other.Volume)
+	if err != nil {
+		return err
+	}
+	f.Count += other.Count
+	f.Volume = volume
+	f.Min = min(f.Min, other.Min)
+	f.Max = max(f.Max, other.Max)
+	return nil
+}
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/DataDog/sketches-go/ddsketch"
+	"github.com/DataDog/sketches-go/ddsketch/store"
+)
+
+const (
+	// sketchAccuracy is the relative error of the quantiles: a p99 of 1000 lies between 990 and 1010.
+	sketchAccuracy = 0.01
+	// sketchMaxBins bounds the sketch size. 2048 bins at 1% cover amounts spanning 17 orders of magnitude
+	// before the lowest ones start being collapsed.
+	sketchMaxBins = 2048
+)
+
+// HistogramBounds are the inclusive upper bounds of the amount histogram buckets, a last bucket
+// holds the amounts above the highest bound.
+var HistogramBounds = []int64{0, 1, 10, 100, 1_000, 10_000, 100_000, 1_000_000, 10_000_000, 100_000_000, 1_000_000_000, 10_000_000_000, 100_000_000_000, 1_000_000_000_000}
+
+// Distribution describes how transfer amounts are spread: a DDSketch for quantiles and a
+// fixed-bucket histogram. Both can be merged, and the amounts recorded can be removed again.
+type Distribution struct {
+	sketch  *ddsketch.DDSketch
+	buckets []int64
+}
+
+// Bucket is a histogram bucket, UpperBound is nil for the last one.
+type Bucket struct {
+	UpperBound *int64 `json:"le"`
+	Count      int64  `json:"count"`
+}
+
+func NewDistribution() *Distribution {
+	sketch, err := ddsketch.LogCollapsingLowestDenseDDSketch(sketchAccuracy, sketchMaxBins)
+	if err != nil {
+		// Only fails on an invalid accuracy, which is a constant.
+		panic(err)
+	}
+	return &Distribution{sketch: sketch, buckets: make([]int64, len(HistogramBounds)+1)}
+}
+
+// Add records an amount.
+func (d *Distribution) Add(amount int64) {
+	// The sketch only rejects NaN and infinities, which an int64 cannot be.
+	_ = d.sketch.Add(float64(amount))
+	d.buckets[bucketOf(amount)]++
+}
+
+// Remove takes amounts recorded by Add out again, so that rolling rounds back does not mean
+// rebuilding the distribution from every amount that remains. Removing an amount that was not
+// recorded leaves the distribution wrong.
+func (d *Distribution) Remove(amounts ...int64) error {
+	var zeros float64
+	for _, amount := range amounts {
+		// The sketch only takes positive counts, its stores take negative ones too.
+		switch v := float64(amount); {
+		case v > d.sketch.MinIndexableValue():
+			d.sketch.GetPositiveValueStore().AddWithCount(d.sketch.Index(v), -1)
+		case v < -d.sketch.MinIndexableValue():
+			d.sketch.GetNegativeValueStore().AddWithCount(d.sketch.Index(-v), -1)
+		default:
+			zeros++
+		}
+		d.buckets[bucketOf(amount)]--
+	}
+	if zeros == 0 {
+		return nil
+	}
+	// The count of zeros can only be set through the protobuf form of the sketch.
+	pb := d.sketch.ToProto()
+	pb.ZeroCount -= zeros
+	sketch, err := ddsketch.FromProtoWithStoreProvider(pb, newSketchStore)
+	if err != nil {
+		return err
+	}
+	d.sketch = sketch
+	return nil
+}
+
+// bucketOf returns the index of the histogram bucket holding the amount.
+func bucketOf(amount int64) int {
+	for i, bound := range HistogramBounds {
+		if amount <= bound {
+			return i
+		}
+	}
+	return len(HistogramBounds)
+}
+
+func newSketchStore() store.Store {
+	return store.NewCollapsingLowestDenseStore(sketchMaxBins)
+}
+
+// Merge adds the amounts recorded by other.
+func (d *Distribution) Merge(other *Distribution) error {
+	if err := d.sketch.MergeWith(other.sketch); err != nil {
+		return err
+	}
+	for i, n := range other.buckets {
+		d.buckets[i] += n
+	}
+	return nil
+}
+
+// Count returns the number of recorded amounts.
+func (d *Distribution) Count() int64 {
+	return int64(d.sketch.GetCount())
+}
+
+// Quantile returns the amount at quantile q, between 0 and 1, within the sketch accuracy.
+// It returns false when nothing was recorded.
+func (d *Distribution) Quantile(q float64) (float64, bool) {
+	if d.sketch.IsEmpty() {
+		return 0, false
+	}
+	v, err := d.sketch.GetValueAtQuantile(q)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// Buckets returns the histogram, each bucket counting the amounts above the previous bound.
+func (d *Distribution) Buckets() []Bucket {
+	out := make([]Bucket, len(d.buckets))
+	for i, n := range d.buckets {
+		out[i].Count = n
+		if i < len(HistogramBounds) {
+			out[i].UpperBound = &HistogramBounds[i]
+		}
+	}
+	return out
+}
+
+type distributionJSON struct {
+	Sketch  []byte  `json:"sketch"`
+	Buckets []int64 `json:"buckets"`
+}
+
+func (d *Distribution) MarshalBinary() ([]byte, error) {
+	var sketch []byte
+	d.sketch.Encode(&sketch, false)
+	return json.Marshal(distributionJSON{Sketch: sketch, Buckets: d.buckets})
+}
+
+func (d *Distribution) UnmarshalBinary(data []byte) error {
+	var raw distributionJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw.Buckets) != len(HistogramBounds)+1 {
+		return fmt.Errorf("distribution has %d histogram buckets, want %d", len(raw.Buckets), len(HistogramBounds)+1)
+	}
+	sketch, err := ddsketch.DecodeDDSketch(raw.Sketch, newSketchStore, nil)
+	if err != nil {
+		return err
+	}
+	d.sketch, d.buckets = sketch, raw.Buckets
+	return nil
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Distribution", func() {
+	quantile := func(d *Distribution, q float64) float64 {
+		v, ok := d.Quantile(q)
+		Expect(ok).To(BeTrue())
+		return v
+	}
+
+	It("should answer quantiles from the amounts left after a removal", func() {
+		d := NewDistribution()
+		for a := int64(1); a <= 100; a++ {
+			d.Add(a)
+		}
+		rolledBack := []int64{0, -50, 5_000, 10_000, 10_000}
+		for _, a := range rolledBack {
+			d.Add(a)
+		}
+		Expect(quantile(d, 1)).To(BeNumerically("~", 10_000, 100))
+
+		Expect(d.Remove(rolledBack...)).To(Succeed())
+		Expect(d.Count()).To(Equal(int64(100)))
+		Expect(quantile(d, 0)).To(BeNumerically("~", 1, 0.01))
+		Expect(quantile(d, 0.5)).To(BeNumerically("~", 50, 1))
+		Expect(quantile(d, 0.99)).To(BeNumerically("~", 99, 1))
+		Expect(quantile(d, 1)).To(BeNumerically("~", 100, 1))
+
+		want := NewDistribution()
+		for a := int64(1); a <= 100; a++ {
+			want.Add(a)
+		}
+		Expect(d.Buckets()).To(Equal(want.Buckets()))
+	})
+	It("should be empty once every amount is removed", func() {
+		d := NewDistribution()
+		d.Add(0)
+		d.Add(7)
+		Expect(d.Remove(7, 0)).To(Succeed())
+		Expect(d.Count()).To(BeZero())
+		_, ok := d.Quantile(0.5)
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -7,3 +7,38 @@ type Event struct {
 	Recipient int64  `json:"recipient"`
 	Amount    int64  `json:"amount"`
 }
+
+// EventFilter selects stored events. Nil fields are not filtered on and ranges are inclusive.
+type EventFilter struct {
+	FromRound *int64
+	ToRound   *int64
+	Sender    *int64
+	Recipient *int64
+	MinAmount *int64
+	MaxAmount *int64
+	// After resumes a listing right after the given event, in (round, sig) order.
+	After *EventCursor
+	// Limit caps the number of events returned, 0 means no limit.
+	Limit int
+}
+
+// Match reports whether the event is selected by the filter, ignoring its limit.
+func (f EventFilter) Match(e Event) bool {
+	switch {
+	case f.FromRound != nil && e.Round < *f.FromRound,
+		f.ToRound != nil && e.Round > *f.ToRound,
+		f.Sender != nil && e.Sender != *f.Sender,
+		f.Recipient != nil && e.Recipient != *f.Recipient,
+		f.MinAmount != nil && e.Amount < *f.MinAmount,
+		f.MaxAmount != nil && e.Amount > *f.MaxAmount,
+		f.After != nil && (e.Round < f.After.Round || e.Round == f.After.Round && e.Sig <= f.After.Sig):
+		return false
+	}
+	return true
+}
+
+// EventCursor is the position of an event in (round, sig) order.
+type EventCursor struct {
+	Round int64
+	Sig   string
+}
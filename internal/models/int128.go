@@ -0,0 +1,179 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+)
+
+// ErrOverflow is returned when a result does not fit the type it is computed in.
+var ErrOverflow = errors.New("integer overflow")
+
+// Int128 is a signed 128-bit integer in two's complement. The zero value is 0. It is a plain value,
+// so structs holding one can be copied freely, and every operation reports overflow instead of
+// wrapping around. It is encoded as a decimal number in JSON and as a decimal string in SQL.
+type Int128 struct {
+	hi int64
+	lo uint64
+}
+
+// NewInt128 converts an int64.
+func NewInt128(v int64) Int128 {
+	// Sign-extend into the high half.
+	return Int128{hi: v >> 63, lo: uint64(v)}
+}
+
+// ParseInt128 reads a base 10 integer.
+func ParseInt128(s string) (Int128, error) {
+	b, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return Int128{}, fmt.Errorf("invalid integer %q", s)
+	}
+	return int128FromBig(b)
+}
+
+var (
+	maxInt128 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 127), big.NewInt(1))
+	minInt128 = new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), 127))
+)
+
+func int128FromBig(b *big.Int) (Int128, error) {
+	if b.Cmp(maxInt128) > 0 || b.Cmp(minInt128) < 0 {
+		return Int128{}, fmt.Errorf("%s: %w", b, ErrOverflow)
+	}
+	abs := new(big.Int).Abs(b)
+	v := Int128{hi: int64(new(big.Int).Rsh(abs, 64).Uint64()), lo: abs.Uint64()}
+	if b.Sign() < 0 {
+		v = v.neg()
+	}
+	return v, nil
+}
+
+func (a Int128) big() *big.Int {
+	neg := a.hi < 0
+	if neg {
+		// The magnitude of the smallest value does not fit an Int128, but it does fit its bits as unsigned.
+		a = a.neg()
+	}
+	b := new(big.Int).SetUint64(uint64(a.hi))
+	b.Lsh(b, 64).Or(b, new(big.Int).SetUint64(a.lo))
+	if neg {
+		b.Neg(b)
+	}
+	return b
+}
+
+// neg returns -a, wrapping around for the smallest value.
+func (a Int128) neg() Int128 {
+	lo, borrow := bits.Sub64(0, a.lo, 0)
+	hi, _ := bits.Sub64(0, uint64(a.hi), borrow)
+	return Int128{hi: int64(hi), lo: lo}
+}
+
+// Add returns a+b, or ErrOverflow.
+func (a Int128) Add(b Int128) (Int128, error) {
+	lo, carry := bits.Add64(a.lo, b.lo, 0)
+	hi, _ := bits.Add64(uint64(a.hi), uint64(b.hi), carry)
+	sum := Int128{hi: int64(hi), lo: lo}
+	// Adding operands of the same sign overflows when the result has the other sign.
+	if (a.hi < 0) == (b.hi < 0) && (sum.hi < 0) != (a.hi < 0) {
+		return Int128{}, ErrOverflow
+	}
+	return sum, nil
+}
+
+// Sub returns a-b, or ErrOverflow.
+func (a Int128) Sub(b Int128) (Int128, error) {
+	lo, borrow := bits.Sub64(a.lo, b.lo, 0)
+	hi, _ := bits.Sub64(uint64(a.hi), uint64(b.hi), borrow)
+	diff := Int128{hi: int64(hi), lo: lo}
+	// Subtracting an operand of the other sign overflows when the result changes sign.
+	if (a.hi < 0) != (b.hi < 0) && (diff.hi < 0) != (a.hi < 0) {
+		return Int128{}, ErrOverflow
+	}
+	return diff, nil
+}
+
+// Cmp returns -1, 0 or 1 as a is less than, equal to or greater than b.
+func (a Int128) Cmp(b Int128) int {
+	switch {
+	case a.hi < b.hi:
+		return -1
+	case a.hi > b.hi:
+		return 1
+	case a.lo < b.lo:
+		return -1
+	case a.lo > b.lo:
+		return 1
+	}
+	return 0
+}
+
+// Int64 returns the value and whether it fits an int64.
+func (a Int128) Int64() (int64, bool) {
+	v := int64(a.lo)
+	return v, a.hi == v>>63
+}
+
+// Float64 returns the nearest float64.
+func (a Int128) Float64() float64 {
+	if v, ok := a.Int64(); ok {
+		return float64(v)
+	}
+	f, _ := new(big.Float).SetInt(a.big()).Float64()
+	return f
+}
+
+func (a Int128) String() string {
+	if v, ok := a.Int64(); ok {
+		return fmt.Sprint(v)
+	}
+	return a.big().String()
+}
+
+func (a Int128) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Int128) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	// Accept quoted values too, for clients that cannot hold large numbers.
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	v, err := ParseInt128(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// Value stores the decimal string, so the database keeps every digit.
+func (a Int128) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+// Scan reads a decimal string, or an integer from a column that predates Int128.
+func (a *Int128) Scan(src any) error {
+	var err error
+	switch v := src.(type) {
+	case int64:
+		*a = NewInt128(v)
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) >= math.MaxInt64 {
+			return fmt.Errorf("cannot scan %v into Int128", v)
+		}
+		*a = NewInt128(int64(v))
+	case string:
+		*a, err = ParseInt128(v)
+	case []byte:
+		*a, err = ParseInt128(string(v))
+	default:
+		return fmt.Errorf("cannot scan %T into Int128", src)
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestModels(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Models Suite")
+}
+
+// mustParse parses a base 10 integer that fits an Int128.
+func mustParse(s string) Int128 {
+	v, err := ParseInt128(s)
+	Expect(err).To(BeNil())
+	return v
+}
+
+var _ = Describe("Int128", func() {
+	const (
+		max128 = "170141183460469231731687303715884105727"
+		min128 = "-170141183460469231731687303715884105728"
+	)
+	one := NewInt128(1)
+
+	It("should carry into the high half past 2^63 and back", func() {
+		sum, err := NewInt128(math.MaxInt64).Add(one)
+		Expect(err).To(BeNil())
+		Expect(sum.String()).To(Equal("9223372036854775808"))
+		_, fits := sum.Int64()
+		Expect(fits).To(BeFalse())
+
+		back, err := sum.Sub(one)
+		Expect(err).To(BeNil())
+		v, ok := back.Int64()
+		Expect(ok).To(BeTrue())
+		Expect(v).To(Equal(int64(math.MaxInt64)))
+	})
+	It("should borrow from the high half below -2^63 and back", func() {
+		diff, err := NewInt128(math.MinInt64).Sub(one)
+		Expect(err).To(BeNil())
+		Expect(diff.String()).To(Equal("-9223372036854775809"))
+		_, fits := diff.Int64()
+		Expect(fits).To(BeFalse())
+
+		back, err := diff.Add(one)
+		Expect(err).To(BeNil())
+		v, ok := back.Int64()
+		Expect(ok).To(BeTrue())
+		Expect(v).To(Equal(int64(math.MinInt64)))
+	})
+	It("should carry a full low half", func() {
+		sum, err := mustParse("18446744073709551615").Add(one)
+		Expect(err).To(BeNil())
+		Expect(sum.String()).To(Equal("18446744073709551616"))
+		Expect(sum.Cmp(mustParse("18446744073709551616"))).To(BeZero())
+	})
+	It("should report overflow at ±2^127", func() {
+		max, min := mustParse(max128), mustParse(min128)
+		Expect(max.Add(one)).Error().To(MatchError(ErrOverflow))
+		Expect(max.Sub(NewInt128(-1))).Error().To(MatchError(ErrOverflow))
+		Expect(min.Sub(one)).Error().To(MatchError(ErrOverflow))
+		Expect(min.Add(NewInt128(-1))).Error().To(MatchError(ErrOverflow))
+
+		sum, err := min.Add(max)
+		Expect(err).To(BeNil())
+		Expect(sum).To(Equal(NewInt128(-1)))
+		diff, err := max.Sub(max)
+		Expect(err).To(BeNil())
+		Expect(diff).To(Equal(Int128{}))
+	})
+	It("should parse up to the limits only", func() {
+		Expect(mustParse(max128).String()).To(Equal(max128))
+		Expect(mustParse(min128).String()).To(Equal(min128))
+		Expect(ParseInt128("170141183460469231731687303715884105728")).Error().To(MatchError(ErrOverflow))
+		Expect(ParseInt128("-170141183460469231731687303715884105729")).Error().To(MatchError(ErrOverflow))
+		Expect(ParseInt128("12a")).Error().To(HaveOccurred())
+	})
+	It("should order values across the halves", func() {
+		ordered := []Int128{mustParse(min128), mustParse("-9223372036854775809"), NewInt128(math.MinInt64), NewInt128(-1), {}, NewInt128(math.MaxInt64), mustParse("9223372036854775808"), mustParse(max128)}
+		for i := 1; i < len(ordered); i++ {
+			Expect(ordered[i-1].Cmp(ordered[i])).To(Equal(-1), "%s < %s", ordered[i-1], ordered[i])
+			Expect(ordered[i].Cmp(ordered[i-1])).To(Equal(1))
+		}
+		Expect(mustParse(max128).Float64()).To(Equal(math.Pow(2, 127)))
+	})
+	It("should round-trip through JSON and SQL", func() {
+		v := mustParse(min128)
+		data, err := json.Marshal(v)
+		Expect(err).To(BeNil())
+		Expect(string(data)).To(Equal(min128))
+		var decoded Int128
+		Expect(json.Unmarshal(data, &decoded)).To(Succeed())
+		Expect(decoded).To(Equal(v))
+		Expect(json.Unmarshal([]byte(`"`+max128+`"`), &decoded)).To(Succeed())
+		Expect(decoded).To(Equal(mustParse(max128)))
+
+		stored, err := v.Value()
+		Expect(err).To(BeNil())
+		var scanned Int128
+		Expect(scanned.Scan(stored)).To(Succeed())
+		Expect(scanned).To(Equal(v))
+		Expect(scanned.Scan([]byte("42"))).To(Succeed())
+		Expect(scanned).To(Equal(NewInt128(42)))
+		Expect(scanned.Scan(int64(-7))).To(Succeed())
+		Expect(scanned).To(Equal(NewInt128(-7)))
+		Expect(scanned.Scan(1.5)).To(HaveOccurred())
+	})
+})
@@ -1,37 +1,115 @@
 package models
 
-import "math"
+import (
+	"fmt"
+	"math"
+)
 
-// Metrics keeps running stats; Average is derived (Sum/Count).
+// Metrics keeps running stats; Average is derived (Sum/Count). Sum is 128 bits wide, so it cannot
+// overflow before Count does.
+// Mean and M2 are maintained with Welford's online algorithm, M2 being the sum of squared
+// differences from the mean, so the spread is computed without a sum of squares that would
+// overflow or lose precision.
 type Metrics struct {
-	Count     int64 `json:"count"`
-	Sum       int64 `json:"sum"`
-	Min       int64 `json:"min"`
-	Max       int64 `json:"max"`
-	LastRound int64 `json:"last_round"`
+	Count     int64   `json:"count"`
+	Sum       Int128  `json:"sum"`
+	Min       int64   `json:"min"`
+	Max       int64   `json:"max"`
+	Mean      float64 `json:"mean"`
+	M2        float64 `json:"m2"`
+	LastRound int64   `json:"last_round"`
 }
 
 func NewMetrics() Metrics {
 	return Metrics{Min: math.MaxInt64}
 }
 
-func (m *Metrics) Update(amount int64, round int64) {
+// Update counts a transfer. It returns ErrOverflow, leaving m unchanged, if the count or the sum would overflow.
+func (m *Metrics) Update(amount int64, round int64) error {
+	if m.Count == math.MaxInt64 {
+		return fmt.Errorf("count: %w", ErrOverflow)
+	}
+	sum, err := m.Sum.Add(NewInt128(amount))
+	if err != nil {
+		return fmt.Errorf("sum: %w", err)
+	}
 	m.Count++
-	m.Sum += amount
+	m.Sum = sum
 	if amount < m.Min {
 		m.Min = amount
 	}
 	if amount > m.Max {
 		m.Max = amount
 	}
+	delta := float64(amount) - m.Mean
+	m.Mean += delta / float64(m.Count)
+	m.M2 += delta * (float64(amount) - m.Mean)
 	if round > m.LastRound {
 		m.LastRound = round
 	}
+	return nil
+}
+
+// AddRound counts a round's transfers from its stats, merging its spread in with Chan's parallel
+// formula and moving LastRound to the round. It returns ErrOverflow, leaving m unchanged, if the count
+// or the sum would overflow.
+func (m *Metrics) AddRound(st RoundStats) error {
+	if st.Count > 0 {
+		if m.Count > math.MaxInt64-st.Count {
+			return fmt.Errorf("count: %w", ErrOverflow)
+		}
+		sum, err := m.Sum.Add(st.Sum)
+		if err != nil {
+			return fmt.Errorf("sum: %w", err)
+		}
+		count := m.Count + st.Count
+		delta := st.Mean - m.Mean
+		m.M2 += st.M2 + delta*delta*float64(m.Count)*float64(st.Count)/float64(count)
+		m.Mean += delta * float64(st.Count) / float64(count)
+		m.Count = count
+		m.Sum = sum
+		m.Min = min(m.Min, st.Min)
+		m.Max = max(m.Max, st.Max)
+	}
+	if st.Round > m.LastRound {
+		m.LastRound = st.Round
+	}
+	return nil
+}
+
+// RemoveSpread takes n amounts with the given mean and M2 out of Mean and M2, the reverse of
+// merging them in. Count must still include them; Count and Sum are left to the caller.
+func (m *Metrics) RemoveSpread(n int64, mean, m2 float64) {
+	if n <= 0 {
+		return
+	}
+	kept := m.Count - n
+	if kept <= 0 {
+		m.Mean, m.M2 = 0, 0
+		return
+	}
+	keptMean := (float64(m.Count)*m.Mean - float64(n)*mean) / float64(kept)
+	delta := mean - keptMean
+	m.M2 = max(m.M2-m2-delta*delta*float64(kept)*float64(n)/float64(m.Count), 0)
+	m.Mean = keptMean
 }
 
 func (m *Metrics) Average() float64 {
 	if m.Count == 0 {
 		return 0
 	}
-	return float64(m.Sum) / float64(m.Count)
+	return m.Sum.Float64() / float64(m.Count)
+}
+
+// Variance is the population variance of the amounts.
+func (m *Metrics) Variance() float64 {
+	if m.Count == 0 {
+		return 0
+	}
+	return m.M2 / float64(m.Count)
+}
+
+// StdDev is the population standard deviation of the amounts.
+func (m *Metrics) StdDev() float64 {
+	return math.Sqrt(m.Variance())
 }
@@ -0,0 +1,45 @@
+package models
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	// of returns the metrics of the amounts and the round stats holding them, as of the round.
+	of := func(round int64, amounts ...int64) (Metrics, RoundStats) {
+		m := NewMetrics()
+		for _, a := range amounts {
+			Expect(m.Update(a, round)).To(Succeed())
+		}
+		return m, RoundStats{Round: round, Count: m.Count, Sum: m.Sum, Min: m.Min, Max: m.Max, Mean: m.Mean, M2: m.M2}
+	}
+
+	It("should merge a round's spread as if its amounts were counted one by one", func() {
+		merged, _ := of(1, 10, 20, 30)
+		_, st := of(2, -5, 1_000, 7)
+		Expect(merged.AddRound(st)).To(Succeed())
+
+		want, _ := of(2, 10, 20, 30, -5, 1_000, 7)
+		Expect(merged.Mean).To(BeNumerically("~", want.Mean, 1e-9))
+		Expect(merged.M2).To(BeNumerically("~", want.M2, 1e-6))
+		merged.Mean, merged.M2, want.Mean, want.M2 = 0, 0, 0, 0
+		Expect(merged).To(Equal(want))
+	})
+	It("should take a merged round's spread back out", func() {
+		m, _ := of(1, 10, 20, 30, 45)
+		orig := m
+		_, st := of(2, -5, 1_000, 7)
+		Expect(m.AddRound(st)).To(Succeed())
+
+		m.RemoveSpread(st.Count, st.Mean, st.M2)
+		Expect(m.Mean).To(BeNumerically("~", orig.Mean, 1e-9))
+		Expect(m.M2).To(BeNumerically("~", orig.M2, 1e-6))
+	})
+	It("should reset the spread when every amount is taken out", func() {
+		m, st := of(1, 10, 20)
+		m.RemoveSpread(st.Count, st.Mean, st.M2)
+		Expect(m.Mean).To(BeZero())
+		Expect(m.M2).To(BeZero())
+	})
+})
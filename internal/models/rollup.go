@@ -0,0 +1,85 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Period is the width of a rollup bucket. Buckets start on UTC hour and day boundaries.
+type Period string
+
+const (
+	PeriodHour Period = "hour"
+	PeriodDay  Period = "day"
+)
+
+// Periods lists every period rollups are kept for.
+var Periods = []Period{PeriodHour, PeriodDay}
+
+// ParsePeriod reads "hour" or "day".
+func ParsePeriod(s string) (Period, error) {
+	switch p := Period(s); p {
+	case PeriodHour, PeriodDay:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown period %q, want hour or day", s)
+}
+
+// Duration returns the width of the period.
+func (p Period) Duration() time.Duration {
+	if p == PeriodDay {
+		return 24 * time.Hour
+	}
+	return time.Hour
+}
+
+// Start returns the start of the bucket holding t.
+func (p Period) Start(t time.Time) time.Time {
+	return t.UTC().Truncate(p.Duration())
+}
+
+// Rollup sums up the rounds ingested during one period.
+type Rollup struct {
+	Period Period    `json:"period"`
+	Start  time.Time `json:"start"`
+	Rounds int64     `json:"rounds"`
+	// TxCount counts every transaction of the rounds, Count only the transfers.
+	TxCount int64  `json:"tx_count"`
+	Count   int64  `json:"count"`
+	Volume  Int128 `json:"volume"`
+	// Min and Max are only meaningful when Count > 0.
+	Min int64 `json:"min"`
+	Max int64 `json:"max"`
+}
+
+// Add counts a round ingested during the rollup's period.
+func (r *Rollup) Add(st RoundStats) error {
+	volume, err := r.Volume.Add(st.Sum)
+	if err != nil {
+		return err
+	}
+	if st.Count > 0 {
+		if r.Count == 0 || st.Min < r.Min {
+			r.Min = st.Min
+		}
+		if r.Count == 0 || st.Max > r.Max {
+			r.Max = st.Max
+		}
+	}
+	r.Rounds++
+	r.TxCount += st.TxCount
+	r.Count += st.Count
+	r.Volume = volume
+	return nil
+}
+
+// RoundFilter selects round stats. Nil fields are not filtered on and ranges are inclusive,
+// except To, which is exclusive.
+type RoundFilter struct {
+	FromRound *int64
+	ToRound   *int64
+	From      *time.Time
+	To        *time.Time
+	// Limit caps the number of rounds returned, 0 means no limit.
+	Limit int
+}
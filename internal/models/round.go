@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// RoundCommit is everything a processed round writes to the repository in a single transaction.
+type RoundCommit struct {
+	Round int64
+	// Hash identifies the block the round was built from, to detect when upstream replaces it.
+	Hash    string
+	Events  []Event
+	Metrics Metrics
+	// TxCount is the number of transactions in the block, transfers or not.
+	TxCount int64
+	// IngestedAt is when the round was processed, the time its rollups are bucketed by.
+	IngestedAt time.Time
+	// Snapshot keeps a copy of Metrics, the starting point of point-in-time queries of later rounds.
+	Snapshot bool
+}
+
+// RoundStats is a single round's own contribution to the metrics.
+// It is kept per round so a replaced round can be subtracted from the running totals.
+type RoundStats struct {
+	Round int64  `json:"round"`
+	Hash  string `json:"hash"`
+	// TxCount counts every transaction of the round, Count only the transfers.
+	TxCount int64  `json:"tx_count"`
+	Count   int64  `json:"count"`
+	Sum     Int128 `json:"sum"`
+	// Min and Max are only meaningful when Count > 0.
+	Min int64 `json:"min"`
+	Max int64 `json:"max"`
+	// Mean and M2 are the spread of the round's own amounts, see Metrics.
+	Mean float64 `json:"mean"`
+	M2   float64 `json:"m2"`
+	// IngestedAt is zero for rounds committed before it was recorded.
+	IngestedAt time.Time `json:"ingested_at"`
+}
+
+// Stats summarizes the commit's events as the round's contribution.
+func (c RoundCommit) Stats() RoundStats {
+	s := RoundStats{Round: c.Round, Hash: c.Hash, TxCount: c.TxCount, IngestedAt: c.IngestedAt}
+	for i, e := range c.Events {
+		s.Count++
+		// A round cannot hold the 2^64 transfers it would take to overflow the sum.
+		s.Sum, _ = s.Sum.Add(NewInt128(e.Amount))
+		if i == 0 || e.Amount < s.Min {
+			s.Min = e.Amount
+		}
+		if i == 0 || e.Amount > s.Max {
+			s.Max = e.Amount
+		}
+		delta := float64(e.Amount) - s.Mean
+		s.Mean += delta / float64(s.Count)
+		s.M2 += delta * (float64(e.Amount) - s.Mean)
+	}
+	return s
+}
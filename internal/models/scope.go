@@ -0,0 +1,25 @@
+package models
+
+// Scope holds metrics computed over a fixed round range, apart from the live checkpoint.
+// It is filled by backfills, which replay history without moving the live last_round.
+type Scope struct {
+	Name      string `json:"name"`
+	FromRound int64  `json:"from_round"`
+	ToRound   int64  `json:"to_round"`
+	// Metrics.LastRound is the last round of the range applied so far.
+	Metrics Metrics `json:"metrics"`
+	// Distribution describes the amounts counted in Metrics.
+	Distribution *Distribution `json:"-"`
+}
+
+// NewScope returns an empty scope over the rounds from..to.
+func NewScope(name string, from, to int64) Scope {
+	m := NewMetrics()
+	m.LastRound = from - 1
+	return Scope{Name: name, FromRound: from, ToRound: to, Metrics: m, Distribution: NewDistribution()}
+}
+
+// Done reports whether every round of the range was applied.
+func (s Scope) Done() bool {
+	return s.Metrics.LastRound >= s.ToRound
+}
@@ -0,0 +1,219 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WindowSpec is the extent of a sliding window: either the last Rounds rounds or the rounds ingested
+// during the last Span.
+type WindowSpec struct {
+	Rounds int64
+	Span   time.Duration
+}
+
+// ParseWindowSpec reads a round count such as "100" or a duration such as "15m".
+func ParseWindowSpec(s string) (WindowSpec, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n <= 0 {
+			return WindowSpec{}, fmt.Errorf("window %q: must hold at least one round", s)
+		}
+		return WindowSpec{Rounds: n}, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return WindowSpec{}, fmt.Errorf("window %q: want a round count or a duration", s)
+	}
+	if d <= 0 {
+		return WindowSpec{}, fmt.Errorf("window %q: must be positive", s)
+	}
+	return WindowSpec{Span: d}, nil
+}
+
+// ParseWindowSpecs reads a comma-separated list of windows, such as "100,1h". An empty list is valid.
+func ParseWindowSpecs(s string) ([]WindowSpec, error) {
+	var specs []WindowSpec
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		spec, err := ParseWindowSpec(f)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+func (w WindowSpec) String() string {
+	if w.Rounds > 0 {
+		return strconv.FormatInt(w.Rounds, 10)
+	}
+	return w.Span.String()
+}
+
+// WindowStats sums up the transfers of the rounds in a window.
+type WindowStats struct {
+	Window WindowSpec
+	// Rounds is the number of rounds in the window, with or without transfers.
+	Rounds int64
+	Count  int64
+	Sum    Int128
+	// Min and Max are only meaningful when Count > 0.
+	Min int64
+	Max int64
+}
+
+func (s WindowStats) Average() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum.Float64() / float64(s.Count)
+}
+
+// Window keeps count, sum, min and max over a sliding window of rounds. Rounds enter in round order
+// and leave oldest first, so the count and sum are kept by adding and subtracting, and min and max by
+// monotonic deques of the rounds' own min and max: every round enters and leaves each deque once.
+type Window struct {
+	spec   WindowSpec
+	rounds deque[RoundStats]
+	// mins holds rounds with increasing minimums, maxs rounds with decreasing maximums. Their fronts
+	// are the window's min and max.
+	mins  deque[RoundStats]
+	maxs  deque[RoundStats]
+	count int64
+	sum   Int128
+}
+
+func NewWindow(spec WindowSpec) *Window {
+	return &Window{spec: spec}
+}
+
+func (w *Window) Spec() WindowSpec {
+	return w.spec
+}
+
+// Add moves the window forward to the round. Rounds at or before the window's last one are ignored,
+// and so are rounds without an ingest time in a time window.
+func (w *Window) Add(st RoundStats) error {
+	if last, ok := w.rounds.back(); ok && st.Round <= last.Round {
+		return nil
+	}
+	if w.spec.Span > 0 && st.IngestedAt.IsZero() {
+		return nil
+	}
+	sum, err := w.sum.Add(st.Sum)
+	if err != nil {
+		return err
+	}
+	w.sum = sum
+	w.count += st.Count
+	w.rounds.pushBack(st)
+	if st.Count > 0 {
+		for b, ok := w.mins.back(); ok && b.Min >= st.Min; b, ok = w.mins.back() {
+			w.mins.popBack()
+		}
+		w.mins.pushBack(st)
+		for b, ok := w.maxs.back(); ok && b.Max <= st.Max; b, ok = w.maxs.back() {
+			w.maxs.popBack()
+		}
+		w.maxs.pushBack(st)
+	}
+	if w.spec.Rounds > 0 {
+		for int64(w.rounds.len()) > w.spec.Rounds {
+			w.evict()
+		}
+	}
+	return nil
+}
+
+// Expire drops the rounds of a time window ingested before now minus its span.
+func (w *Window) Expire(now time.Time) {
+	if w.spec.Span <= 0 {
+		return
+	}
+	cutoff := now.Add(-w.spec.Span)
+	for f, ok := w.rounds.front(); ok && !f.IngestedAt.After(cutoff); f, ok = w.rounds.front() {
+		w.evict()
+	}
+}
+
+func (w *Window) evict() {
+	st, _ := w.rounds.popFront()
+	w.count -= st.Count
+	// The sum held st's, it cannot overflow taking it back out.
+	w.sum, _ = w.sum.Sub(st.Sum)
+	if f, ok := w.mins.front(); ok && f.Round == st.Round {
+		w.mins.popFront()
+	}
+	if f, ok := w.maxs.front(); ok && f.Round == st.Round {
+		w.maxs.popFront()
+	}
+}
+
+func (w *Window) Stats() WindowStats {
+	s := WindowStats{Window: w.spec, Rounds: int64(w.rounds.len()), Count: w.count, Sum: w.sum}
+	if f, ok := w.mins.front(); ok {
+		s.Min = f.Min
+	}
+	if f, ok := w.maxs.front(); ok {
+		s.Max = f.Max
+	}
+	return s
+}
+
+// deque is a double-ended queue over a slice, whose popped front is reclaimed once it makes up half of it.
+type deque[T any] struct {
+	items []T
+	head  int
+}
+
+func (d *deque[T]) len() int { return len(d.items) - d.head }
+
+func (d *deque[T]) pushBack(v T) { d.items = append(d.items, v) }
+
+func (d *deque[T]) front() (T, bool) {
+	if d.len() == 0 {
+		var zero T
+		return zero, false
+	}
+	return d.items[d.head], true
+}
+
+func (d *deque[T]) back() (T, bool) {
+	if d.len() == 0 {
+		var zero T
+		return zero, false
+	}
+	return d.items[len(d.items)-1], true
+}
+
+func (d *deque[T]) popFront() (T, bool) {
+	v, ok := d.front()
+	if !ok {
+		return v, false
+	}
+	var zero T
+	d.items[d.head] = zero
+	d.head++
+	if d.head > len(d.items)/2 {
+		d.items = append(d.items[:0], d.items[d.head:]...)
+		d.head = 0
+	}
+	return v, true
+}
+
+func (d *deque[T]) popBack() (T, bool) {
+	v, ok := d.back()
+	if !ok {
+		return v, false
+	}
+	d.items = d.items[:len(d.items)-1]
+	if d.len() == 0 {
+		d.items, d.head = d.items[:0], 0
+	}
+	return v, true
+}
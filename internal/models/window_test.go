@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Window", func() {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// round returns the stats of a round with transfers between lo and hi, ingested round seconds after start.
+	round := func(r, lo, hi int64) RoundStats {
+		return RoundStats{Round: r, Count: 2, Sum: NewInt128(lo + hi), Min: lo, Max: hi, IngestedAt: start.Add(time.Duration(r) * time.Second)}
+	}
+	// empty returns the stats of a round without transfers.
+	empty := func(r int64) RoundStats {
+		return RoundStats{Round: r, IngestedAt: start.Add(time.Duration(r) * time.Second)}
+	}
+	minMax := func(w *Window) [2]int64 {
+		s := w.Stats()
+		return [2]int64{s.Min, s.Max}
+	}
+
+	It("should move min and max to the remaining rounds as rounds leave a round window", func() {
+		w := NewWindow(WindowSpec{Rounds: 3})
+		for _, st := range []RoundStats{round(1, 1, 100), round(2, 5, 50), round(3, 3, 70)} {
+			Expect(w.Add(st)).To(Succeed())
+		}
+		Expect(minMax(w)).To(Equal([2]int64{1, 100}))
+
+		// Round 1 held both the min and the max.
+		Expect(w.Add(round(4, 10, 20))).To(Succeed())
+		Expect(minMax(w)).To(Equal([2]int64{3, 70}))
+		// Round 2 held neither.
+		Expect(w.Add(empty(5))).To(Succeed())
+		Expect(minMax(w)).To(Equal([2]int64{3, 70}))
+		Expect(w.Add(empty(6))).To(Succeed())
+		Expect(minMax(w)).To(Equal([2]int64{10, 20}))
+
+		s := w.Stats()
+		Expect(s.Rounds).To(Equal(int64(3)))
+		Expect(s.Count).To(Equal(int64(2)))
+		Expect(s.Sum).To(Equal(NewInt128(30)))
+	})
+	It("should move min and max to the remaining rounds as rounds expire from a time window", func() {
+		w := NewWindow(WindowSpec{Span: 10 * time.Second})
+		for _, st := range []RoundStats{round(1, -7, 9), round(2, 4, 90), round(3, 2, 8)} {
+			Expect(w.Add(st)).To(Succeed())
+		}
+		Expect(minMax(w)).To(Equal([2]int64{-7, 90}))
+
+		w.Expire(start.Add(11 * time.Second))
+		Expect(minMax(w)).To(Equal([2]int64{2, 90}))
+		w.Expire(start.Add(12 * time.Second))
+		Expect(minMax(w)).To(Equal([2]int64{2, 8}))
+		w.Expire(start.Add(13 * time.Second))
+		Expect(w.Stats()).To(Equal(WindowStats{Window: WindowSpec{Span: 10 * time.Second}, Sum: NewInt128(0)}))
+	})
+})
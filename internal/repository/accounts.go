@@ -0,0 +1,216 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+// ErrAccountNotFound is returned by LoadAccount when the account never took part in a transfer.
+var ErrAccountNotFound = errors.New("account not found")
+
+const accountColumns = `id, sent_count, sent_volume, sent_min, sent_max,
+	received_count, received_volume, received_min, received_max, first_round, last_round`
+
+// rankingOrder returns the ranking's ORDER BY expression. The volume ones match the expression indexes.
+func rankingOrder(d dialect, by models.AccountRanking) (string, bool) {
+	switch by {
+	case models.RankSentVolume:
+		return `CAST(sent_volume AS ` + d.numeric + `) DESC`, true
+	case models.RankReceivedVolume:
+		return `CAST(received_volume AS ` + d.numeric + `) DESC`, true
+	case models.RankSentCount:
+		return `sent_count DESC`, true
+	case models.RankReceivedCount:
+		return `received_count DESC`, true
+	}
+	return "", false
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAccount(row rowScanner) (models.Account, error) {
+	var (
+		a                        models.Account
+		sentMin, sentMax         sql.NullInt64
+		receivedMin, receivedMax sql.NullInt64
+	)
+	err := row.Scan(&a.ID, &a.Sent.Count, &a.Sent.Volume, &sentMin, &sentMax,
+		&a.Received.Count, &a.Received.Volume, &receivedMin, &receivedMax, &a.FirstRound, &a.LastRound)
+	a.Sent.Min, a.Sent.Max = sentMin.Int64, sentMax.Int64
+	a.Received.Min, a.Received.Max = receivedMin.Int64, receivedMax.Int64
+	return a, err
+}
+
+// flowBounds stores a flow's min and max as NULL while it has no transfers.
+func flowBounds(f models.Flow) (sql.NullInt64, sql.NullInt64) {
+	if f.Count == 0 {
+		return sql.NullInt64{}, sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: f.Min, Valid: true}, sql.NullInt64{Int64: f.Max, Valid: true}
+}
+
+func (s *sqlMetrics) LoadAccount(ctx context.Context, id int64) (models.Account, error) {
+	a, err := scanAccount(s.db.QueryRowContext(ctx, `SELECT `+accountColumns+` FROM accounts WHERE id=?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Account{}, ErrAccountNotFound
+	}
+	return a, err
+}
+
+func (s *sqlMetrics) TopAccounts(ctx context.Context, by models.AccountRanking, limit int) ([]models.Account, error) {
+	order, ok := rankingOrder(s.db.dialect, by)
+	if !ok {
+		return nil, fmt.Errorf("unknown ranking %q", by)
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT `+accountColumns+` FROM accounts ORDER BY `+order+`, id LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := make([]models.Account, 0, limit)
+	for rows.Next() {
+		a, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+func loadAccountTx(ctx context.Context, tx *sqlTx, id int64) (models.Account, error) {
+	a, err := scanAccount(tx.QueryRowContext(ctx, `SELECT `+accountColumns+` FROM accounts WHERE id=?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Account{ID: id}, nil
+	}
+	return a, err
+}
+
+func saveAccount(ctx context.Context, tx *sqlTx, a models.Account) error {
+	sentMin, sentMax := flowBounds(a.Sent)
+	receivedMin, receivedMax := flowBounds(a.Received)
+	_, err := tx.ExecContext(ctx, upsert("accounts", accountColumns, "id"),
+		a.ID, a.Sent.Count, a.Sent.Volume, sentMin, sentMax,
+		a.Received.Count, a.Received.Volume, receivedMin, receivedMax, a.FirstRound, a.LastRound)
+	return err
+}
+
+// sortedIDs lists the accounts in ID order, so they are always written in the same order.
+func sortedIDs(accounts models.Accounts) []int64 {
+	ids := make([]int64, 0, len(accounts))
+	for id := range accounts {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// applyAccounts adds the events to the aggregates of their senders and recipients.
+func applyAccounts(ctx context.Context, tx *sqlTx, events []models.Event) error {
+	deltas, err := models.AccountsOf(events)
+	if err != nil {
+		return err
+	}
+	for _, id := range sortedIDs(deltas) {
+		a, err := loadAccountTx(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := a.Merge(*deltas[id]); err != nil {
+			return err
+		}
+		if err := saveAccount(ctx, tx, a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accountsAfter aggregates the stored events after the round.
+func accountsAfter(ctx context.Context, tx *sqlTx, round int64) (models.Accounts, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT round, sender, recipient, amount FROM events WHERE round > ?`, round)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := make(models.Accounts)
+	for rows.Next() {
+		var e models.Event
+		if err := rows.Scan(&e.Round, &e.Sender, &e.Recipient, &e.Amount); err != nil {
+			return nil, err
+		}
+		if err := accounts.Add(e); err != nil {
+			return nil, err
+		}
+	}
+	return accounts, rows.Err()
+}
+
+// rollbackAccounts subtracts the removed transfers once their events are deleted. Counts and volumes
+// are subtracted exactly, min, max and the round range are recomputed from the events that remain.
+// Accounts left without transfers are deleted.
+func rollbackAccounts(ctx context.Context, tx *sqlTx, removed models.Accounts) error {
+	for _, id := range sortedIDs(removed) {
+		a, err := loadAccountTx(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		r := removed[id]
+		a.Sent.Count -= r.Sent.Count
+		a.Received.Count -= r.Received.Count
+		if a.Transfers() <= 0 {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM accounts WHERE id=?`, id); err != nil {
+				return err
+			}
+			continue
+		}
+		if a.Sent.Volume, err = a.Sent.Volume.Sub(r.Sent.Volume); err != nil {
+			return err
+		}
+		if a.Received.Volume, err = a.Received.Volume.Sub(r.Received.Volume); err != nil {
+			return err
+		}
+
+		var sentMin, sentMax, receivedMin, receivedMax sql.NullInt64
+		if err := tx.QueryRowContext(ctx, `SELECT MIN(amount), MAX(amount) FROM events WHERE sender=?`, id).Scan(&sentMin, &sentMax); err != nil {
+			return err
+		}
+		if err := tx.QueryRowContext(ctx, `SELECT MIN(amount), MAX(amount) FROM events WHERE recipient=?`, id).Scan(&receivedMin, &receivedMax); err != nil {
+			return err
+		}
+		if err := tx.QueryRowContext(ctx, `SELECT MIN(round), MAX(round) FROM events WHERE sender=? OR recipient=?`, id, id).
+			Scan(&a.FirstRound, &a.LastRound); err != nil {
+			return err
+		}
+		a.Sent.Min, a.Sent.Max = sentMin.Int64, sentMax.Int64
+		a.Received.Min, a.Received.Max = receivedMin.Int64, receivedMax.Int64
+		if err := saveAccount(ctx, tx, a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildAccounts recomputes every account from the stored events.
+func rebuildAccounts(ctx context.Context, tx *sqlTx) error {
+	accounts, err := accountsAfter(ctx, tx, math.MinInt64)
+	if err != nil {
+		return err
+	}
+	for _, id := range sortedIDs(accounts) {
+		if err := saveAccount(ctx, tx, *accounts[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
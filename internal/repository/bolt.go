@@ -0,0 +1,705 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/rhuandantas/metrika/internal/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of the bolt repository. Keys holding rounds, ids and times are big-endian, so that cursors
+// walk rounds, and events within a round by sig, in order.
+var (
+	// metaBucket holds the live metrics under metricsKey and their distribution under distributionKey.
+	metaBucket = []byte("meta")
+	// eventsBucket maps round and sig to the event, sigsBucket maps the sig back to the event's key.
+	eventsBucket = []byte("events")
+	sigsBucket   = []byte("sigs")
+	// roundsBucket maps a round to its stats, snapshotsBucket to the metrics snapshotted at the round.
+	roundsBucket    = []byte("rounds")
+	snapshotsBucket = []byte("snapshots")
+	// rollupsBucket maps a period and its start to the rollup.
+	rollupsBucket  = []byte("rollups")
+	accountsBucket = []byte("accounts")
+	scopesBucket   = []byte("scopes")
+
+	metricsKey      = []byte("metrics")
+	distributionKey = []byte("distribution")
+)
+
+// BoltMetrics stores the metrics in an embedded bbolt key-value file, for deployments without a
+// database. Values are JSON, and every method runs in a single bolt transaction.
+type BoltMetrics struct {
+	db *bolt.DB
+}
+
+// boltScope is how a scope is stored, its distribution included.
+type boltScope struct {
+	models.Scope
+	Distribution []byte `json:"distribution"`
+}
+
+// NewBoltMetrics opens the bolt file at path, creating it if needed. Only one process can hold it open.
+func NewBoltMetrics(path string) (Repository, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	return &BoltMetrics{db: db}, nil
+}
+
+// Init creates the buckets and the initial metrics, leaving existing ones alone.
+func (s *BoltMetrics) Init(context.Context) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{metaBucket, eventsBucket, sigsBucket, roundsBucket, snapshotsBucket, rollupsBucket, accountsBucket, scopesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(metaBucket).Get(metricsKey) != nil {
+			return nil
+		}
+		return put(tx.Bucket(metaBucket), metricsKey, models.NewMetrics())
+	})
+}
+
+// intKey encodes v with its sign bit flipped, so that keys sort like the numbers do.
+func intKey(v int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(v)^1<<63)
+}
+
+func intOf(key []byte) int64 {
+	return int64(binary.BigEndian.Uint64(key) ^ 1<<63)
+}
+
+func eventKey(round int64, sig string) []byte {
+	return append(intKey(round), sig...)
+}
+
+func boltRollupKey(period models.Period, start int64) []byte {
+	return append(append([]byte(period), 0), intKey(start)...)
+}
+
+func put(b *bolt.Bucket, key []byte, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, raw)
+}
+
+// get decodes the value stored under the key, reporting whether there is one.
+func get[T any](b *bolt.Bucket, key []byte) (T, bool, error) {
+	var v T
+	raw := b.Get(key)
+	if raw == nil {
+		return v, false, nil
+	}
+	return v, true, json.Unmarshal(raw, &v)
+}
+
+func loadMetrics(tx *bolt.Tx) (models.Metrics, error) {
+	m, _, err := get[models.Metrics](tx.Bucket(metaBucket), metricsKey)
+	return m, err
+}
+
+func loadAccount(tx *bolt.Tx, id int64) (models.Account, error) {
+	a, ok, err := get[models.Account](tx.Bucket(accountsBucket), intKey(id))
+	if !ok && err == nil {
+		a = models.Account{ID: id}
+	}
+	return a, err
+}
+
+func (s *BoltMetrics) LoadMetrics(context.Context) (m models.Metrics, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		m, err = loadMetrics(tx)
+		return err
+	})
+	return m, err
+}
+
+func (s *BoltMetrics) LoadDistribution(context.Context) (d *models.Distribution, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		d, err = decodeDistribution(tx.Bucket(metaBucket).Get(distributionKey))
+		return err
+	})
+	return d, err
+}
+
+func (s *BoltMetrics) SaveMetrics(_ context.Context, m models.Metrics) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(metaBucket), metricsKey, m)
+	})
+}
+
+func (s *BoltMetrics) RepairMetrics(_ context.Context, m models.Metrics) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		stored, err := loadMetrics(tx)
+		if err != nil {
+			return err
+		}
+		if stored.LastRound != m.LastRound {
+			return ErrCheckpointMoved
+		}
+		return put(tx.Bucket(metaBucket), metricsKey, m)
+	})
+}
+
+func (s *BoltMetrics) CommitRound(_ context.Context, c models.RoundCommit) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		m, err := loadMetrics(tx)
+		if err != nil {
+			return err
+		}
+		if m.LastRound >= c.Round {
+			return ErrRoundCommitted
+		}
+
+		if dup, err := insertBoltEvents(tx, c.Events); err != nil {
+			return err
+		} else if dup != "" {
+			return &DuplicateEventError{Sig: dup}
+		}
+		if err := addToBoltDistribution(tx.Bucket(metaBucket), c.Events); err != nil {
+			return err
+		}
+		deltas, err := models.AccountsOf(c.Events)
+		if err != nil {
+			return err
+		}
+		for id, d := range deltas {
+			a, err := loadAccount(tx, id)
+			if err != nil {
+				return err
+			}
+			if err := a.Merge(*d); err != nil {
+				return err
+			}
+			if err := put(tx.Bucket(accountsBucket), intKey(id), a); err != nil {
+				return err
+			}
+		}
+
+		st := c.Stats()
+		if !st.IngestedAt.IsZero() {
+			// Ingest times are kept to the millisecond, like the SQL repositories do.
+			st.IngestedAt = time.UnixMilli(st.IngestedAt.UnixMilli()).UTC()
+			rollups := tx.Bucket(rollupsBucket)
+			for _, p := range models.Periods {
+				start := time.Unix(p.Start(st.IngestedAt).Unix(), 0).UTC()
+				key := boltRollupKey(p, start.Unix())
+				r, ok, err := get[models.Rollup](rollups, key)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					r = models.Rollup{Period: p, Start: start}
+				}
+				if err := r.Add(st); err != nil {
+					return err
+				}
+				if err := put(rollups, key, r); err != nil {
+					return err
+				}
+			}
+		}
+		if err := put(tx.Bucket(roundsBucket), intKey(c.Round), st); err != nil {
+			return err
+		}
+		if c.Snapshot {
+			if err := put(tx.Bucket(snapshotsBucket), intKey(c.Metrics.LastRound), c.Metrics); err != nil {
+				return err
+			}
+		}
+
+		m = c.Metrics
+		m.LastRound = c.Round
+		return put(tx.Bucket(metaBucket), metricsKey, m)
+	})
+}
+
+// insertBoltEvents stores the events, ignoring sigs that are already stored. It returns the first sig it ignored, if any.
+func insertBoltEvents(tx *bolt.Tx, events []models.Event) (string, error) {
+	stored, sigs := tx.Bucket(eventsBucket), tx.Bucket(sigsBucket)
+	var dup string
+	for _, e := range events {
+		if sigs.Get([]byte(e.Sig)) != nil {
+			if dup == "" {
+				dup = e.Sig
+			}
+			continue
+		}
+		key := eventKey(e.Round, e.Sig)
+		if err := put(stored, key, e); err != nil {
+			return "", err
+		}
+		if err := sigs.Put([]byte(e.Sig), key); err != nil {
+			return "", err
+		}
+	}
+	return dup, nil
+}
+
+// addToBoltDistribution adds the events' amounts to the live distribution.
+func addToBoltDistribution(meta *bolt.Bucket, events []models.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	d, err := decodeDistribution(meta.Get(distributionKey))
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		d.Add(e.Amount)
+	}
+	raw, err := d.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return meta.Put(distributionKey, raw)
+}
+
+func (s *BoltMetrics) RecentRounds(_ context.Context, n int) (stats []models.RoundStats, err error) {
+	stats = make([]models.RoundStats, 0)
+	err = s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(roundsBucket).Cursor()
+		for k, v := c.Last(); k != nil && (n < 0 || len(stats) < n); k, v = c.Prev() {
+			var st models.RoundStats
+			if err := json.Unmarshal(v, &st); err != nil {
+				return err
+			}
+			stats = append(stats, st)
+		}
+		return nil
+	})
+	slices.Reverse(stats)
+	return stats, err
+}
+
+func (s *BoltMetrics) MetricsAt(_ context.Context, round int64) (m models.Metrics, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		live, err := loadMetrics(tx)
+		if err != nil {
+			return err
+		}
+		switch {
+		case round < 0 || round > live.LastRound:
+			return fmt.Errorf("%w: round %d is not between 0 and the last committed round %d", ErrRoundNotAvailable, round, live.LastRound)
+		case round == live.LastRound:
+			m = live
+			return nil
+		}
+
+		// The last snapshot at or before the round is the one before the first key past it.
+		m = models.NewMetrics()
+		c := tx.Bucket(snapshotsBucket).Cursor()
+		k, v := c.Seek(intKey(round + 1))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		if k != nil {
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+		}
+
+		from := m.LastRound + 1
+		stats, err := queryBoltRounds(tx, models.RoundFilter{FromRound: &from, ToRound: &round})
+		if err != nil {
+			return err
+		}
+		// Every round is committed, so a gap means the round predates the stats.
+		if int64(len(stats)) != round-m.LastRound {
+			return fmt.Errorf("%w: the rounds before round %d were committed without stats", ErrRoundNotAvailable, round)
+		}
+		for _, st := range stats {
+			if err := m.AddRound(st); err != nil {
+				return err
+			}
+		}
+		m.LastRound = round
+		return nil
+	})
+	if err != nil {
+		return models.Metrics{}, err
+	}
+	return m, nil
+}
+
+func (s *BoltMetrics) QueryRounds(_ context.Context, f models.RoundFilter) (stats []models.RoundStats, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		stats, err = queryBoltRounds(tx, f)
+		return err
+	})
+	return stats, err
+}
+
+// queryBoltRounds scans the rounds of the filter's round range for the ones matching it.
+func queryBoltRounds(tx *bolt.Tx, f models.RoundFilter) ([]models.RoundStats, error) {
+	stats := make([]models.RoundStats, 0)
+	c := tx.Bucket(roundsBucket).Cursor()
+	k, v := c.First()
+	if f.FromRound != nil {
+		k, v = c.Seek(intKey(*f.FromRound))
+	}
+	for ; k != nil && (f.Limit <= 0 || len(stats) < f.Limit); k, v = c.Next() {
+		if f.ToRound != nil && intOf(k) > *f.ToRound {
+			break
+		}
+		var st models.RoundStats
+		if err := json.Unmarshal(v, &st); err != nil {
+			return nil, err
+		}
+		if matchRound(st, f) {
+			stats = append(stats, st)
+		}
+	}
+	return stats, nil
+}
+
+func (s *BoltMetrics) QueryRollups(_ context.Context, period models.Period, from, to time.Time, limit int) ([]models.Rollup, error) {
+	if _, err := models.ParsePeriod(string(period)); err != nil {
+		return nil, err
+	}
+	rollups := make([]models.Rollup, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := append([]byte(period), 0)
+		c := tx.Bucket(rollupsBucket).Cursor()
+		for k, v := c.Seek(boltRollupKey(period, period.Start(from).Unix())); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if intOf(k[len(prefix):]) >= to.Unix() || (limit > 0 && len(rollups) == limit) {
+				break
+			}
+			var r models.Rollup
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			rollups = append(rollups, r)
+		}
+		return nil
+	})
+	return rollups, err
+}
+
+func (s *BoltMetrics) RollbackTo(_ context.Context, round int64) (m models.Metrics, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if m, err = loadMetrics(tx); err != nil {
+			return err
+		}
+
+		// As in the other repositories, count and sum are subtracted exactly, min and max recomputed from
+		// the rounds that remain when a removed round held them, and the spread of the removed events
+		// taken out of the mean and M2.
+		removed := models.NewMetrics()
+		removedAccounts := make(models.Accounts)
+		events, sigs := tx.Bucket(eventsBucket), tx.Bucket(sigsBucket)
+		removedEvents, err := deleteFrom(events, intKey(round+1))
+		if err != nil {
+			return err
+		}
+		removedAmounts := make([]int64, 0, len(removedEvents))
+		for _, raw := range removedEvents {
+			var e models.Event
+			if err := json.Unmarshal(raw, &e); err != nil {
+				return err
+			}
+			if err := sigs.Delete([]byte(e.Sig)); err != nil {
+				return err
+			}
+			if err := removed.Update(e.Amount, 0); err != nil {
+				return err
+			}
+			if err := removedAccounts.Add(e); err != nil {
+				return err
+			}
+			removedAmounts = append(removedAmounts, e.Amount)
+		}
+		m.RemoveSpread(removed.Count, removed.Mean, removed.M2)
+		d, err := decodeDistribution(tx.Bucket(metaBucket).Get(distributionKey))
+		if err != nil {
+			return err
+		}
+		if err := d.Remove(removedAmounts...); err != nil {
+			return err
+		}
+		raw, err := d.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(metaBucket).Put(distributionKey, raw); err != nil {
+			return err
+		}
+
+		removedRounds, err := deleteFrom(tx.Bucket(roundsBucket), intKey(round+1))
+		if err != nil {
+			return err
+		}
+		var (
+			removedSum             models.Int128
+			removedMin, removedMax *int64
+			rollups                = make(map[rollupKey]models.Rollup)
+		)
+		for _, raw := range removedRounds {
+			var st models.RoundStats
+			if err := json.Unmarshal(raw, &st); err != nil {
+				return err
+			}
+			m.Count -= st.Count
+			if removedSum, err = removedSum.Add(st.Sum); err != nil {
+				return err
+			}
+			if st.Count > 0 {
+				removedMin, removedMax = minOf(removedMin, st.Min), maxOf(removedMax, st.Max)
+			}
+			if !st.IngestedAt.IsZero() {
+				for _, p := range models.Periods {
+					r := models.Rollup{Period: p, Start: time.Unix(p.Start(st.IngestedAt).Unix(), 0).UTC()}
+					rollups[keyOf(r)] = r
+				}
+			}
+		}
+		if m.Sum, err = m.Sum.Sub(removedSum); err != nil {
+			return err
+		}
+
+		// The rounds that remain give the min and max, and rebuild the rollups of the removed rounds.
+		var keptMin, keptMax *int64
+		err = tx.Bucket(roundsBucket).ForEach(func(_, raw []byte) error {
+			var st models.RoundStats
+			if err := json.Unmarshal(raw, &st); err != nil {
+				return err
+			}
+			if st.Count > 0 {
+				keptMin, keptMax = minOf(keptMin, st.Min), maxOf(keptMax, st.Max)
+			}
+			if st.IngestedAt.IsZero() {
+				return nil
+			}
+			for _, p := range models.Periods {
+				k := rollupKey{period: p, start: p.Start(st.IngestedAt).Unix()}
+				if r, ok := rollups[k]; ok {
+					if err := r.Add(st); err != nil {
+						return err
+					}
+					rollups[k] = r
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		switch {
+		case m.Count == 0:
+			m.Min, m.Max = models.NewMetrics().Min, 0
+		default:
+			if removedMin != nil && *removedMin <= m.Min && keptMin != nil {
+				m.Min = *keptMin
+			}
+			if removedMax != nil && *removedMax >= m.Max && keptMax != nil {
+				m.Max = *keptMax
+			}
+		}
+		m.LastRound = round
+		for k, r := range rollups {
+			key := boltRollupKey(k.period, k.start)
+			if r.Rounds == 0 {
+				err = tx.Bucket(rollupsBucket).Delete(key)
+			} else {
+				err = put(tx.Bucket(rollupsBucket), key, r)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		// The events that remain rebuild the accounts of the removed transfers.
+		kept := make(models.Accounts)
+		err = events.ForEach(func(_, raw []byte) error {
+			var e models.Event
+			if err := json.Unmarshal(raw, &e); err != nil {
+				return err
+			}
+			if removedAccounts[e.Sender] != nil || removedAccounts[e.Recipient] != nil {
+				return kept.Add(e)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		accounts, err := subtractAccounts(removedAccounts, kept, func(id int64) (models.Account, error) { return loadAccount(tx, id) })
+		if err != nil {
+			return err
+		}
+		for id, a := range accounts {
+			if a.Transfers() <= 0 {
+				err = tx.Bucket(accountsBucket).Delete(intKey(id))
+			} else {
+				err = put(tx.Bucket(accountsBucket), intKey(id), a)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if _, err := deleteFrom(tx.Bucket(snapshotsBucket), intKey(round+1)); err != nil {
+			return err
+		}
+		return put(tx.Bucket(metaBucket), metricsKey, m)
+	})
+	if err != nil {
+		return models.Metrics{}, err
+	}
+	return m, nil
+}
+
+// deleteFrom deletes the keys from the given one on and returns their values.
+func deleteFrom(b *bolt.Bucket, from []byte) ([][]byte, error) {
+	var keys, values [][]byte
+	c := b.Cursor()
+	for k, v := c.Seek(from); k != nil; k, v = c.Next() {
+		// Keys and values are only valid during the transaction, and deleting them would reuse their pages.
+		keys, values = append(keys, slices.Clone(k)), append(values, slices.Clone(v))
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (s *BoltMetrics) SaveEvents(_ context.Context, events []models.Event) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		_, err := insertBoltEvents(tx, events)
+		return err
+	})
+}
+
+func (s *BoltMetrics) QueryEvents(_ context.Context, f models.EventFilter) ([]models.Event, error) {
+	events := make([]models.Event, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var from []byte
+		if f.FromRound != nil {
+			from = intKey(*f.FromRound)
+		}
+		if f.After != nil {
+			if after := eventKey(f.After.Round, f.After.Sig); bytes.Compare(after, from) > 0 {
+				from = after
+			}
+		}
+		c := tx.Bucket(eventsBucket).Cursor()
+		k, v := c.First()
+		if from != nil {
+			k, v = c.Seek(from)
+		}
+		for ; k != nil && (f.Limit <= 0 || len(events) < f.Limit); k, v = c.Next() {
+			if f.ToRound != nil && intOf(k) > *f.ToRound {
+				break
+			}
+			var e models.Event
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if f.Match(e) {
+				events = append(events, e)
+			}
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (s *BoltMetrics) LoadAccount(_ context.Context, id int64) (a models.Account, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		var ok bool
+		a, ok, err = get[models.Account](tx.Bucket(accountsBucket), intKey(id))
+		if err == nil && !ok {
+			return ErrAccountNotFound
+		}
+		return err
+	})
+	return a, err
+}
+
+func (s *BoltMetrics) TopAccounts(_ context.Context, by models.AccountRanking, limit int) ([]models.Account, error) {
+	var accounts []models.Account
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(accountsBucket).ForEach(func(_, raw []byte) error {
+			var a models.Account
+			if err := json.Unmarshal(raw, &a); err != nil {
+				return err
+			}
+			accounts = append(accounts, a)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rankAccounts(accounts, by, limit)
+}
+
+func (s *BoltMetrics) LoadScope(_ context.Context, name string) (sc models.Scope, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		stored, ok, err := get[boltScope](tx.Bucket(scopesBucket), []byte(name))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrScopeNotFound
+		}
+		sc = stored.Scope
+		sc.Distribution, err = decodeDistribution(stored.Distribution)
+		return err
+	})
+	return sc, err
+}
+
+func (s *BoltMetrics) ResetScope(_ context.Context, name string, from, to int64) (models.Scope, error) {
+	sc := models.NewScope(name, from, to)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(scopesBucket), []byte(name), boltScope{Scope: sc})
+	})
+	return sc, err
+}
+
+func (s *BoltMetrics) CommitScopeRound(_ context.Context, name string, c models.RoundCommit) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		scopes := tx.Bucket(scopesBucket)
+		sc, ok, err := get[boltScope](scopes, []byte(name))
+		if err != nil {
+			return err
+		}
+		if !ok || sc.Metrics.LastRound >= c.Round || c.Round < sc.FromRound || c.Round > sc.ToRound {
+			return ErrRoundCommitted
+		}
+		sc.Metrics = c.Metrics
+		sc.Metrics.LastRound = c.Round
+		d, err := decodeDistribution(sc.Distribution)
+		if err != nil {
+			return err
+		}
+		for _, e := range c.Events {
+			d.Add(e.Amount)
+		}
+		if sc.Distribution, err = d.MarshalBinary(); err != nil {
+			return err
+		}
+		return put(scopes, []byte(name), sc)
+	})
+}
+
+// Close releases the bolt file, so that another process can open it.
+func (s *BoltMetrics) Close() error {
+	return s.db.Close()
+}
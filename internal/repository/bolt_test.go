@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+var _ = Describe("BoltMetrics", func() {
+	open := func(ctx context.Context) Repository {
+		repo, err := Open("bolt://" + filepath.Join(GinkgoT().TempDir(), "metrika.bolt"))
+		Expect(err).To(BeNil())
+		DeferCleanup(repo.(*BoltMetrics).Close)
+		Expect(repo.Init(ctx)).To(Succeed())
+		return repo
+	}
+
+	Context("as a repository", func() {
+		repositoryContract(open)
+	})
+
+	It("should keep its content across reopens", func() {
+		ctx := context.Background()
+		path := filepath.Join(GinkgoT().TempDir(), "metrika.bolt")
+		repo, err := NewBoltMetrics(path)
+		Expect(err).To(BeNil())
+		Expect(repo.Init(ctx)).To(Succeed())
+		c := models.RoundCommit{
+			Round:   1,
+			Events:  []models.Event{{Round: 1, Sig: "a", Sender: 1, Recipient: 2, Amount: 10}},
+			Metrics: models.Metrics{Count: 1, Sum: models.NewInt128(10), Min: 10, Max: 10, Mean: 10, LastRound: 1},
+		}
+		Expect(repo.CommitRound(ctx, c)).To(Succeed())
+		Expect(repo.(*BoltMetrics).Close()).To(Succeed())
+
+		repo, err = NewBoltMetrics(path)
+		Expect(err).To(BeNil())
+		DeferCleanup(repo.(*BoltMetrics).Close)
+		Expect(repo.Init(ctx)).To(Succeed())
+		m, err := repo.LoadMetrics(ctx)
+		Expect(err).To(BeNil())
+		Expect(m).To(Equal(c.Metrics))
+		events, err := repo.QueryEvents(ctx, models.EventFilter{})
+		Expect(err).To(BeNil())
+		Expect(events).To(Equal(c.Events))
+	})
+	It("should encode keys that sort like the numbers", func() {
+		values := []int64{math.MinInt64, -1, 0, 1, 255, 256, math.MaxInt64}
+		for i := 1; i < len(values); i++ {
+			Expect(bytes.Compare(intKey(values[i-1]), intKey(values[i]))).To(Equal(-1))
+			Expect(intOf(intKey(values[i]))).To(Equal(values[i]))
+		}
+	})
+})
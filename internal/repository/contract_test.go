@@ -0,0 +1,483 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+// repositoryContract specifies what every Repository does, whatever it stores the metrics in.
+// open returns an initialized repository holding nothing.
+func repositoryContract(open func(ctx context.Context) Repository) {
+	var (
+		ctx  context.Context
+		repo Repository
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		repo = open(ctx)
+	})
+
+	It("should start from empty metrics", func() {
+		m, err := repo.LoadMetrics(ctx)
+		Expect(err).To(BeNil())
+		Expect(m).To(Equal(models.Metrics{Min: math.MaxInt64}))
+	})
+	It("should save and load metrics", func() {
+		saved := models.Metrics{Count: 2, Sum: models.NewInt128(30), Min: 10, Max: 20, Mean: 15, M2: 50, LastRound: 5}
+		Expect(repo.SaveMetrics(ctx, saved)).To(Succeed())
+		m, err := repo.LoadMetrics(ctx)
+		Expect(err).To(BeNil())
+		Expect(m).To(Equal(saved))
+	})
+	It("should repair metrics only while the checkpoint has not moved", func() {
+		Expect(repo.SaveMetrics(ctx, models.Metrics{Count: 9, Sum: models.NewInt128(9), Min: 1, Max: 1, LastRound: 5})).To(Succeed())
+
+		repaired := models.Metrics{Count: 2, Sum: models.NewInt128(30), Min: 10, Max: 20, Mean: 15, M2: 50, LastRound: 5}
+		Expect(repo.RepairMetrics(ctx, repaired)).To(Succeed())
+		m, err := repo.LoadMetrics(ctx)
+		Expect(err).To(BeNil())
+		Expect(m).To(Equal(repaired))
+
+		Expect(repo.RepairMetrics(ctx, models.Metrics{LastRound: 4})).To(MatchError(ErrCheckpointMoved))
+	})
+
+	It("should keep sums beyond 64 bits", func() {
+		sum, err := models.ParseInt128("170141183460469231731687303715884105727")
+		Expect(err).To(BeNil())
+		saved := models.Metrics{Count: 2, Sum: sum, Min: 10, Max: 20, LastRound: 5}
+		Expect(repo.SaveMetrics(ctx, saved)).To(Succeed())
+		m, err := repo.LoadMetrics(ctx)
+		Expect(err).To(BeNil())
+		Expect(m.Sum.String()).To(Equal("170141183460469231731687303715884105727"))
+	})
+
+	It("should commit a round exactly once", func() {
+		commit := models.RoundCommit{
+			Round:   3,
+			Events:  []models.Event{{Round: 3, Sig: "x", Sender: 1, Recipient: 2, Amount: 7}},
+			Metrics: models.Metrics{Count: 1, Sum: models.NewInt128(7), Min: 7, Max: 7, LastRound: 3},
+		}
+		Expect(repo.CommitRound(ctx, commit)).To(Succeed())
+
+		retry := commit
+		retry.Metrics = models.Metrics{Count: 2, Sum: models.NewInt128(14), Min: 7, Max: 7, LastRound: 3}
+		Expect(repo.CommitRound(ctx, retry)).To(MatchError(ErrRoundCommitted))
+
+		m, err := repo.LoadMetrics(ctx)
+		Expect(err).To(BeNil())
+		Expect(m).To(Equal(commit.Metrics))
+		events, err := repo.QueryEvents(ctx, models.EventFilter{})
+		Expect(err).To(BeNil())
+		Expect(events).To(Equal(commit.Events))
+		d, err := repo.LoadDistribution(ctx)
+		Expect(err).To(BeNil())
+		Expect(d.Count()).To(Equal(int64(1)))
+	})
+
+	It("should refuse to count an event that is already stored", func() {
+		first := models.RoundCommit{
+			Round:   3,
+			Events:  []models.Event{{Round: 3, Sig: "x", Sender: 1, Recipient: 2, Amount: 7}},
+			Metrics: models.Metrics{Count: 1, Sum: models.NewInt128(7), Min: 7, Max: 7, LastRound: 3},
+		}
+		Expect(repo.CommitRound(ctx, first)).To(Succeed())
+
+		replayed := models.RoundCommit{
+			Round:   4,
+			Events:  []models.Event{{Round: 4, Sig: "y", Sender: 1, Recipient: 2, Amount: 5}, {Round: 4, Sig: "x", Sender: 1, Recipient: 2, Amount: 7}},
+			Metrics: models.Metrics{Count: 3, Sum: models.NewInt128(19), Min: 5, Max: 7, LastRound: 4},
+		}
+		Expect(repo.CommitRound(ctx, replayed)).To(MatchError(ErrDuplicateEvent))
+		repeated := models.RoundCommit{
+			Round:   4,
+			Events:  []models.Event{{Round: 4, Sig: "y", Sender: 1, Recipient: 2, Amount: 5}, {Round: 4, Sig: "y", Sender: 1, Recipient: 2, Amount: 5}},
+			Metrics: models.Metrics{Count: 3, Sum: models.NewInt128(17), Min: 5, Max: 7, LastRound: 4},
+		}
+		Expect(repo.CommitRound(ctx, repeated)).To(MatchError(ErrDuplicateEvent))
+
+		m, err := repo.LoadMetrics(ctx)
+		Expect(err).To(BeNil())
+		Expect(m).To(Equal(first.Metrics))
+		events, err := repo.QueryEvents(ctx, models.EventFilter{})
+		Expect(err).To(BeNil())
+		Expect(events).To(Equal(first.Events))
+		a, err := repo.LoadAccount(ctx, 2)
+		Expect(err).To(BeNil())
+		Expect(a.Received.Count).To(Equal(int64(1)))
+		d, err := repo.LoadDistribution(ctx)
+		Expect(err).To(BeNil())
+		Expect(d.Count()).To(Equal(int64(1)))
+	})
+
+	Describe("rollback", func() {
+		commit := func(round int64, amounts ...int64) {
+			m, err := repo.LoadMetrics(ctx)
+			Expect(err).To(BeNil())
+			c := models.RoundCommit{Round: round, Hash: fmt.Sprintf("h%d", round)}
+			for i, a := range amounts {
+				c.Events = append(c.Events, models.Event{Round: round, Sig: fmt.Sprintf("%d-%d", round, i), Amount: a})
+				m.Update(a, round)
+			}
+			m.LastRound = round
+			c.Metrics = m
+			Expect(repo.CommitRound(ctx, c)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			commit(1, 20, 30)
+			commit(2)
+			commit(3, 5, 90)
+			commit(4, 40)
+		})
+
+		It("should list the most recent rounds oldest first", func() {
+			recent, err := repo.RecentRounds(ctx, 2)
+			Expect(err).To(BeNil())
+			Expect(recent).To(Equal([]models.RoundStats{
+				{Round: 3, Hash: "h3", Count: 2, Sum: models.NewInt128(95), Min: 5, Max: 90, Mean: 47.5, M2: 3612.5},
+				{Round: 4, Hash: "h4", Count: 1, Sum: models.NewInt128(40), Min: 40, Max: 40, Mean: 40},
+			}))
+		})
+		It("should subtract rolled back rounds and recompute min and max", func() {
+			m, err := repo.RollbackTo(ctx, 2)
+			Expect(err).To(BeNil())
+			Expect(m.Mean).To(BeNumerically("~", 25, 1e-9))
+			Expect(m.Variance()).To(BeNumerically("~", 25, 1e-9))
+			m.Mean, m.M2 = 0, 0
+			Expect(m).To(Equal(models.Metrics{Count: 2, Sum: models.NewInt128(50), Min: 20, Max: 30, LastRound: 2}))
+
+			loaded, err := repo.LoadMetrics(ctx)
+			Expect(err).To(BeNil())
+			Expect(loaded.Count).To(Equal(int64(2)))
+			Expect(loaded.Mean).To(BeNumerically("~", 25, 1e-9))
+			events, err := repo.QueryEvents(ctx, models.EventFilter{FromRound: ptr(3)})
+			Expect(err).To(BeNil())
+			Expect(events).To(BeEmpty())
+
+			d, err := repo.LoadDistribution(ctx)
+			Expect(err).To(BeNil())
+			Expect(d.Count()).To(Equal(int64(2)))
+			maxAmount, _ := d.Quantile(1)
+			Expect(maxAmount).To(BeNumerically("~", 30, 1))
+
+			commit(3, 1)
+			loaded, err = repo.LoadMetrics(ctx)
+			Expect(err).To(BeNil())
+			Expect(loaded.Mean).To(BeNumerically("~", 17, 1e-9))
+			Expect(loaded.M2).To(BeNumerically("~", 434, 1e-9))
+			loaded.Mean, loaded.M2 = 0, 0
+			Expect(loaded).To(Equal(models.Metrics{Count: 3, Sum: models.NewInt128(51), Min: 1, Max: 30, LastRound: 3}))
+		})
+		It("should take the rolled back amounts out of the distribution", func() {
+			commit(5, 0, 7, 90)
+			_, err := repo.RollbackTo(ctx, 3)
+			Expect(err).To(BeNil())
+
+			want := models.NewDistribution()
+			for _, a := range []int64{20, 30, 5, 90} {
+				want.Add(a)
+			}
+			d, err := repo.LoadDistribution(ctx)
+			Expect(err).To(BeNil())
+			Expect(d.Count()).To(Equal(want.Count()))
+			Expect(d.Buckets()).To(Equal(want.Buckets()))
+			for _, q := range []float64{0, 0.25, 0.5, 1} {
+				got, ok := d.Quantile(q)
+				Expect(ok).To(BeTrue())
+				wantQ, _ := want.Quantile(q)
+				Expect(got).To(Equal(wantQ))
+			}
+		})
+		It("should reset the metrics when every round is rolled back", func() {
+			m, err := repo.RollbackTo(ctx, 0)
+			Expect(err).To(BeNil())
+			Expect(m).To(Equal(models.NewMetrics()))
+		})
+	})
+
+	Describe("accounts", func() {
+		commit := func(round int64, events ...models.Event) {
+			m, err := repo.LoadMetrics(ctx)
+			Expect(err).To(BeNil())
+			c := models.RoundCommit{Round: round, Hash: fmt.Sprintf("h%d", round)}
+			for i, e := range events {
+				e.Round, e.Sig = round, fmt.Sprintf("%d-%d", round, i)
+				c.Events = append(c.Events, e)
+				Expect(m.Update(e.Amount, round)).To(Succeed())
+			}
+			m.LastRound = round
+			c.Metrics = m
+			Expect(repo.CommitRound(ctx, c)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			commit(1, models.Event{Sender: 1, Recipient: 2, Amount: 10})
+			commit(2, models.Event{Sender: 1, Recipient: 3, Amount: 50}, models.Event{Sender: 3, Recipient: 1, Amount: 5})
+			commit(3, models.Event{Sender: 1, Recipient: 2, Amount: 100})
+		})
+
+		It("should aggregate both directions of every account", func() {
+			a, err := repo.LoadAccount(ctx, 1)
+			Expect(err).To(BeNil())
+			Expect(a).To(Equal(models.Account{
+				ID:         1,
+				Sent:       models.Flow{Count: 3, Volume: models.NewInt128(160), Min: 10, Max: 100},
+				Received:   models.Flow{Count: 1, Volume: models.NewInt128(5), Min: 5, Max: 5},
+				FirstRound: 1,
+				LastRound:  3,
+			}))
+
+			_, err = repo.LoadAccount(ctx, 9)
+			Expect(err).To(MatchError(ErrAccountNotFound))
+		})
+		It("should rank the accounts", func() {
+			top, err := repo.TopAccounts(ctx, models.RankReceivedVolume, 2)
+			Expect(err).To(BeNil())
+			Expect(top).To(HaveLen(2))
+			Expect(top[0].ID).To(Equal(int64(2)))
+			Expect(top[1].ID).To(Equal(int64(3)))
+		})
+		It("should roll the accounts back with the rounds", func() {
+			_, err := repo.RollbackTo(ctx, 1)
+			Expect(err).To(BeNil())
+
+			a, err := repo.LoadAccount(ctx, 1)
+			Expect(err).To(BeNil())
+			Expect(a).To(Equal(models.Account{
+				ID:         1,
+				Sent:       models.Flow{Count: 1, Volume: models.NewInt128(10), Min: 10, Max: 10},
+				FirstRound: 1,
+				LastRound:  1,
+			}))
+			_, err = repo.LoadAccount(ctx, 3)
+			Expect(err).To(MatchError(ErrAccountNotFound))
+		})
+	})
+
+	Describe("round stats", func() {
+		day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+		commit := func(round int64, ingestedAt time.Time, txCount int64, amounts ...int64) {
+			m, err := repo.LoadMetrics(ctx)
+			Expect(err).To(BeNil())
+			c := models.RoundCommit{Round: round, Hash: fmt.Sprintf("h%d", round), TxCount: txCount, IngestedAt: ingestedAt}
+			for i, a := range amounts {
+				c.Events = append(c.Events, models.Event{Round: round, Sig: fmt.Sprintf("%d-%d", round, i), Amount: a})
+				Expect(m.Update(a, round)).To(Succeed())
+			}
+			m.LastRound = round
+			c.Metrics = m
+			Expect(repo.CommitRound(ctx, c)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			commit(1, day.Add(10*time.Hour+15*time.Minute), 3, 20, 30)
+			commit(2, day.Add(10*time.Hour+45*time.Minute), 1)
+			commit(3, day.Add(11*time.Hour+5*time.Minute), 1, 5)
+			commit(4, day.Add(24*time.Hour+10*time.Minute), 2, 40)
+		})
+
+		It("should record each round and filter them by round and ingest time", func() {
+			rounds, err := repo.QueryRounds(ctx, models.RoundFilter{FromRound: ptr(2), ToRound: ptr(3)})
+			Expect(err).To(BeNil())
+			Expect(rounds).To(Equal([]models.RoundStats{
+				{Round: 2, Hash: "h2", TxCount: 1, IngestedAt: day.Add(10*time.Hour + 45*time.Minute)},
+				{Round: 3, Hash: "h3", TxCount: 1, Count: 1, Sum: models.NewInt128(5), Min: 5, Max: 5, Mean: 5, IngestedAt: day.Add(11*time.Hour + 5*time.Minute)},
+			}))
+
+			from, to := day.Add(10*time.Hour), day.Add(11*time.Hour)
+			rounds, err = repo.QueryRounds(ctx, models.RoundFilter{From: &from, To: &to, Limit: 1})
+			Expect(err).To(BeNil())
+			Expect(rounds).To(HaveLen(1))
+			Expect(rounds[0].Round).To(Equal(int64(1)))
+		})
+		It("should roll the rounds up by hour and day", func() {
+			hours, err := repo.QueryRollups(ctx, models.PeriodHour, day, day.Add(48*time.Hour), 0)
+			Expect(err).To(BeNil())
+			Expect(hours).To(Equal([]models.Rollup{
+				{Period: models.PeriodHour, Start: day.Add(10 * time.Hour), Rounds: 2, TxCount: 4, Count: 2, Volume: models.NewInt128(50), Min: 20, Max: 30},
+				{Period: models.PeriodHour, Start: day.Add(11 * time.Hour), Rounds: 1, TxCount: 1, Count: 1, Volume: models.NewInt128(5), Min: 5, Max: 5},
+				{Period: models.PeriodHour, Start: day.Add(24 * time.Hour), Rounds: 1, TxCount: 2, Count: 1, Volume: models.NewInt128(40), Min: 40, Max: 40},
+			}))
+
+			days, err := repo.QueryRollups(ctx, models.PeriodDay, day.Add(time.Hour), day.Add(24*time.Hour), 0)
+			Expect(err).To(BeNil())
+			Expect(days).To(Equal([]models.Rollup{
+				{Period: models.PeriodDay, Start: day, Rounds: 3, TxCount: 5, Count: 3, Volume: models.NewInt128(55), Min: 5, Max: 30},
+			}))
+		})
+		It("should recompute the rollups of rolled back rounds", func() {
+			_, err := repo.RollbackTo(ctx, 2)
+			Expect(err).To(BeNil())
+
+			hours, err := repo.QueryRollups(ctx, models.PeriodHour, day, day.Add(48*time.Hour), 0)
+			Expect(err).To(BeNil())
+			Expect(hours).To(HaveLen(1))
+			days, err := repo.QueryRollups(ctx, models.PeriodDay, day, day.Add(48*time.Hour), 0)
+			Expect(err).To(BeNil())
+			Expect(days).To(Equal([]models.Rollup{
+				{Period: models.PeriodDay, Start: day, Rounds: 2, TxCount: 4, Count: 2, Volume: models.NewInt128(50), Min: 20, Max: 30},
+			}))
+
+			commit(3, day.Add(11*time.Hour), 1, 1)
+			days, err = repo.QueryRollups(ctx, models.PeriodDay, day, day.Add(48*time.Hour), 0)
+			Expect(err).To(BeNil())
+			Expect(days[0].Min).To(Equal(int64(1)))
+		})
+	})
+
+	Describe("point-in-time metrics", func() {
+		commit := func(round int64, snapshot bool, amounts ...int64) {
+			m, err := repo.LoadMetrics(ctx)
+			Expect(err).To(BeNil())
+			c := models.RoundCommit{Round: round, Hash: fmt.Sprintf("h%d", round), Snapshot: snapshot}
+			for i, a := range amounts {
+				c.Events = append(c.Events, models.Event{Round: round, Sig: fmt.Sprintf("%d-%d", round, i), Amount: a})
+				Expect(m.Update(a, round)).To(Succeed())
+			}
+			m.LastRound = round
+			c.Metrics = m
+			Expect(repo.CommitRound(ctx, c)).To(Succeed())
+		}
+		// metricsOf computes the metrics of the amounts one by one, as the ingestor does.
+		metricsOf := func(round int64, amounts ...int64) models.Metrics {
+			m := models.NewMetrics()
+			for _, a := range amounts {
+				Expect(m.Update(a, round)).To(Succeed())
+			}
+			m.LastRound = round
+			return m
+		}
+		expectMetrics := func(round int64, want models.Metrics) {
+			m, err := repo.MetricsAt(ctx, round)
+			Expect(err).To(BeNil())
+			Expect(m.Mean).To(BeNumerically("~", want.Mean, 1e-9))
+			Expect(m.M2).To(BeNumerically("~", want.M2, 1e-6))
+			m.Mean, m.M2, want.Mean, want.M2 = 0, 0, 0, 0
+			Expect(m).To(Equal(want))
+		}
+
+		BeforeEach(func() {
+			commit(1, false, 20, 30)
+			commit(2, true, 10)
+			commit(3, false)
+			commit(4, false, 90, 5)
+			commit(5, false, 40)
+		})
+
+		It("should add the rounds since the last snapshot up", func() {
+			expectMetrics(0, models.NewMetrics())
+			expectMetrics(1, metricsOf(1, 20, 30))
+			expectMetrics(2, metricsOf(2, 20, 30, 10))
+			expectMetrics(3, metricsOf(3, 20, 30, 10))
+			expectMetrics(4, metricsOf(4, 20, 30, 10, 90, 5))
+			expectMetrics(5, metricsOf(5, 20, 30, 10, 90, 5, 40))
+
+			_, err := repo.MetricsAt(ctx, 6)
+			Expect(err).To(MatchError(ErrRoundNotAvailable))
+		})
+		It("should drop the snapshots of rolled back rounds", func() {
+			_, err := repo.RollbackTo(ctx, 1)
+			Expect(err).To(BeNil())
+			commit(2, false, 7)
+			commit(3, false)
+
+			expectMetrics(2, metricsOf(2, 20, 30, 7))
+		})
+	})
+
+	Describe("events", func() {
+		BeforeEach(func() {
+			Expect(repo.SaveEvents(ctx, []models.Event{
+				{Round: 100, Sig: "a", Sender: 1, Recipient: 2, Amount: 10},
+				{Round: 150, Sig: "c", Sender: 1, Recipient: 3, Amount: 50},
+				{Round: 150, Sig: "b", Sender: 2, Recipient: 1, Amount: 500},
+				{Round: 250, Sig: "d", Sender: 1, Recipient: 2, Amount: 20},
+			})).To(Succeed())
+		})
+
+		sigs := func(events []models.Event) []string {
+			out := make([]string, 0, len(events))
+			for _, e := range events {
+				out = append(out, e.Sig)
+			}
+			return out
+		}
+
+		It("should ignore events that are already stored", func() {
+			Expect(repo.SaveEvents(ctx, []models.Event{{Round: 100, Sig: "a", Sender: 9, Recipient: 9, Amount: 1}})).To(Succeed())
+			events, err := repo.QueryEvents(ctx, models.EventFilter{})
+			Expect(err).To(BeNil())
+			Expect(sigs(events)).To(Equal([]string{"a", "b", "c", "d"}))
+			Expect(events[0].Amount).To(Equal(int64(10)))
+		})
+		It("should filter by round range and sender", func() {
+			events, err := repo.QueryEvents(ctx, models.EventFilter{Sender: ptr(1), FromRound: ptr(100), ToRound: ptr(200)})
+			Expect(err).To(BeNil())
+			Expect(sigs(events)).To(Equal([]string{"a", "c"}))
+		})
+		It("should filter by recipient and amount range", func() {
+			events, err := repo.QueryEvents(ctx, models.EventFilter{Recipient: ptr(2), MinAmount: ptr(15), MaxAmount: ptr(20)})
+			Expect(err).To(BeNil())
+			Expect(sigs(events)).To(Equal([]string{"d"}))
+		})
+		It("should page through events with a cursor", func() {
+			page, err := repo.QueryEvents(ctx, models.EventFilter{Limit: 2})
+			Expect(err).To(BeNil())
+			Expect(sigs(page)).To(Equal([]string{"a", "b"}))
+
+			page, err = repo.QueryEvents(ctx, models.EventFilter{Limit: 2, After: &models.EventCursor{Round: 150, Sig: "b"}})
+			Expect(err).To(BeNil())
+			Expect(sigs(page)).To(Equal([]string{"c", "d"}))
+		})
+	})
+
+	Describe("scopes", func() {
+		It("should report a missing scope", func() {
+			_, err := repo.LoadScope(ctx, "nope")
+			Expect(err).To(MatchError(ErrScopeNotFound))
+		})
+		It("should commit scope rounds in range without moving the live checkpoint", func() {
+			sc, err := repo.ResetScope(ctx, "history", 10, 20)
+			Expect(err).To(BeNil())
+			Expect(sc.Metrics.LastRound).To(Equal(int64(9)))
+
+			m := models.Metrics{Count: 1, Sum: models.NewInt128(7), Min: 7, Max: 7}
+			Expect(repo.CommitScopeRound(ctx, "history", models.RoundCommit{Round: 10, Events: []models.Event{{Round: 10, Sig: "x", Amount: 7}}, Metrics: m})).To(Succeed())
+			Expect(repo.CommitScopeRound(ctx, "history", models.RoundCommit{Round: 10, Metrics: m})).To(MatchError(ErrRoundCommitted))
+			Expect(repo.CommitScopeRound(ctx, "history", models.RoundCommit{Round: 21, Metrics: m})).To(MatchError(ErrRoundCommitted))
+
+			sc, err = repo.LoadScope(ctx, "history")
+			Expect(err).To(BeNil())
+			m.LastRound = 10
+			Expect(sc.Metrics).To(Equal(m))
+			Expect(sc.FromRound).To(Equal(int64(10)))
+			Expect(sc.ToRound).To(Equal(int64(20)))
+			Expect(sc.Distribution.Count()).To(Equal(int64(1)))
+
+			live, err := repo.LoadMetrics(ctx)
+			Expect(err).To(BeNil())
+			Expect(live.LastRound).To(BeZero())
+			events, err := repo.QueryEvents(ctx, models.EventFilter{})
+			Expect(err).To(BeNil())
+			Expect(events).To(BeEmpty())
+		})
+		It("should discard a scope's content when it is reset", func() {
+			_, err := repo.ResetScope(ctx, "history", 10, 20)
+			Expect(err).To(BeNil())
+			Expect(repo.CommitScopeRound(ctx, "history", models.RoundCommit{Round: 10, Metrics: models.Metrics{Count: 1}})).To(Succeed())
+
+			_, err = repo.ResetScope(ctx, "history", 1, 5)
+			Expect(err).To(BeNil())
+			sc, err := repo.LoadScope(ctx, "history")
+			Expect(err).To(BeNil())
+			Expect(sc).To(Equal(models.NewScope("history", 1, 5)))
+		})
+	})
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// dialect holds what differs between the SQL databases the repository runs on. Queries are written
+// with ? placeholders and rebound to the dialect's own before they reach the driver.
+type dialect struct {
+	name string
+	// numbered placeholders are $1, $2... instead of ?.
+	numbered bool
+	// numeric is the type decimal sums are cast to for ordering.
+	numeric string
+}
+
+var (
+	sqliteDialect   = dialect{name: "sqlite", numeric: "REAL"}
+	postgresDialect = dialect{name: "postgres", numbered: true, numeric: "NUMERIC"}
+)
+
+// rebind rewrites the ? placeholders of the query for the dialect. None of the queries hold a ? in a literal.
+func (d dialect) rebind(q string) string {
+	if !d.numbered {
+		return q
+	}
+	var (
+		b strings.Builder
+		n int
+	)
+	for {
+		i := strings.IndexByte(q, '?')
+		if i < 0 {
+			b.WriteString(q)
+			return b.String()
+		}
+		n++
+		b.WriteString(q[:i])
+		b.WriteString("$" + strconv.Itoa(n))
+		q = q[i+1:]
+	}
+}
+
+// upsert returns the statement inserting the columns into the table, or updating them in the row
+// that already has the key.
+func upsert(table, columns, key string) string {
+	cols := strings.Split(columns, ",")
+	set := make([]string, 0, len(cols))
+	for i, c := range cols {
+		cols[i] = strings.TrimSpace(c)
+		if !strings.Contains(","+key+",", ","+cols[i]+",") {
+			set = append(set, cols[i]+"=excluded."+cols[i])
+		}
+	}
+	return `INSERT INTO ` + table + `(` + strings.Join(cols, ", ") + `) VALUES(` + strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ") +
+		`) ON CONFLICT(` + key + `) DO UPDATE SET ` + strings.Join(set, ", ")
+}
+
+// dbtx is implemented by both *sqlDB and *sqlTx.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// sqlDB is a *sql.DB that rebinds its queries for the dialect.
+type sqlDB struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (db *sqlDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return db.DB.ExecContext(ctx, db.dialect.rebind(query), args...)
+}
+
+func (db *sqlDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return db.DB.QueryContext(ctx, db.dialect.rebind(query), args...)
+}
+
+func (db *sqlDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return db.DB.QueryRowContext(ctx, db.dialect.rebind(query), args...)
+}
+
+func (db *sqlDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sqlTx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{Tx: tx, dialect: db.dialect}, nil
+}
+
+// sqlTx is a *sql.Tx that rebinds its queries for the dialect.
+type sqlTx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (tx *sqlTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return tx.Tx.ExecContext(ctx, tx.dialect.rebind(query), args...)
+}
+
+func (tx *sqlTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.QueryContext(ctx, tx.dialect.rebind(query), args...)
+}
+
+func (tx *sqlTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRowContext(ctx, tx.dialect.rebind(query), args...)
+}
+
+func (tx *sqlTx) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return tx.Tx.PrepareContext(ctx, tx.dialect.rebind(query))
+}
@@ -0,0 +1,18 @@
+package repository
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dialect", func() {
+	It("should number the placeholders for Postgres only", func() {
+		q := `SELECT a FROM t WHERE b=? AND c IN (?, ?) LIMIT ?`
+		Expect(sqliteDialect.rebind(q)).To(Equal(q))
+		Expect(postgresDialect.rebind(q)).To(Equal(`SELECT a FROM t WHERE b=$1 AND c IN ($2, $3) LIMIT $4`))
+	})
+	It("should update every column but the key on conflict", func() {
+		Expect(upsert("round_rollups", "period, start, rounds,\n\tvolume", "period,start")).To(Equal(
+			`INSERT INTO round_rollups(period, start, rounds, volume) VALUES(?, ?, ?, ?) ON CONFLICT(period,start) DO UPDATE SET rounds=excluded.rounds, volume=excluded.volume`))
+	})
+})
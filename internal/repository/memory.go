@@ -0,0 +1,544 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+// MemoryMetrics keeps everything in memory, for tests and ephemeral runs: it is gone when the process
+// exits. It behaves like the SQL repositories and is safe for concurrent use.
+type MemoryMetrics struct {
+	mu           sync.RWMutex
+	metrics      models.Metrics
+	distribution *models.Distribution
+	// events are kept in (round, sig) order, sigs holds their sigs.
+	events []models.Event
+	sigs   map[string]bool
+	// rounds holds the stats of the committed rounds in round order.
+	rounds    []models.RoundStats
+	snapshots map[int64]models.Metrics
+	rollups   map[rollupKey]models.Rollup
+	accounts  map[int64]models.Account
+	scopes    map[string]models.Scope
+}
+
+type rollupKey struct {
+	period models.Period
+	start  int64
+}
+
+func keyOf(r models.Rollup) rollupKey {
+	return rollupKey{period: r.Period, start: r.Start.Unix()}
+}
+
+func NewMemoryMetrics() Repository {
+	return &MemoryMetrics{
+		metrics:      models.NewMetrics(),
+		distribution: models.NewDistribution(),
+		sigs:         make(map[string]bool),
+		snapshots:    make(map[int64]models.Metrics),
+		rollups:      make(map[rollupKey]models.Rollup),
+		accounts:     make(map[int64]models.Account),
+		scopes:       make(map[string]models.Scope),
+	}
+}
+
+func (s *MemoryMetrics) Init(context.Context) error { return nil }
+
+// cloneDistribution copies d, so that callers never share the stored one.
+func cloneDistribution(d *models.Distribution) *models.Distribution {
+	c := models.NewDistribution()
+	// Merging only fails between sketches of different accuracies, and every distribution has the same.
+	_ = c.Merge(d)
+	return c
+}
+
+func compareEvents(a, b models.Event) int {
+	return cmp.Or(cmp.Compare(a.Round, b.Round), strings.Compare(a.Sig, b.Sig))
+}
+
+func (s *MemoryMetrics) LoadMetrics(context.Context) (models.Metrics, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.metrics, nil
+}
+
+func (s *MemoryMetrics) LoadDistribution(context.Context) (*models.Distribution, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return cloneDistribution(s.distribution), nil
+}
+
+func (s *MemoryMetrics) SaveMetrics(_ context.Context, m models.Metrics) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics = m
+	return nil
+}
+
+func (s *MemoryMetrics) RepairMetrics(_ context.Context, m models.Metrics) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.metrics.LastRound != m.LastRound {
+		return ErrCheckpointMoved
+	}
+	s.metrics = m
+	return nil
+}
+
+func (s *MemoryMetrics) CommitRound(_ context.Context, c models.RoundCommit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.metrics.LastRound >= c.Round {
+		return ErrRoundCommitted
+	}
+
+	// Everything that can fail is computed before anything is stored.
+	seen := make(map[string]bool, len(c.Events))
+	for _, e := range c.Events {
+		if s.sigs[e.Sig] || seen[e.Sig] {
+			return &DuplicateEventError{Sig: e.Sig}
+		}
+		seen[e.Sig] = true
+	}
+	deltas, err := models.AccountsOf(c.Events)
+	if err != nil {
+		return err
+	}
+	accounts := make(map[int64]models.Account, len(deltas))
+	for id, d := range deltas {
+		a, ok := s.accounts[id]
+		if !ok {
+			a = models.Account{ID: id}
+		}
+		if err := a.Merge(*d); err != nil {
+			return err
+		}
+		accounts[id] = a
+	}
+	st := c.Stats()
+	var rollups []models.Rollup
+	if !st.IngestedAt.IsZero() {
+		// Ingest times are kept to the millisecond, like the SQL repositories do.
+		st.IngestedAt = time.UnixMilli(st.IngestedAt.UnixMilli()).UTC()
+		for _, p := range models.Periods {
+			start := time.Unix(p.Start(st.IngestedAt).Unix(), 0).UTC()
+			r, ok := s.rollups[rollupKey{period: p, start: start.Unix()}]
+			if !ok {
+				r = models.Rollup{Period: p, Start: start}
+			}
+			if err := r.Add(st); err != nil {
+				return err
+			}
+			rollups = append(rollups, r)
+		}
+	}
+
+	s.metrics = c.Metrics
+	s.metrics.LastRound = c.Round
+	s.insertEvents(c.Events)
+	maps.Copy(s.accounts, accounts)
+	for _, e := range c.Events {
+		s.distribution.Add(e.Amount)
+	}
+	s.rounds = append(s.rounds, st)
+	for _, r := range rollups {
+		s.rollups[keyOf(r)] = r
+	}
+	if c.Snapshot {
+		s.snapshots[c.Metrics.LastRound] = c.Metrics
+	}
+	return nil
+}
+
+// insertEvents stores the events in order, ignoring sigs that are already stored.
+func (s *MemoryMetrics) insertEvents(events []models.Event) {
+	for _, e := range events {
+		if s.sigs[e.Sig] {
+			continue
+		}
+		s.sigs[e.Sig] = true
+		i, _ := slices.BinarySearchFunc(s.events, e, compareEvents)
+		s.events = slices.Insert(s.events, i, e)
+	}
+}
+
+func (s *MemoryMetrics) RecentRounds(_ context.Context, n int) ([]models.RoundStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	from := 0
+	if n >= 0 {
+		from = max(len(s.rounds)-n, 0)
+	}
+	return append(make([]models.RoundStats, 0, len(s.rounds)-from), s.rounds[from:]...), nil
+}
+
+func (s *MemoryMetrics) MetricsAt(_ context.Context, round int64) (models.Metrics, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	live := s.metrics
+	switch {
+	case round < 0 || round > live.LastRound:
+		return models.Metrics{}, fmt.Errorf("%w: round %d is not between 0 and the last committed round %d", ErrRoundNotAvailable, round, live.LastRound)
+	case round == live.LastRound:
+		return live, nil
+	}
+
+	m := models.NewMetrics()
+	for r, snapshot := range s.snapshots {
+		if r <= round && r > m.LastRound {
+			m = snapshot
+		}
+	}
+	from := m.LastRound + 1
+	stats := s.queryRounds(s.rounds, models.RoundFilter{FromRound: &from, ToRound: &round})
+	// Every round is committed, so a gap means the round predates the stats.
+	if int64(len(stats)) != round-m.LastRound {
+		return models.Metrics{}, fmt.Errorf("%w: the rounds before round %d were committed without stats", ErrRoundNotAvailable, round)
+	}
+	for _, st := range stats {
+		if err := m.AddRound(st); err != nil {
+			return models.Metrics{}, err
+		}
+	}
+	m.LastRound = round
+	return m, nil
+}
+
+func (s *MemoryMetrics) QueryRounds(_ context.Context, f models.RoundFilter) ([]models.RoundStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.queryRounds(s.rounds, f), nil
+}
+
+// queryRounds selects the rounds matching the filter.
+func (s *MemoryMetrics) queryRounds(rounds []models.RoundStats, f models.RoundFilter) []models.RoundStats {
+	stats := make([]models.RoundStats, 0)
+	for _, st := range rounds {
+		if f.Limit > 0 && len(stats) == f.Limit {
+			break
+		}
+		if matchRound(st, f) {
+			stats = append(stats, st)
+		}
+	}
+	return stats
+}
+
+// matchRound reports whether the round matches the filter, ignoring its limit. Rounds without an
+// ingest time never match a time range.
+func matchRound(st models.RoundStats, f models.RoundFilter) bool {
+	at := st.IngestedAt.UnixMilli()
+	switch {
+	case f.FromRound != nil && st.Round < *f.FromRound,
+		f.ToRound != nil && st.Round > *f.ToRound,
+		(f.From != nil || f.To != nil) && st.IngestedAt.IsZero(),
+		f.From != nil && at < f.From.UnixMilli(),
+		f.To != nil && at >= f.To.UnixMilli():
+		return false
+	}
+	return true
+}
+
+func (s *MemoryMetrics) QueryRollups(_ context.Context, period models.Period, from, to time.Time, limit int) ([]models.Rollup, error) {
+	if _, err := models.ParsePeriod(string(period)); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rollups := make([]models.Rollup, 0)
+	for k, r := range s.rollups {
+		if k.period == period && k.start >= period.Start(from).Unix() && k.start < to.Unix() {
+			rollups = append(rollups, r)
+		}
+	}
+	slices.SortFunc(rollups, func(a, b models.Rollup) int { return a.Start.Compare(b.Start) })
+	if limit > 0 && len(rollups) > limit {
+		rollups = rollups[:limit]
+	}
+	return rollups, nil
+}
+
+func (s *MemoryMetrics) RollbackTo(_ context.Context, round int64) (models.Metrics, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// As in the SQL repositories, count and sum are subtracted exactly, min and max recomputed from
+	// the rounds that remain when a removed round held them, and the spread of the removed events
+	// taken out of the mean and M2. Everything that can fail is computed before anything is changed.
+	m := s.metrics
+	firstEvent := sort.Search(len(s.events), func(i int) bool { return s.events[i].Round > round })
+	keptEvents, removedEvents := s.events[:firstEvent], s.events[firstEvent:]
+	removed := models.NewMetrics()
+	removedAccounts := make(models.Accounts)
+	removedAmounts := make([]int64, 0, len(removedEvents))
+	for _, e := range removedEvents {
+		if err := removed.Update(e.Amount, 0); err != nil {
+			return models.Metrics{}, err
+		}
+		if err := removedAccounts.Add(e); err != nil {
+			return models.Metrics{}, err
+		}
+		removedAmounts = append(removedAmounts, e.Amount)
+	}
+	m.RemoveSpread(removed.Count, removed.Mean, removed.M2)
+	distribution := cloneDistribution(s.distribution)
+	if err := distribution.Remove(removedAmounts...); err != nil {
+		return models.Metrics{}, err
+	}
+
+	firstRound := sort.Search(len(s.rounds), func(i int) bool { return s.rounds[i].Round > round })
+	keptRounds, removedRounds := s.rounds[:firstRound], s.rounds[firstRound:]
+	var (
+		removedSum             models.Int128
+		removedMin, removedMax *int64
+		removedRollups         = make(map[rollupKey]models.Rollup)
+		err                    error
+	)
+	for _, st := range removedRounds {
+		m.Count -= st.Count
+		if removedSum, err = removedSum.Add(st.Sum); err != nil {
+			return models.Metrics{}, err
+		}
+		if st.Count > 0 {
+			removedMin, removedMax = minOf(removedMin, st.Min), maxOf(removedMax, st.Max)
+		}
+		if !st.IngestedAt.IsZero() {
+			for _, p := range models.Periods {
+				r := models.Rollup{Period: p, Start: time.Unix(p.Start(st.IngestedAt).Unix(), 0).UTC()}
+				removedRollups[keyOf(r)] = r
+			}
+		}
+	}
+	if m.Sum, err = m.Sum.Sub(removedSum); err != nil {
+		return models.Metrics{}, err
+	}
+	var keptMin, keptMax *int64
+	for _, st := range keptRounds {
+		if st.Count > 0 {
+			keptMin, keptMax = minOf(keptMin, st.Min), maxOf(keptMax, st.Max)
+		}
+	}
+	switch {
+	case m.Count == 0:
+		m.Min, m.Max = models.NewMetrics().Min, 0
+	default:
+		if removedMin != nil && *removedMin <= m.Min && keptMin != nil {
+			m.Min = *keptMin
+		}
+		if removedMax != nil && *removedMax >= m.Max && keptMax != nil {
+			m.Max = *keptMax
+		}
+	}
+	m.LastRound = round
+
+	kept := make(models.Accounts)
+	for _, e := range keptEvents {
+		if removedAccounts[e.Sender] != nil || removedAccounts[e.Recipient] != nil {
+			if err := kept.Add(e); err != nil {
+				return models.Metrics{}, err
+			}
+		}
+	}
+	accounts, err := subtractAccounts(removedAccounts, kept, func(id int64) (models.Account, error) {
+		a, ok := s.accounts[id]
+		if !ok {
+			a = models.Account{ID: id}
+		}
+		return a, nil
+	})
+	if err != nil {
+		return models.Metrics{}, err
+	}
+	for k, r := range removedRollups {
+		rebuilt := models.Rollup{Period: r.Period, Start: r.Start}
+		for _, st := range s.queryRounds(keptRounds, models.RoundFilter{From: &r.Start, To: ptrTo(r.Start.Add(r.Period.Duration()))}) {
+			if err := rebuilt.Add(st); err != nil {
+				return models.Metrics{}, err
+			}
+		}
+		removedRollups[k] = rebuilt
+	}
+
+	s.metrics = m
+	for _, e := range removedEvents {
+		delete(s.sigs, e.Sig)
+	}
+	s.events = slices.Clip(keptEvents)
+	s.rounds = slices.Clip(keptRounds)
+	maps.DeleteFunc(s.snapshots, func(r int64, _ models.Metrics) bool { return r > round })
+	s.distribution = distribution
+	for id, a := range accounts {
+		if a.Transfers() <= 0 {
+			delete(s.accounts, id)
+		} else {
+			s.accounts[id] = a
+		}
+	}
+	for k, r := range removedRollups {
+		if r.Rounds == 0 {
+			delete(s.rollups, k)
+		} else {
+			s.rollups[k] = r
+		}
+	}
+	return m, nil
+}
+
+// subtractAccounts returns the accounts, as load reads them, with the removed transfers subtracted and
+// their min, max and round range taken from the kept ones, the transfers that remain of the same accounts.
+// Accounts left without transfers have none.
+func subtractAccounts(removed, kept models.Accounts, load func(id int64) (models.Account, error)) (map[int64]models.Account, error) {
+	accounts := make(map[int64]models.Account, len(removed))
+	for id, r := range removed {
+		a, err := load(id)
+		if err != nil {
+			return nil, err
+		}
+		a.Sent.Count -= r.Sent.Count
+		a.Received.Count -= r.Received.Count
+		if a.Transfers() <= 0 {
+			accounts[id] = a
+			continue
+		}
+		if a.Sent.Volume, err = a.Sent.Volume.Sub(r.Sent.Volume); err != nil {
+			return nil, err
+		}
+		if a.Received.Volume, err = a.Received.Volume.Sub(r.Received.Volume); err != nil {
+			return nil, err
+		}
+		var k models.Account
+		if kept[id] != nil {
+			k = *kept[id]
+		}
+		a.Sent.Min, a.Sent.Max = k.Sent.Min, k.Sent.Max
+		a.Received.Min, a.Received.Max = k.Received.Min, k.Received.Max
+		a.FirstRound, a.LastRound = k.FirstRound, k.LastRound
+		accounts[id] = a
+	}
+	return accounts, nil
+}
+
+func minOf(cur *int64, v int64) *int64 {
+	if cur == nil || v < *cur {
+		return &v
+	}
+	return cur
+}
+
+func maxOf(cur *int64, v int64) *int64 {
+	if cur == nil || v > *cur {
+		return &v
+	}
+	return cur
+}
+
+func (s *MemoryMetrics) SaveEvents(_ context.Context, events []models.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.insertEvents(events)
+	return nil
+}
+
+func (s *MemoryMetrics) QueryEvents(_ context.Context, f models.EventFilter) ([]models.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := make([]models.Event, 0)
+	for _, e := range s.events {
+		if f.Limit > 0 && len(events) == f.Limit {
+			break
+		}
+		if f.Match(e) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (s *MemoryMetrics) LoadAccount(_ context.Context, id int64) (models.Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a, ok := s.accounts[id]
+	if !ok {
+		return models.Account{}, ErrAccountNotFound
+	}
+	return a, nil
+}
+
+func (s *MemoryMetrics) TopAccounts(_ context.Context, by models.AccountRanking, limit int) ([]models.Account, error) {
+	s.mu.RLock()
+	accounts := slices.Collect(maps.Values(s.accounts))
+	s.mu.RUnlock()
+	return rankAccounts(accounts, by, limit)
+}
+
+// rankAccounts orders the accounts by the ranking, highest first, and keeps up to limit of them.
+func rankAccounts(accounts []models.Account, by models.AccountRanking, limit int) ([]models.Account, error) {
+	var key func(a models.Account) models.Int128
+	switch by {
+	case models.RankSentVolume:
+		key = func(a models.Account) models.Int128 { return a.Sent.Volume }
+	case models.RankReceivedVolume:
+		key = func(a models.Account) models.Int128 { return a.Received.Volume }
+	case models.RankSentCount:
+		key = func(a models.Account) models.Int128 { return models.NewInt128(a.Sent.Count) }
+	case models.RankReceivedCount:
+		key = func(a models.Account) models.Int128 { return models.NewInt128(a.Received.Count) }
+	default:
+		return nil, fmt.Errorf("unknown ranking %q", by)
+	}
+	slices.SortFunc(accounts, func(a, b models.Account) int {
+		return cmp.Or(key(b).Cmp(key(a)), cmp.Compare(a.ID, b.ID))
+	})
+	if limit >= 0 && len(accounts) > limit {
+		accounts = accounts[:limit]
+	}
+	return accounts, nil
+}
+
+func (s *MemoryMetrics) LoadScope(_ context.Context, name string) (models.Scope, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sc, ok := s.scopes[name]
+	if !ok {
+		return models.Scope{}, ErrScopeNotFound
+	}
+	sc.Distribution = cloneDistribution(sc.Distribution)
+	return sc, nil
+}
+
+func (s *MemoryMetrics) ResetScope(_ context.Context, name string, from, to int64) (models.Scope, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc := models.NewScope(name, from, to)
+	s.scopes[name] = sc
+	sc.Distribution = cloneDistribution(sc.Distribution)
+	return sc, nil
+}
+
+func (s *MemoryMetrics) CommitScopeRound(_ context.Context, name string, c models.RoundCommit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc, ok := s.scopes[name]
+	if !ok || sc.Metrics.LastRound >= c.Round || c.Round < sc.FromRound || c.Round > sc.ToRound {
+		return ErrRoundCommitted
+	}
+	sc.Metrics = c.Metrics
+	sc.Metrics.LastRound = c.Round
+	for _, e := range c.Events {
+		sc.Distribution.Add(e.Amount)
+	}
+	s.scopes[name] = sc
+	return nil
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+var _ = Describe("MemoryMetrics", func() {
+	Context("as a repository", func() {
+		repositoryContract(func(context.Context) Repository { return NewMemoryMetrics() })
+	})
+
+	It("should commit each round once under concurrent writers", func() {
+		ctx := context.Background()
+		repo := NewMemoryMetrics()
+
+		var (
+			wg        sync.WaitGroup
+			mu        sync.Mutex
+			committed int
+		)
+		for w := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer GinkgoRecover()
+				for round := int64(1); round <= 50; round++ {
+					c := models.RoundCommit{
+						Round:   round,
+						Events:  []models.Event{{Round: round, Sig: fmt.Sprintf("%d-%d", round, w), Sender: 1, Recipient: 2, Amount: 1}},
+						Metrics: models.Metrics{Count: round, Sum: models.NewInt128(round), Min: 1, Max: 1, LastRound: round},
+					}
+					err := repo.CommitRound(ctx, c)
+					if err == nil {
+						mu.Lock()
+						committed++
+						mu.Unlock()
+						continue
+					}
+					Expect(err).To(MatchError(ErrRoundCommitted))
+					_, err = repo.LoadMetrics(ctx)
+					Expect(err).To(BeNil())
+				}
+			}()
+		}
+		wg.Wait()
+
+		Expect(committed).To(Equal(50))
+		events, err := repo.QueryEvents(ctx, models.EventFilter{})
+		Expect(err).To(BeNil())
+		Expect(events).To(HaveLen(50))
+		a, err := repo.LoadAccount(ctx, 1)
+		Expect(err).To(BeNil())
+		Expect(a.Sent.Count).To(Equal(int64(50)))
+	})
+})
@@ -0,0 +1,361 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSchemaTooNew is returned when the database was migrated further than this version of metrika knows.
+var ErrSchemaTooNew = errors.New("database schema is newer than this version of metrika")
+
+// Migration is a numbered step of a versioned schema. AppliedAt is zero while it is pending.
+type Migration struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+// Migrator is implemented by the repositories whose schema is versioned.
+type Migrator interface {
+	// Migrations lists the migrations this version of metrika knows, oldest first, and the database's version.
+	Migrations(ctx context.Context) ([]Migration, int, error)
+	// MigrateTo applies or reverts migrations, one transaction each, until the schema is at the version.
+	MigrateTo(ctx context.Context, version int) error
+}
+
+// migration moves the schema from the previous version to its own and back.
+type migration struct {
+	name     string
+	up, down func(ctx context.Context, tx *sqlTx) error
+}
+
+// sqliteMigrations holds the SQLite schema, version n at index n-1. Databases created before the schema
+// was versioned are at version 0 whatever they hold, so every up leaves alone what is already there.
+// Append new migrations, never change the ones that were released.
+var sqliteMigrations = []migration{
+	{
+		name: "metrics and events",
+		up: func(ctx context.Context, tx *sqlTx) error {
+			if err := execAll(ctx, tx,
+				`CREATE TABLE IF NOT EXISTS metrics(
+					id INTEGER PRIMARY KEY CHECK(id=1),
+					last_round INTEGER,
+					count INTEGER,
+					sum TEXT,
+					min INTEGER,
+					max INTEGER
+					);`,
+				`INSERT OR IGNORE INTO metrics(id, last_round, count, sum, min, max) VALUES(1, 0, 0, '0', 9223372036854775807, 0);`,
+				`CREATE TABLE IF NOT EXISTS events(
+					sig TEXT PRIMARY KEY,
+					round INTEGER NOT NULL,
+					sender INTEGER NOT NULL,
+					recipient INTEGER NOT NULL,
+					amount INTEGER NOT NULL
+					);`,
+				`CREATE INDEX IF NOT EXISTS idx_events_round ON events(round, sig);`,
+				`CREATE INDEX IF NOT EXISTS idx_events_sender ON events(sender, round);`,
+				`CREATE INDEX IF NOT EXISTS idx_events_recipient ON events(recipient, round);`,
+				`CREATE INDEX IF NOT EXISTS idx_events_amount ON events(amount);`,
+			); err != nil {
+				return err
+			}
+			// Sums are stored as decimal strings to keep all 128 bits. Databases created when they were
+			// 64-bit integers get their sum columns converted, here and in the next two migrations.
+			return textColumn(ctx, tx, "metrics", "sum")
+		},
+		down: func(ctx context.Context, tx *sqlTx) error {
+			return execAll(ctx, tx, `DROP TABLE events;`, `DROP TABLE metrics;`)
+		},
+	},
+	{
+		name: "round stats",
+		up: func(ctx context.Context, tx *sqlTx) error {
+			if err := execAll(ctx, tx, `CREATE TABLE IF NOT EXISTS round_stats(
+				round INTEGER PRIMARY KEY,
+				hash TEXT NOT NULL,
+				count INTEGER NOT NULL,
+				sum TEXT NOT NULL,
+				min INTEGER,
+				max INTEGER
+				);`); err != nil {
+				return err
+			}
+			return textColumn(ctx, tx, "round_stats", "sum")
+		},
+		down: func(ctx context.Context, tx *sqlTx) error {
+			return execAll(ctx, tx, `DROP TABLE round_stats;`)
+		},
+	},
+	{
+		name: "metric scopes",
+		up: func(ctx context.Context, tx *sqlTx) error {
+			if err := execAll(ctx, tx, `CREATE TABLE IF NOT EXISTS metric_scopes(
+				name TEXT PRIMARY KEY,
+				from_round INTEGER NOT NULL,
+				to_round INTEGER NOT NULL,
+				last_round INTEGER NOT NULL,
+				count INTEGER NOT NULL,
+				sum TEXT NOT NULL,
+				min INTEGER NOT NULL,
+				max INTEGER NOT NULL
+				);`); err != nil {
+				return err
+			}
+			return textColumn(ctx, tx, "metric_scopes", "sum")
+		},
+		down: func(ctx context.Context, tx *sqlTx) error {
+			return execAll(ctx, tx, `DROP TABLE metric_scopes;`)
+		},
+	},
+	{
+		// The metrics' distribution and spread are filled from the stored events. Scopes have no events of
+		// their own to fill them from: backfill them again with -reset to get their spread. Neither have
+		// metrics counted before the events were stored, their M2 is left NULL and read as 0.
+		name: "distribution and spread",
+		up: func(ctx context.Context, tx *sqlTx) error {
+			for _, table := range []string{"metrics", "metric_scopes"} {
+				for _, col := range []struct{ name, decl string }{{"distribution", "BLOB"}, {"mean", "REAL"}, {"m2", "REAL"}} {
+					if err := addColumn(ctx, tx, table, col.name, col.decl); err != nil {
+						return err
+					}
+				}
+			}
+			var noDistribution, noSpread bool
+			if err := tx.QueryRowContext(ctx, `SELECT distribution IS NULL, mean IS NULL OR m2 IS NULL FROM metrics WHERE id=1`).
+				Scan(&noDistribution, &noSpread); err != nil {
+				return err
+			}
+			if noDistribution {
+				if err := rebuildDistribution(ctx, tx); err != nil {
+					return err
+				}
+			}
+			if !noSpread {
+				return nil
+			}
+			var count, stored int64
+			if err := tx.QueryRowContext(ctx, `SELECT count, (SELECT COUNT(*) FROM events) FROM metrics WHERE id=1`).
+				Scan(&count, &stored); err != nil {
+				return err
+			}
+			if stored != count {
+				// The metrics predate the stored events, so not every amount is there to compute the spread
+				// from: the mean starts from the average and M2, unknown, is left NULL.
+				_, err := tx.ExecContext(ctx, `UPDATE metrics SET mean=COALESCE(CAST(sum AS DOUBLE PRECISION) / NULLIF(count, 0), 0), m2=NULL WHERE id=1`)
+				return err
+			}
+			spread, err := eventSpread(ctx, tx, `SELECT amount FROM events`)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `UPDATE metrics SET mean=?, m2=? WHERE id=1`, spread.Mean, spread.M2)
+			return err
+		},
+		down: func(ctx context.Context, tx *sqlTx) error {
+			for _, table := range []string{"metrics", "metric_scopes"} {
+				if err := dropColumns(ctx, tx, table, "distribution", "mean", "m2"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// The accounts are filled from the stored events.
+		name: "accounts",
+		up: func(ctx context.Context, tx *sqlTx) error {
+			exists, err := hasTable(ctx, tx, "accounts")
+			if err != nil {
+				return err
+			}
+			if err := execAll(ctx, tx,
+				`CREATE TABLE IF NOT EXISTS accounts(
+					id INTEGER PRIMARY KEY,
+					sent_count INTEGER NOT NULL,
+					sent_volume TEXT NOT NULL,
+					sent_min INTEGER,
+					sent_max INTEGER,
+					received_count INTEGER NOT NULL,
+					received_volume TEXT NOT NULL,
+					received_min INTEGER,
+					received_max INTEGER,
+					first_round INTEGER NOT NULL,
+					last_round INTEGER NOT NULL
+					);`,
+				`CREATE INDEX IF NOT EXISTS idx_accounts_sent_volume ON accounts(CAST(sent_volume AS REAL));`,
+				`CREATE INDEX IF NOT EXISTS idx_accounts_received_volume ON accounts(CAST(received_volume AS REAL));`,
+				`CREATE INDEX IF NOT EXISTS idx_accounts_sent_count ON accounts(sent_count);`,
+				`CREATE INDEX IF NOT EXISTS idx_accounts_received_count ON accounts(received_count);`,
+			); err != nil {
+				return err
+			}
+			if exists {
+				return nil
+			}
+			return rebuildAccounts(ctx, tx)
+		},
+		down: func(ctx context.Context, tx *sqlTx) error {
+			return execAll(ctx, tx, `DROP TABLE accounts;`)
+		},
+	},
+	{
+		// Rounds committed before the tx count and ingest time were recorded have neither, so they are
+		// left out of the rollups.
+		name: "round rollups",
+		up: func(ctx context.Context, tx *sqlTx) error {
+			for _, col := range []string{"tx_count", "ingested_at"} {
+				if err := addColumn(ctx, tx, "round_stats", col, "INTEGER"); err != nil {
+					return err
+				}
+			}
+			return execAll(ctx, tx,
+				`CREATE INDEX IF NOT EXISTS idx_round_stats_ingested_at ON round_stats(ingested_at);`,
+				`CREATE TABLE IF NOT EXISTS round_rollups(
+					period TEXT NOT NULL,
+					start INTEGER NOT NULL,
+					rounds INTEGER NOT NULL,
+					tx_count INTEGER NOT NULL,
+					count INTEGER NOT NULL,
+					volume TEXT NOT NULL,
+					min INTEGER,
+					max INTEGER,
+					PRIMARY KEY(period, start)
+					);`,
+			)
+		},
+		down: func(ctx context.Context, tx *sqlTx) error {
+			if err := execAll(ctx, tx, `DROP TABLE round_rollups;`, `DROP INDEX idx_round_stats_ingested_at;`); err != nil {
+				return err
+			}
+			return dropColumns(ctx, tx, "round_stats", "tx_count", "ingested_at")
+		},
+	},
+	{
+		// The spread of each round's own amounts, which point-in-time queries add up, is filled from the
+		// stored events.
+		name: "metric snapshots",
+		up: func(ctx context.Context, tx *sqlTx) error {
+			for _, col := range []string{"mean", "m2"} {
+				if err := addColumn(ctx, tx, "round_stats", col, "REAL"); err != nil {
+					return err
+				}
+			}
+			if err := fillRoundSpreads(ctx, tx); err != nil {
+				return err
+			}
+			return execAll(ctx, tx, `CREATE TABLE IF NOT EXISTS metric_snapshots(
+				round INTEGER PRIMARY KEY,
+				count INTEGER NOT NULL,
+				sum TEXT NOT NULL,
+				min INTEGER NOT NULL,
+				max INTEGER NOT NULL,
+				mean REAL NOT NULL,
+				m2 REAL NOT NULL
+				);`)
+		},
+		down: func(ctx context.Context, tx *sqlTx) error {
+			if err := execAll(ctx, tx, `DROP TABLE metric_snapshots;`); err != nil {
+				return err
+			}
+			return dropColumns(ctx, tx, "round_stats", "mean", "m2")
+		},
+	},
+}
+
+// schemaVersion returns the version the database is migrated to, creating the schema_version table
+// that records the applied migrations if it does not exist yet.
+func (s *SQLiteMetrics) schemaVersion(ctx context.Context) (int, error) {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version(
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at INTEGER NOT NULL
+		);`); err != nil {
+		return 0, err
+	}
+	var version int
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+func (s *SQLiteMetrics) Migrations(ctx context.Context) ([]Migration, int, error) {
+	version, err := s.schemaVersion(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT version, applied_at FROM schema_version`)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var (
+			v  int
+			at int64
+		)
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, 0, err
+		}
+		applied[v] = time.Unix(at, 0).UTC()
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	migrations := make([]Migration, 0, len(sqliteMigrations))
+	for i, m := range sqliteMigrations {
+		migrations = append(migrations, Migration{Version: i + 1, Name: m.name, AppliedAt: applied[i+1]})
+	}
+	return migrations, version, nil
+}
+
+func (s *SQLiteMetrics) MigrateTo(ctx context.Context, version int) error {
+	latest := len(sqliteMigrations)
+	if version < 0 || version > latest {
+		return fmt.Errorf("no schema version %d, the latest is %d", version, latest)
+	}
+	current, err := s.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current > latest {
+		return fmt.Errorf("%w: the database is at version %d, the latest known is %d", ErrSchemaTooNew, current, latest)
+	}
+	for ; current < version; current++ {
+		if err := s.migrate(ctx, current+1, true); err != nil {
+			return err
+		}
+	}
+	for ; current > version; current-- {
+		if err := s.migrate(ctx, current, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrate applies the migration to the version, or reverts it, and records it in schema_version.
+func (s *SQLiteMetrics) migrate(ctx context.Context, version int, up bool) error {
+	m := sqliteMigrations[version-1]
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	step, record, args := m.up, `INSERT INTO schema_version(version, name, applied_at) VALUES(?, ?, ?)`, []any{version, m.name, time.Now().Unix()}
+	if !up {
+		step, record, args = m.down, `DELETE FROM schema_version WHERE version=?`, []any{version}
+	}
+	if err := step(ctx, tx); err != nil {
+		return fmt.Errorf("migration %d (%s): %w", version, m.name, err)
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// PostgresMetrics stores the metrics in a Postgres database. Sums are kept as decimal strings,
+// like in SQLite, so that all 128 bits survive.
+type PostgresMetrics struct{ sqlMetrics }
+
+func NewPostgresMetrics(dsn string) (Repository, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &PostgresMetrics{sqlMetrics{db: &sqlDB{DB: db, dialect: postgresDialect}}}, nil
+}
+
+// Init creates the tables and indexes that do not exist yet. Unlike SQLite, the schema is not versioned
+// and tables created by an older version are not migrated, they have to be recreated or altered by hand.
+func (s *PostgresMetrics) Init(ctx context.Context) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS metrics(
+			id INTEGER PRIMARY KEY CHECK(id=1),
+			last_round BIGINT,
+			count BIGINT,
+			sum TEXT,
+			min BIGINT,
+			max BIGINT,
+			distribution BYTEA,
+			mean DOUBLE PRECISION,
+			m2 DOUBLE PRECISION
+			);`,
+		`INSERT INTO metrics(id, last_round, count, sum, min, max, mean, m2) VALUES(1, 0, 0, '0', 9223372036854775807, 0, 0, 0)
+			ON CONFLICT(id) DO NOTHING;`,
+		`CREATE TABLE IF NOT EXISTS events(
+			sig TEXT PRIMARY KEY,
+			round BIGINT NOT NULL,
+			sender BIGINT NOT NULL,
+			recipient BIGINT NOT NULL,
+			amount BIGINT NOT NULL
+			);`,
+		`CREATE INDEX IF NOT EXISTS idx_events_round ON events(round, sig);`,
+		`CREATE INDEX IF NOT EXISTS idx_events_sender ON events(sender, round);`,
+		`CREATE INDEX IF NOT EXISTS idx_events_recipient ON events(recipient, round);`,
+		`CREATE INDEX IF NOT EXISTS idx_events_amount ON events(amount);`,
+		`CREATE TABLE IF NOT EXISTS round_stats(
+			round BIGINT PRIMARY KEY,
+			hash TEXT NOT NULL,
+			count BIGINT NOT NULL,
+			sum TEXT NOT NULL,
+			min BIGINT,
+			max BIGINT,
+			tx_count BIGINT,
+			ingested_at BIGINT,
+			mean DOUBLE PRECISION,
+			m2 DOUBLE PRECISION
+			);`,
+		`CREATE INDEX IF NOT EXISTS idx_round_stats_ingested_at ON round_stats(ingested_at);`,
+		`CREATE TABLE IF NOT EXISTS metric_snapshots(
+			round BIGINT PRIMARY KEY,
+			count BIGINT NOT NULL,
+			sum TEXT NOT NULL,
+			min BIGINT NOT NULL,
+			max BIGINT NOT NULL,
+			mean DOUBLE PRECISION NOT NULL,
+			m2 DOUBLE PRECISION NOT NULL
+			);`,
+		`CREATE TABLE IF NOT EXISTS round_rollups(
+			period TEXT NOT NULL,
+			start BIGINT NOT NULL,
+			rounds BIGINT NOT NULL,
+			tx_count BIGINT NOT NULL,
+			count BIGINT NOT NULL,
+			volume TEXT NOT NULL,
+			min BIGINT,
+			max BIGINT,
+			PRIMARY KEY(period, start)
+			);`,
+		`CREATE TABLE IF NOT EXISTS metric_scopes(
+			name TEXT PRIMARY KEY,
+			from_round BIGINT NOT NULL,
+			to_round BIGINT NOT NULL,
+			last_round BIGINT NOT NULL,
+			count BIGINT NOT NULL,
+			sum TEXT NOT NULL,
+			min BIGINT NOT NULL,
+			max BIGINT NOT NULL,
+			distribution BYTEA,
+			mean DOUBLE PRECISION,
+			m2 DOUBLE PRECISION
+			);`,
+		`CREATE TABLE IF NOT EXISTS accounts(
+			id BIGINT PRIMARY KEY,
+			sent_count BIGINT NOT NULL,
+			sent_volume TEXT NOT NULL,
+			sent_min BIGINT,
+			sent_max BIGINT,
+			received_count BIGINT NOT NULL,
+			received_volume TEXT NOT NULL,
+			received_min BIGINT,
+			received_max BIGINT,
+			first_round BIGINT NOT NULL,
+			last_round BIGINT NOT NULL
+			);`,
+		`CREATE INDEX IF NOT EXISTS idx_accounts_sent_volume ON accounts((CAST(sent_volume AS NUMERIC)));`,
+		`CREATE INDEX IF NOT EXISTS idx_accounts_received_volume ON accounts((CAST(received_volume AS NUMERIC)));`,
+		`CREATE INDEX IF NOT EXISTS idx_accounts_sent_count ON accounts(sent_count);`,
+		`CREATE INDEX IF NOT EXISTS idx_accounts_received_count ON accounts(received_count);`,
+	}
+	for _, q := range stmts {
+		if _, err := s.db.ExecContext(ctx, q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"context"
+	"os"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// The Postgres specs run against the database at METRIKA_TEST_POSTGRES_DSN, whose metrika tables they drop.
+var _ = Describe("PostgresMetrics", func() {
+	open := func(ctx context.Context) Repository {
+		dsn := os.Getenv("METRIKA_TEST_POSTGRES_DSN")
+		if dsn == "" {
+			Skip("METRIKA_TEST_POSTGRES_DSN is not set")
+		}
+		repo, err := NewPostgresMetrics(dsn)
+		Expect(err).To(BeNil())
+		db := repo.(*PostgresMetrics).db
+		DeferCleanup(db.Close)
+		Expect(db.ExecContext(ctx, `DROP TABLE IF EXISTS metrics, events, round_stats, metric_snapshots, round_rollups, metric_scopes, accounts`)).Error().To(BeNil())
+		Expect(repo.Init(ctx)).To(Succeed())
+		return repo
+	}
+
+	repositoryContract(open)
+})
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+// ErrRoundCommitted is returned by CommitRound when the round is not ahead of the stored checkpoint.
+var ErrRoundCommitted = errors.New("round already committed")
+
+// ErrDuplicateEvent is matched by the DuplicateEventError returned by CommitRound when an event's sig is
+// already stored, since counting an event that is not stored would leave the metrics out of step with the
+// events they are rolled back from.
+var ErrDuplicateEvent = errors.New("event already stored")
+
+// DuplicateEventError names the sig that CommitRound found already stored.
+type DuplicateEventError struct {
+	Sig string
+}
+
+func (e *DuplicateEventError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrDuplicateEvent, e.Sig)
+}
+
+// Is makes the error match ErrDuplicateEvent.
+func (e *DuplicateEventError) Is(target error) bool {
+	return target == ErrDuplicateEvent
+}
+
+// ErrCheckpointMoved is returned by RepairMetrics when rounds were committed since the metrics were read.
+var ErrCheckpointMoved = errors.New("checkpoint moved")
+
+// ErrScopeNotFound is returned by LoadScope when no scope has the given name.
+var ErrScopeNotFound = errors.New("scope not found")
+
+type Repository interface {
+	// SaveMetrics persists the current metrics state.
+	SaveMetrics(ctx context.Context, metrics models.Metrics) error
+	// LoadMetrics retrieves the current metrics state.
+	LoadMetrics(ctx context.Context) (models.Metrics, error)
+	// LoadDistribution retrieves the distribution of the amounts counted in the metrics.
+	LoadDistribution(ctx context.Context) (*models.Distribution, error)
+	// RepairMetrics overwrites the stored count, sum, min, max, mean and M2 with the given ones, provided the
+	// checkpoint is still at m.LastRound. Otherwise it returns ErrCheckpointMoved without writing anything.
+	RepairMetrics(ctx context.Context, m models.Metrics) error
+	// Init creates the database schema, or brings an existing one up to date.
+	Init(ctx context.Context) error
+	// CommitRound atomically stores the round's events, metrics and stats, adds the events to the distribution
+	// and to the accounts of their senders and recipients, adds the stats to the hourly and daily rollups of
+	// the round's ingest time, snapshots the metrics if asked to, and moves the checkpoint to the round.
+	// It returns ErrRoundCommitted, without writing anything, if the checkpoint is already at or past the round,
+	// and a DuplicateEventError if the sig of one of the events is already stored or repeated in the round.
+	CommitRound(ctx context.Context, commit models.RoundCommit) error
+	// RecentRounds returns the stats of the last n committed rounds, oldest first.
+	RecentRounds(ctx context.Context, n int) ([]models.RoundStats, error)
+	// MetricsAt returns the metrics as they were once the round was committed, from the last snapshot
+	// at or before the round and the stats of the rounds since. It returns ErrRoundNotAvailable if the
+	// round was not committed yet or predates the recorded history.
+	MetricsAt(ctx context.Context, round int64) (models.Metrics, error)
+	// QueryRounds returns the stats of the committed rounds matching the filter, ordered by round.
+	QueryRounds(ctx context.Context, filter models.RoundFilter) ([]models.RoundStats, error)
+	// QueryRollups returns up to limit rollups of the period starting in from..to (to exclusive), oldest first.
+	// A limit of 0 means no limit.
+	QueryRollups(ctx context.Context, period models.Period, from, to time.Time, limit int) ([]models.Rollup, error)
+	// RollbackTo removes every round after the given one, subtracting its stats and spread from the
+	// metrics and deleting its events, then moves the checkpoint back to the round. It returns the resulting metrics.
+	// The removed amounts are taken out of the distribution. The accounts of the removed transfers and the
+	// rollups of the removed rounds are updated likewise, and the snapshots of the removed rounds are deleted.
+	RollbackTo(ctx context.Context, round int64) (models.Metrics, error)
+	// SaveEvents persists transfer events, ignoring sigs that are already stored.
+	SaveEvents(ctx context.Context, events []models.Event) error
+	// QueryEvents returns the events matching the filter, ordered by round and sig.
+	QueryEvents(ctx context.Context, filter models.EventFilter) ([]models.Event, error)
+	// LoadAccount returns the aggregates of the account, or ErrAccountNotFound.
+	LoadAccount(ctx context.Context, id int64) (models.Account, error)
+	// TopAccounts returns up to limit accounts ordered by the ranking, highest first.
+	TopAccounts(ctx context.Context, by models.AccountRanking, limit int) ([]models.Account, error)
+	// LoadScope returns the named scope, or ErrScopeNotFound.
+	LoadScope(ctx context.Context, name string) (models.Scope, error)
+	// ResetScope creates the named scope over the rounds from..to, discarding any previous content.
+	ResetScope(ctx context.Context, name string, from, to int64) (models.Scope, error)
+	// CommitScopeRound stores the round's metrics and distribution in the named scope and moves the scope's checkpoint to the round.
+	// It neither touches the live checkpoint nor stores events. It returns ErrRoundCommitted, without writing
+	// anything, if the scope's checkpoint is already at or past the round or the round is outside the scope.
+	CommitScopeRound(ctx context.Context, name string, commit models.RoundCommit) error
+}
+
+// Open connects to the repository the DSN points at: Postgres for postgres:// and postgresql:// URLs,
+// the bolt file at the path for bolt://path, SQLite for anything else.
+func Open(dsn string) (Repository, error) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://"):
+		return NewPostgresMetrics(dsn)
+	case strings.HasPrefix(dsn, "bolt://"):
+		return NewBoltMetrics(strings.TrimPrefix(dsn, "bolt://"))
+	}
+	return NewSQLiteMetrics(dsn)
+}
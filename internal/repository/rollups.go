@@ -0,0 +1,220 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+const roundStatsColumns = `round, hash, COALESCE(tx_count, 0), count, sum, COALESCE(min, 0), COALESCE(max, 0), COALESCE(mean, 0), COALESCE(m2, 0), ingested_at`
+
+const rollupColumns = `period, start, rounds, tx_count, count, volume, COALESCE(min, 0), COALESCE(max, 0)`
+
+func scanRoundStats(row rowScanner) (models.RoundStats, error) {
+	var (
+		st         models.RoundStats
+		ingestedAt sql.NullInt64
+	)
+	if err := row.Scan(&st.Round, &st.Hash, &st.TxCount, &st.Count, &st.Sum, &st.Min, &st.Max, &st.Mean, &st.M2, &ingestedAt); err != nil {
+		return models.RoundStats{}, err
+	}
+	if ingestedAt.Valid {
+		st.IngestedAt = time.UnixMilli(ingestedAt.Int64).UTC()
+	}
+	return st, nil
+}
+
+func scanRollup(row rowScanner) (models.Rollup, error) {
+	var (
+		r     models.Rollup
+		start int64
+	)
+	if err := row.Scan(&r.Period, &start, &r.Rounds, &r.TxCount, &r.Count, &r.Volume, &r.Min, &r.Max); err != nil {
+		return models.Rollup{}, err
+	}
+	r.Start = time.Unix(start, 0).UTC()
+	return r, nil
+}
+
+// insertRoundStats records the round's own stats, then adds them to the rollups of the period it was ingested in.
+func insertRoundStats(ctx context.Context, tx *sqlTx, st models.RoundStats) error {
+	var minAmount, maxAmount, ingestedAt sql.NullInt64
+	if st.Count > 0 {
+		minAmount = sql.NullInt64{Int64: st.Min, Valid: true}
+		maxAmount = sql.NullInt64{Int64: st.Max, Valid: true}
+	}
+	if !st.IngestedAt.IsZero() {
+		ingestedAt = sql.NullInt64{Int64: st.IngestedAt.UnixMilli(), Valid: true}
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO round_stats(round, hash, tx_count, count, sum, min, max, mean, m2, ingested_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		st.Round, st.Hash, st.TxCount, st.Count, st.Sum, minAmount, maxAmount, st.Mean, st.M2, ingestedAt); err != nil {
+		return err
+	}
+	if st.IngestedAt.IsZero() {
+		return nil
+	}
+
+	for _, p := range models.Periods {
+		r, err := scanRollup(tx.QueryRowContext(ctx, `SELECT `+rollupColumns+` FROM round_rollups WHERE period=? AND start=?`, p, p.Start(st.IngestedAt).Unix()))
+		if errors.Is(err, sql.ErrNoRows) {
+			r, err = models.Rollup{Period: p, Start: p.Start(st.IngestedAt)}, nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := r.Add(st); err != nil {
+			return err
+		}
+		if err := saveRollup(ctx, tx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func saveRollup(ctx context.Context, tx *sqlTx, r models.Rollup) error {
+	var minAmount, maxAmount sql.NullInt64
+	if r.Count > 0 {
+		minAmount = sql.NullInt64{Int64: r.Min, Valid: true}
+		maxAmount = sql.NullInt64{Int64: r.Max, Valid: true}
+	}
+	_, err := tx.ExecContext(ctx, upsert("round_rollups", `period, start, rounds, tx_count, count, volume, min, max`, "period,start"),
+		r.Period, r.Start.Unix(), r.Rounds, r.TxCount, r.Count, r.Volume, minAmount, maxAmount)
+	return err
+}
+
+// rollupsAfter returns the rollups holding rounds after the given one.
+func rollupsAfter(ctx context.Context, tx *sqlTx, round int64) ([]models.Rollup, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT ingested_at FROM round_stats WHERE round > ? AND ingested_at IS NOT NULL`, round)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := make(map[models.Rollup]bool)
+	var rollups []models.Rollup
+	for rows.Next() {
+		var ms int64
+		if err := rows.Scan(&ms); err != nil {
+			return nil, err
+		}
+		for _, p := range models.Periods {
+			r := models.Rollup{Period: p, Start: p.Start(time.UnixMilli(ms))}
+			if !seen[r] {
+				seen[r] = true
+				rollups = append(rollups, r)
+			}
+		}
+	}
+	return rollups, rows.Err()
+}
+
+// rebuildRollups recomputes the given rollups from the round stats that remain, deleting the ones left empty.
+// Min and max cannot be subtracted, so the whole period is summed up again.
+func rebuildRollups(ctx context.Context, tx *sqlTx, rollups []models.Rollup) error {
+	for _, r := range rollups {
+		stats, err := queryRounds(ctx, tx, models.RoundFilter{From: &r.Start, To: ptrTo(r.Start.Add(r.Period.Duration()))})
+		if err != nil {
+			return err
+		}
+		if len(stats) == 0 {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM round_rollups WHERE period=? AND start=?`, r.Period, r.Start.Unix()); err != nil {
+				return err
+			}
+			continue
+		}
+		rebuilt := models.Rollup{Period: r.Period, Start: r.Start}
+		for _, st := range stats {
+			if err := rebuilt.Add(st); err != nil {
+				return err
+			}
+		}
+		if err := saveRollup(ctx, tx, rebuilt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ptrTo[T any](v T) *T { return &v }
+
+func queryRounds(ctx context.Context, q dbtx, f models.RoundFilter) ([]models.RoundStats, error) {
+	var (
+		conds []string
+		args  []any
+	)
+	if f.FromRound != nil {
+		conds, args = append(conds, "round >= ?"), append(args, *f.FromRound)
+	}
+	if f.ToRound != nil {
+		conds, args = append(conds, "round <= ?"), append(args, *f.ToRound)
+	}
+	if f.From != nil {
+		conds, args = append(conds, "ingested_at >= ?"), append(args, f.From.UnixMilli())
+	}
+	if f.To != nil {
+		conds, args = append(conds, "ingested_at < ?"), append(args, f.To.UnixMilli())
+	}
+
+	query := `SELECT ` + roundStatsColumns + ` FROM round_stats`
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY round"
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make([]models.RoundStats, 0)
+	for rows.Next() {
+		st, err := scanRoundStats(rows)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
+
+func (s *sqlMetrics) QueryRounds(ctx context.Context, f models.RoundFilter) ([]models.RoundStats, error) {
+	return queryRounds(ctx, s.db, f)
+}
+
+func (s *sqlMetrics) QueryRollups(ctx context.Context, period models.Period, from, to time.Time, limit int) ([]models.Rollup, error) {
+	if _, err := models.ParsePeriod(string(period)); err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + rollupColumns + ` FROM round_rollups WHERE period=? AND start >= ? AND start < ? ORDER BY start`
+	args := []any{period, period.Start(from).Unix(), to.Unix()}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query %s rollups: %w", period, err)
+	}
+	defer rows.Close()
+
+	rollups := make([]models.Rollup, 0)
+	for rows.Next() {
+		r, err := scanRollup(rows)
+		if err != nil {
+			return nil, err
+		}
+		rollups = append(rollups, r)
+	}
+	return rollups, rows.Err()
+}
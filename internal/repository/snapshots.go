@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+// ErrRoundNotAvailable is returned by MetricsAt for a round that was not committed yet or whose history was not recorded.
+var ErrRoundNotAvailable = errors.New("round not available")
+
+func saveSnapshot(ctx context.Context, tx *sqlTx, m models.Metrics) error {
+	_, err := tx.ExecContext(ctx, upsert("metric_snapshots", `round, count, sum, min, max, mean, m2`, "round"),
+		m.LastRound, m.Count, m.Sum, m.Min, m.Max, m.Mean, m.M2)
+	return err
+}
+
+// fillRoundSpreads computes the spread of the rounds stored before it was recorded from their events.
+func fillRoundSpreads(ctx context.Context, tx *sqlTx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT round FROM round_stats WHERE mean IS NULL OR m2 IS NULL`)
+	if err != nil {
+		return err
+	}
+	var rounds []int64
+	for rows.Next() {
+		var round int64
+		if err := rows.Scan(&round); err != nil {
+			rows.Close()
+			return err
+		}
+		rounds = append(rounds, round)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, round := range rounds {
+		spread, err := eventSpread(ctx, tx, `SELECT amount FROM events WHERE round=?`, round)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE round_stats SET mean=?, m2=? WHERE round=?`, spread.Mean, spread.M2, round); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlMetrics) MetricsAt(ctx context.Context, round int64) (models.Metrics, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Metrics{}, err
+	}
+	defer tx.Rollback()
+
+	live := models.NewMetrics()
+	if err := tx.QueryRowContext(ctx, `SELECT count,sum,min,max,mean,COALESCE(m2, 0),last_round FROM metrics WHERE id=1`).
+		Scan(&live.Count, &live.Sum, &live.Min, &live.Max, &live.Mean, &live.M2, &live.LastRound); err != nil {
+		return models.Metrics{}, err
+	}
+	switch {
+	case round < 0 || round > live.LastRound:
+		return models.Metrics{}, fmt.Errorf("%w: round %d is not between 0 and the last committed round %d", ErrRoundNotAvailable, round, live.LastRound)
+	case round == live.LastRound:
+		return live, nil
+	}
+
+	m := models.NewMetrics()
+	err = tx.QueryRowContext(ctx, `SELECT round, count, sum, min, max, mean, m2 FROM metric_snapshots WHERE round <= ? ORDER BY round DESC LIMIT 1`, round).
+		Scan(&m.LastRound, &m.Count, &m.Sum, &m.Min, &m.Max, &m.Mean, &m.M2)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return models.Metrics{}, err
+	}
+
+	from := m.LastRound + 1
+	stats, err := queryRounds(ctx, tx, models.RoundFilter{FromRound: &from, ToRound: &round})
+	if err != nil {
+		return models.Metrics{}, err
+	}
+	// Every round is committed, so a gap means the round predates the stats.
+	if int64(len(stats)) != round-m.LastRound {
+		return models.Metrics{}, fmt.Errorf("%w: the rounds before round %d were committed without stats", ErrRoundNotAvailable, round)
+	}
+	for _, st := range stats {
+		if err := m.AddRound(st); err != nil {
+			return models.Metrics{}, err
+		}
+	}
+	m.LastRound = round
+	return m, nil
+}
@@ -3,21 +3,13 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"strings"
 
-	"github.com/rhuandantas/metrika/internal/models"
 	_ "modernc.org/sqlite"
 )
 
-type Repository interface {
-	// SaveMetrics persists the current metrics state.
-	SaveMetrics(ctx context.Context, metrics models.Metrics) error
-	// LoadMetrics retrieves the current metrics state.
-	LoadMetrics(ctx context.Context) (models.Metrics, error)
-	// Init initializes the database schema if not exists.
-	Init(ctx context.Context) error
-}
-
-type SQLiteMetrics struct{ db *sql.DB }
+// SQLiteMetrics stores the metrics in a SQLite database.
+type SQLiteMetrics struct{ sqlMetrics }
 
 func NewSQLiteMetrics(dsn string) (Repository, error) {
 	db, err := sql.Open("sqlite", dsn)
@@ -33,41 +25,73 @@ func NewSQLiteMetrics(dsn string) (Repository, error) {
 		return nil, err
 	}
 
-	return &SQLiteMetrics{db: db}, nil
+	return &SQLiteMetrics{sqlMetrics{db: &sqlDB{DB: db, dialect: sqliteDialect}}}, nil
 }
 
+// Init migrates the schema to the latest version. It returns ErrSchemaTooNew, without touching
+// anything, if a newer version of metrika migrated the database further.
 func (s *SQLiteMetrics) Init(ctx context.Context) error {
-	// Use WAL journal mode for better concurrency.
-	stmts := []string{
-		`PRAGMA journal_mode=WAL;`,
-		`CREATE TABLE IF NOT EXISTS metrics(
-			id INTEGER PRIMARY KEY CHECK(id=1),
-			last_round INTEGER,
-			count INTEGER,
-			sum INTEGER,
-			min INTEGER,
-			max INTEGER
-			);`,
-		`INSERT OR IGNORE INTO metrics(id, last_round, count, sum, min, max) VALUES(1, 0, 0, 0, 9223372036854775807, 0);`,
+	// Use WAL journal mode for better concurrency. It cannot be changed within the migrations' transactions.
+	if _, err := s.db.ExecContext(ctx, `PRAGMA journal_mode=WAL;`); err != nil {
+		return err
 	}
-	for _, q := range stmts {
-		if _, err := s.db.ExecContext(ctx, q); err != nil {
+	return s.MigrateTo(ctx, len(sqliteMigrations))
+}
+
+// hasTable reports whether the table exists.
+func hasTable(ctx context.Context, tx *sqlTx, table string) (bool, error) {
+	var ok bool
+	err := tx.QueryRowContext(ctx, `SELECT COUNT(*) > 0 FROM sqlite_master WHERE type='table' AND name=?`, table).Scan(&ok)
+	return ok, err
+}
+
+// addColumn adds the column to the table unless it already has it.
+func addColumn(ctx context.Context, tx *sqlTx, table, column, decl string) error {
+	var n int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?`, table, column).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `ALTER TABLE `+table+` ADD COLUMN `+column+` `+decl)
+	return err
+}
+
+// dropColumns drops the columns from the table.
+func dropColumns(ctx context.Context, tx *sqlTx, table string, columns ...string) error {
+	for _, c := range columns {
+		if _, err := tx.ExecContext(ctx, `ALTER TABLE `+table+` DROP COLUMN `+c); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (s *SQLiteMetrics) LoadMetrics(ctx context.Context) (models.Metrics, error) {
-	row := s.db.QueryRowContext(ctx, `SELECT count,sum,min,max,last_round FROM metrics WHERE id=1`)
-	m := models.NewMetrics()
-	if err := row.Scan(&m.Count, &m.Sum, &m.Min, &m.Max, &m.LastRound); err != nil {
-		return models.Metrics{}, err
+// textColumn converts the column to TEXT, moving it to the end of the table, unless it already is.
+func textColumn(ctx context.Context, tx *sqlTx, table, column string) error {
+	var decl string
+	if err := tx.QueryRowContext(ctx, `SELECT type FROM pragma_table_info(?) WHERE name=?`, table, column).Scan(&decl); err != nil {
+		return err
+	}
+	if strings.EqualFold(decl, "TEXT") {
+		return nil
 	}
-	return m, nil
+
+	tmp := column + "_text"
+	return execAll(ctx, tx,
+		`ALTER TABLE `+table+` ADD COLUMN `+tmp+` TEXT`,
+		`UPDATE `+table+` SET `+tmp+`=CAST(`+column+` AS TEXT)`,
+		`ALTER TABLE `+table+` DROP COLUMN `+column,
+		`ALTER TABLE `+table+` RENAME COLUMN `+tmp+` TO `+column,
+	)
 }
 
-func (s *SQLiteMetrics) SaveMetrics(ctx context.Context, m models.Metrics) error {
-	_, err := s.db.ExecContext(ctx, `UPDATE metrics SET count=?, sum=?, min=?, max=?, last_round=? WHERE id=1`, m.Count, m.Sum, m.Min, m.Max, m.LastRound)
-	return err
+func execAll(ctx context.Context, tx *sqlTx, stmts ...string) error {
+	for _, q := range stmts {
+		if _, err := tx.ExecContext(ctx, q); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+func TestRepository(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Repository Suite")
+}
+
+func ptr(v int64) *int64 { return &v }
+
+var _ = Describe("SQLiteMetrics", func() {
+	open := func(ctx context.Context) Repository {
+		repo, err := NewSQLiteMetrics("file:" + filepath.Join(GinkgoT().TempDir(), "metrika.db"))
+		Expect(err).To(BeNil())
+		Expect(repo.Init(ctx)).To(Succeed())
+		return repo
+	}
+
+	Context("as a repository", func() {
+		repositoryContract(open)
+	})
+
+	// The databases of earlier versions are brought up to date by Init.
+	Context("migrating the schema", func() {
+		var (
+			ctx    context.Context
+			repo   Repository
+			sqlite *SQLiteMetrics
+			db     *sqlDB
+		)
+
+		// commit commits a round of transfers, snapshotting the metrics if asked to.
+		commit := func(round int64, snapshot bool, events ...models.Event) {
+			m, err := repo.LoadMetrics(ctx)
+			Expect(err).To(BeNil())
+			c := models.RoundCommit{Round: round, Hash: fmt.Sprintf("h%d", round), Snapshot: snapshot}
+			for i, e := range events {
+				e.Round, e.Sig = round, fmt.Sprintf("%d-%d", round, i)
+				c.Events = append(c.Events, e)
+				Expect(m.Update(e.Amount, round)).To(Succeed())
+			}
+			m.LastRound = round
+			c.Metrics = m
+			Expect(repo.CommitRound(ctx, c)).To(Succeed())
+		}
+		expectMetrics := func(round int64, amounts ...int64) {
+			want := models.NewMetrics()
+			for _, a := range amounts {
+				Expect(want.Update(a, round)).To(Succeed())
+			}
+			m, err := repo.MetricsAt(ctx, round)
+			Expect(err).To(BeNil())
+			Expect(m.Mean).To(BeNumerically("~", want.Mean, 1e-9))
+			Expect(m.M2).To(BeNumerically("~", want.M2, 1e-6))
+			m.Mean, m.M2, want.Mean, want.M2, want.LastRound = 0, 0, 0, 0, round
+			Expect(m).To(Equal(want))
+		}
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			repo = open(ctx)
+			sqlite = repo.(*SQLiteMetrics)
+			db = sqlite.db
+		})
+
+		It("should record the migrations it applied", func() {
+			migrations, version, err := sqlite.Migrations(ctx)
+			Expect(err).To(BeNil())
+			Expect(version).To(Equal(len(sqliteMigrations)))
+			Expect(migrations).To(HaveLen(len(sqliteMigrations)))
+			for i, m := range migrations {
+				Expect(m.Version).To(Equal(i + 1))
+				Expect(m.AppliedAt).NotTo(BeZero())
+			}
+		})
+		It("should migrate all the way down and up again", func() {
+			Expect(sqlite.MigrateTo(ctx, 0)).To(Succeed())
+			migrations, version, err := sqlite.Migrations(ctx)
+			Expect(err).To(BeNil())
+			Expect(version).To(BeZero())
+			Expect(migrations[0].AppliedAt).To(BeZero())
+			var tables []string
+			rows, err := db.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type='table' ORDER BY name`)
+			Expect(err).To(BeNil())
+			for rows.Next() {
+				var name string
+				Expect(rows.Scan(&name)).To(Succeed())
+				tables = append(tables, name)
+			}
+			Expect(rows.Close()).To(Succeed())
+			Expect(tables).To(Equal([]string{"schema_version"}))
+
+			Expect(repo.Init(ctx)).To(Succeed())
+			commit(1, true, models.Event{Sender: 1, Recipient: 2, Amount: 10})
+			m, err := repo.LoadMetrics(ctx)
+			Expect(err).To(BeNil())
+			Expect(m.Count).To(Equal(int64(1)))
+		})
+		It("should refuse a schema newer than it knows", func() {
+			Expect(db.ExecContext(ctx, `INSERT INTO schema_version(version, name, applied_at) VALUES(?, 'future', 0)`, len(sqliteMigrations)+1)).Error().To(BeNil())
+
+			Expect(repo.Init(ctx)).To(MatchError(ErrSchemaTooNew))
+			Expect(sqlite.MigrateTo(ctx, 1)).To(MatchError(ErrSchemaTooNew))
+			Expect(sqlite.MigrateTo(ctx, len(sqliteMigrations)+1)).NotTo(Succeed())
+		})
+		It("should convert the integer sums of a database that predates versioning", func() {
+			Expect(db.ExecContext(ctx, `DELETE FROM schema_version`)).Error().To(BeNil())
+			Expect(db.ExecContext(ctx, `DROP TABLE metrics`)).Error().To(BeNil())
+			Expect(db.ExecContext(ctx, `CREATE TABLE metrics(id INTEGER PRIMARY KEY CHECK(id=1), last_round INTEGER, count INTEGER, sum INTEGER, min INTEGER, max INTEGER)`)).Error().To(BeNil())
+			Expect(db.ExecContext(ctx, `INSERT INTO metrics VALUES(1, 5, 2, 9223372036854775807, 1, 9223372036854775806)`)).Error().To(BeNil())
+			Expect(repo.Init(ctx)).To(Succeed())
+
+			m, err := repo.LoadMetrics(ctx)
+			Expect(err).To(BeNil())
+			Expect(m.Sum).To(Equal(models.NewInt128(math.MaxInt64)))
+			m.Sum, err = m.Sum.Add(models.NewInt128(1))
+			Expect(err).To(BeNil())
+			Expect(repo.SaveMetrics(ctx, m)).To(Succeed())
+			m, err = repo.LoadMetrics(ctx)
+			Expect(err).To(BeNil())
+			Expect(m.Sum.String()).To(Equal("9223372036854775808"))
+		})
+		It("should fill in the distribution and spread of a database that predates them", func() {
+			Expect(repo.SaveEvents(ctx, []models.Event{{Round: 1, Sig: "a", Amount: 10}, {Round: 2, Sig: "b", Amount: 1000}})).To(Succeed())
+			Expect(sqlite.MigrateTo(ctx, 3)).To(Succeed())
+			Expect(db.ExecContext(ctx, `UPDATE metrics SET count=2, sum='1010', min=10, max=1000, last_round=2 WHERE id=1`)).Error().To(BeNil())
+			Expect(repo.Init(ctx)).To(Succeed())
+
+			d, err := repo.LoadDistribution(ctx)
+			Expect(err).To(BeNil())
+			Expect(d.Count()).To(Equal(int64(2)))
+			top, ok := d.Quantile(1)
+			Expect(ok).To(BeTrue())
+			Expect(top).To(BeNumerically("~", 1000, 10))
+
+			m, err := repo.LoadMetrics(ctx)
+			Expect(err).To(BeNil())
+			Expect(m.Mean).To(BeNumerically("~", 505, 1e-9))
+			Expect(m.M2).To(BeNumerically("~", 490050, 1e-6))
+		})
+		It("should start the mean from the average of metrics that predate the events", func() {
+			Expect(repo.SaveEvents(ctx, []models.Event{{Round: 4, Sig: "a", Amount: 10}})).To(Succeed())
+			Expect(sqlite.MigrateTo(ctx, 3)).To(Succeed())
+			Expect(db.ExecContext(ctx, `UPDATE metrics SET count=4, sum='100', min=10, max=40, last_round=4 WHERE id=1`)).Error().To(BeNil())
+			Expect(repo.Init(ctx)).To(Succeed())
+
+			var unknown bool
+			Expect(db.QueryRowContext(ctx, `SELECT m2 IS NULL FROM metrics WHERE id=1`).Scan(&unknown)).To(Succeed())
+			Expect(unknown).To(BeTrue())
+			m, err := repo.LoadMetrics(ctx)
+			Expect(err).To(BeNil())
+			Expect(m.Mean).To(Equal(25.0))
+			Expect(m.M2).To(BeZero())
+
+			// The later amounts are merged in around the average of the earlier ones.
+			commit(5, false, models.Event{Amount: 50})
+			m, err = repo.LoadMetrics(ctx)
+			Expect(err).To(BeNil())
+			Expect(m.Mean).To(Equal(30.0))
+			Expect(m.M2).To(Equal(500.0))
+		})
+		It("should fill in the accounts of a database that predates them", func() {
+			commit(1, false, models.Event{Sender: 1, Recipient: 2, Amount: 10})
+			commit(2, false, models.Event{Sender: 1, Recipient: 2, Amount: 100})
+			Expect(sqlite.MigrateTo(ctx, 4)).To(Succeed())
+			Expect(repo.Init(ctx)).To(Succeed())
+
+			a, err := repo.LoadAccount(ctx, 2)
+			Expect(err).To(BeNil())
+			Expect(a.Received).To(Equal(models.Flow{Count: 2, Volume: models.NewInt128(110), Min: 10, Max: 100}))
+		})
+		It("should fill in the spread of rounds that predate it", func() {
+			commit(1, false, models.Event{Amount: 20}, models.Event{Amount: 30})
+			commit(2, true, models.Event{Amount: 10})
+			commit(3, false, models.Event{Amount: 90}, models.Event{Amount: 5})
+			commit(4, false, models.Event{Amount: 40})
+			Expect(sqlite.MigrateTo(ctx, 6)).To(Succeed())
+			Expect(repo.Init(ctx)).To(Succeed())
+
+			expectMetrics(3, 20, 30, 10, 90, 5)
+		})
+		It("should refuse rounds that predate the round stats", func() {
+			commit(1, false, models.Event{Amount: 20}, models.Event{Amount: 30})
+			commit(2, true, models.Event{Amount: 10})
+			commit(3, false)
+			Expect(db.ExecContext(ctx, `DELETE FROM round_stats WHERE round=1`)).Error().To(BeNil())
+
+			_, err := repo.MetricsAt(ctx, 1)
+			Expect(err).To(MatchError(ErrRoundNotAvailable))
+			expectMetrics(2, 20, 30, 10)
+		})
+	})
+})
@@ -0,0 +1,509 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+// sqlMetrics implements the Repository over the schema that every SQL backend creates in its Init.
+type sqlMetrics struct{ db *sqlDB }
+
+// decodeDistribution reads a stored distribution, NULL meaning nothing was recorded.
+func decodeDistribution(raw []byte) (*models.Distribution, error) {
+	d := models.NewDistribution()
+	if raw == nil {
+		return d, nil
+	}
+	if err := d.UnmarshalBinary(raw); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// addToDistribution adds the events' amounts to the distribution stored by the query's row.
+func addToDistribution(ctx context.Context, tx *sqlTx, events []models.Event, selectQ, updateQ string, args ...any) error {
+	if len(events) == 0 {
+		return nil
+	}
+	var raw []byte
+	if err := tx.QueryRowContext(ctx, selectQ, args...).Scan(&raw); err != nil {
+		return err
+	}
+	d, err := decodeDistribution(raw)
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		d.Add(e.Amount)
+	}
+	if raw, err = d.MarshalBinary(); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, updateQ, append([]any{raw}, args...)...)
+	return err
+}
+
+// rebuildDistribution recomputes the live distribution from every stored event.
+func rebuildDistribution(ctx context.Context, tx *sqlTx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT amount FROM events`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	d := models.NewDistribution()
+	for rows.Next() {
+		var amount int64
+		if err := rows.Scan(&amount); err != nil {
+			return err
+		}
+		d.Add(amount)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	raw, err := d.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE metrics SET distribution=? WHERE id=1`, raw)
+	return err
+}
+
+// removeFromDistribution takes the amounts out of the live distribution.
+func removeFromDistribution(ctx context.Context, tx *sqlTx, amounts []int64) error {
+	if len(amounts) == 0 {
+		return nil
+	}
+	var raw []byte
+	if err := tx.QueryRowContext(ctx, `SELECT distribution FROM metrics WHERE id=1`).Scan(&raw); err != nil {
+		return err
+	}
+	d, err := decodeDistribution(raw)
+	if err != nil {
+		return err
+	}
+	if err := d.Remove(amounts...); err != nil {
+		return err
+	}
+	if raw, err = d.MarshalBinary(); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE metrics SET distribution=? WHERE id=1`, raw)
+	return err
+}
+
+// eventAmounts returns the amounts selected by the query.
+func eventAmounts(ctx context.Context, tx *sqlTx, q string, args ...any) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var amounts []int64
+	for rows.Next() {
+		var amount int64
+		if err := rows.Scan(&amount); err != nil {
+			return nil, err
+		}
+		amounts = append(amounts, amount)
+	}
+	return amounts, rows.Err()
+}
+
+// spreadOf returns the count, mean and M2 of the amounts.
+func spreadOf(amounts []int64) (models.Metrics, error) {
+	m := models.NewMetrics()
+	for _, amount := range amounts {
+		if err := m.Update(amount, 0); err != nil {
+			return models.Metrics{}, err
+		}
+	}
+	return m, nil
+}
+
+// eventSpread returns the count, mean and M2 of the amounts selected by the query.
+func eventSpread(ctx context.Context, tx *sqlTx, q string, args ...any) (models.Metrics, error) {
+	amounts, err := eventAmounts(ctx, tx, q, args...)
+	if err != nil {
+		return models.Metrics{}, err
+	}
+	return spreadOf(amounts)
+}
+
+func (s *sqlMetrics) LoadMetrics(ctx context.Context) (models.Metrics, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT count,sum,min,max,mean,COALESCE(m2, 0),last_round FROM metrics WHERE id=1`)
+	m := models.NewMetrics()
+	if err := row.Scan(&m.Count, &m.Sum, &m.Min, &m.Max, &m.Mean, &m.M2, &m.LastRound); err != nil {
+		return models.Metrics{}, err
+	}
+	return m, nil
+}
+
+func (s *sqlMetrics) LoadDistribution(ctx context.Context) (*models.Distribution, error) {
+	var raw []byte
+	if err := s.db.QueryRowContext(ctx, `SELECT distribution FROM metrics WHERE id=1`).Scan(&raw); err != nil {
+		return nil, err
+	}
+	return decodeDistribution(raw)
+}
+
+func (s *sqlMetrics) SaveMetrics(ctx context.Context, m models.Metrics) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE metrics SET count=?, sum=?, min=?, max=?, mean=?, m2=?, last_round=? WHERE id=1`,
+		m.Count, m.Sum, m.Min, m.Max, m.Mean, m.M2, m.LastRound)
+	return err
+}
+
+func (s *sqlMetrics) RepairMetrics(ctx context.Context, m models.Metrics) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE metrics SET count=?, sum=?, min=?, max=?, mean=?, m2=? WHERE id=1 AND last_round=?`,
+		m.Count, m.Sum, m.Min, m.Max, m.Mean, m.M2, m.LastRound)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrCheckpointMoved
+	}
+	return nil
+}
+
+func (s *sqlMetrics) CommitRound(ctx context.Context, c models.RoundCommit) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	m := c.Metrics
+	res, err := tx.ExecContext(ctx, `UPDATE metrics SET count=?, sum=?, min=?, max=?, mean=?, m2=?, last_round=? WHERE id=1 AND last_round < ?`,
+		m.Count, m.Sum, m.Min, m.Max, m.Mean, m.M2, c.Round, c.Round)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrRoundCommitted
+	}
+
+	if dup, err := insertEvents(ctx, tx, c.Events); err != nil {
+		return err
+	} else if dup != "" {
+		return &DuplicateEventError{Sig: dup}
+	}
+	if err := applyAccounts(ctx, tx, c.Events); err != nil {
+		return err
+	}
+	if err := addToDistribution(ctx, tx, c.Events,
+		`SELECT distribution FROM metrics WHERE id=1`, `UPDATE metrics SET distribution=? WHERE id=1`); err != nil {
+		return err
+	}
+
+	if err := insertRoundStats(ctx, tx, c.Stats()); err != nil {
+		return err
+	}
+	if c.Snapshot {
+		if err := saveSnapshot(ctx, tx, m); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlMetrics) RecentRounds(ctx context.Context, n int) ([]models.RoundStats, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+roundStatsColumns+` FROM
+		(SELECT * FROM round_stats ORDER BY round DESC LIMIT ?) AS recent ORDER BY round`, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make([]models.RoundStats, 0, n)
+	for rows.Next() {
+		st, err := scanRoundStats(rows)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
+
+func (s *sqlMetrics) RollbackTo(ctx context.Context, round int64) (models.Metrics, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Metrics{}, err
+	}
+	defer tx.Rollback()
+
+	m := models.NewMetrics()
+	if err := tx.QueryRowContext(ctx, `SELECT count,sum,min,max,mean,COALESCE(m2, 0) FROM metrics WHERE id=1`).Scan(&m.Count, &m.Sum, &m.Min, &m.Max, &m.Mean, &m.M2); err != nil {
+		return models.Metrics{}, err
+	}
+
+	// Count and sum are subtracted exactly. Min and max only change when a removed round held them,
+	// in which case they are recomputed from the rounds that remain. The amounts of the removed
+	// rounds are taken out of the mean, M2 and distribution from their events, before they are deleted.
+	removedAmounts, err := eventAmounts(ctx, tx, `SELECT amount FROM events WHERE round > ?`, round)
+	if err != nil {
+		return models.Metrics{}, err
+	}
+	removed, err := spreadOf(removedAmounts)
+	if err != nil {
+		return models.Metrics{}, err
+	}
+	m.RemoveSpread(removed.Count, removed.Mean, removed.M2)
+	removedAccounts, err := accountsAfter(ctx, tx, round)
+	if err != nil {
+		return models.Metrics{}, err
+	}
+	removedRollups, err := rollupsAfter(ctx, tx, round)
+	if err != nil {
+		return models.Metrics{}, err
+	}
+	var (
+		removedCount           int64
+		removedMin, removedMax sql.NullInt64
+	)
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(SUM(count), 0), MIN(min), MAX(max) FROM round_stats WHERE round > ?`, round).
+		Scan(&removedCount, &removedMin, &removedMax); err != nil {
+		return models.Metrics{}, err
+	}
+	// SQLite would add the decimal sums up as 64-bit integers, so they are added up here.
+	removedSum, err := sumRoundStats(ctx, tx, round)
+	if err != nil {
+		return models.Metrics{}, err
+	}
+	m.Count -= removedCount
+	if m.Sum, err = m.Sum.Sub(removedSum); err != nil {
+		return models.Metrics{}, err
+	}
+
+	var keptMin, keptMax sql.NullInt64
+	if err := tx.QueryRowContext(ctx, `SELECT MIN(min), MAX(max) FROM round_stats WHERE round <= ?`, round).Scan(&keptMin, &keptMax); err != nil {
+		return models.Metrics{}, err
+	}
+	switch {
+	case m.Count == 0:
+		m.Min, m.Max = models.NewMetrics().Min, 0
+	default:
+		if removedMin.Valid && removedMin.Int64 <= m.Min && keptMin.Valid {
+			m.Min = keptMin.Int64
+		}
+		if removedMax.Valid && removedMax.Int64 >= m.Max && keptMax.Valid {
+			m.Max = keptMax.Int64
+		}
+	}
+	m.LastRound = round
+
+	stmts := []struct {
+		q    string
+		args []any
+	}{
+		{`UPDATE metrics SET count=?, sum=?, min=?, max=?, mean=?, m2=?, last_round=? WHERE id=1`, []any{m.Count, m.Sum, m.Min, m.Max, m.Mean, m.M2, m.LastRound}},
+		{`DELETE FROM events WHERE round > ?`, []any{round}},
+		{`DELETE FROM round_stats WHERE round > ?`, []any{round}},
+		{`DELETE FROM metric_snapshots WHERE round > ?`, []any{round}},
+	}
+	for _, st := range stmts {
+		if _, err := tx.ExecContext(ctx, st.q, st.args...); err != nil {
+			return models.Metrics{}, err
+		}
+	}
+	if err := removeFromDistribution(ctx, tx, removedAmounts); err != nil {
+		return models.Metrics{}, err
+	}
+	if err := rollbackAccounts(ctx, tx, removedAccounts); err != nil {
+		return models.Metrics{}, err
+	}
+	if err := rebuildRollups(ctx, tx, removedRollups); err != nil {
+		return models.Metrics{}, err
+	}
+	return m, tx.Commit()
+}
+
+// sumRoundStats adds up the sums of the rounds after the given one.
+func sumRoundStats(ctx context.Context, tx *sqlTx, round int64) (models.Int128, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT sum FROM round_stats WHERE round > ?`, round)
+	if err != nil {
+		return models.Int128{}, err
+	}
+	defer rows.Close()
+
+	var total models.Int128
+	for rows.Next() {
+		var sum models.Int128
+		if err := rows.Scan(&sum); err != nil {
+			return models.Int128{}, err
+		}
+		if total, err = total.Add(sum); err != nil {
+			return models.Int128{}, err
+		}
+	}
+	return total, rows.Err()
+}
+
+func (s *sqlMetrics) SaveEvents(ctx context.Context, events []models.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := insertEvents(ctx, tx, events); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertEvents stores the events, ignoring sigs that are already stored. It returns the first sig it ignored, if any.
+func insertEvents(ctx context.Context, tx *sqlTx, events []models.Event) (string, error) {
+	if len(events) == 0 {
+		return "", nil
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO events(sig, round, sender, recipient, amount) VALUES(?, ?, ?, ?, ?) ON CONFLICT(sig) DO NOTHING`)
+	if err != nil {
+		return "", err
+	}
+	defer stmt.Close()
+
+	var dup string
+	for _, e := range events {
+		res, err := stmt.ExecContext(ctx, e.Sig, e.Round, e.Sender, e.Recipient, e.Amount)
+		if err != nil {
+			return "", err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return "", err
+		} else if n == 0 && dup == "" {
+			dup = e.Sig
+		}
+	}
+	return dup, nil
+}
+
+func (s *sqlMetrics) QueryEvents(ctx context.Context, f models.EventFilter) ([]models.Event, error) {
+	var (
+		conds []string
+		args  []any
+	)
+	where := func(cond string, vals ...any) {
+		conds = append(conds, cond)
+		args = append(args, vals...)
+	}
+	if f.FromRound != nil {
+		where("round >= ?", *f.FromRound)
+	}
+	if f.ToRound != nil {
+		where("round <= ?", *f.ToRound)
+	}
+	if f.Sender != nil {
+		where("sender = ?", *f.Sender)
+	}
+	if f.Recipient != nil {
+		where("recipient = ?", *f.Recipient)
+	}
+	if f.MinAmount != nil {
+		where("amount >= ?", *f.MinAmount)
+	}
+	if f.MaxAmount != nil {
+		where("amount <= ?", *f.MaxAmount)
+	}
+	if f.After != nil {
+		where("(round > ? OR (round = ? AND sig > ?))", f.After.Round, f.After.Round, f.After.Sig)
+	}
+
+	q := `SELECT sig, round, sender, recipient, amount FROM events`
+	if len(conds) > 0 {
+		q += " WHERE " + strings.Join(conds, " AND ")
+	}
+	q += " ORDER BY round, sig"
+	if f.Limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]models.Event, 0)
+	for rows.Next() {
+		var e models.Event
+		if err := rows.Scan(&e.Sig, &e.Round, &e.Sender, &e.Recipient, &e.Amount); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (s *sqlMetrics) LoadScope(ctx context.Context, name string) (models.Scope, error) {
+	sc := models.Scope{Name: name}
+	m := &sc.Metrics
+	var raw []byte
+	err := s.db.QueryRowContext(ctx, `SELECT from_round, to_round, last_round, count, sum, min, max,
+		COALESCE(mean, CAST(sum AS DOUBLE PRECISION) / NULLIF(count, 0), 0), COALESCE(m2, 0), distribution FROM metric_scopes WHERE name=?`, name).
+		Scan(&sc.FromRound, &sc.ToRound, &m.LastRound, &m.Count, &m.Sum, &m.Min, &m.Max, &m.Mean, &m.M2, &raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Scope{}, ErrScopeNotFound
+	}
+	if err != nil {
+		return models.Scope{}, err
+	}
+	if sc.Distribution, err = decodeDistribution(raw); err != nil {
+		return models.Scope{}, err
+	}
+	return sc, nil
+}
+
+func (s *sqlMetrics) ResetScope(ctx context.Context, name string, from, to int64) (models.Scope, error) {
+	sc := models.NewScope(name, from, to)
+	m := sc.Metrics
+	_, err := s.db.ExecContext(ctx, upsert("metric_scopes", `name, from_round, to_round, last_round, count, sum, min, max, mean, m2, distribution`, "name"),
+		name, from, to, m.LastRound, m.Count, m.Sum, m.Min, m.Max, m.Mean, m.M2, nil)
+	if err != nil {
+		return models.Scope{}, err
+	}
+	return sc, nil
+}
+
+func (s *sqlMetrics) CommitScopeRound(ctx context.Context, name string, c models.RoundCommit) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	m := c.Metrics
+	res, err := tx.ExecContext(ctx, `UPDATE metric_scopes SET count=?, sum=?, min=?, max=?, mean=?, m2=?, last_round=?
+		WHERE name=? AND last_round < ? AND ? BETWEEN from_round AND to_round`,
+		m.Count, m.Sum, m.Min, m.Max, m.Mean, m.M2, c.Round, name, c.Round, c.Round)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrRoundCommitted
+	}
+
+	if err := addToDistribution(ctx, tx, c.Events,
+		`SELECT distribution FROM metric_scopes WHERE name=?`, `UPDATE metric_scopes SET distribution=? WHERE name=?`, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
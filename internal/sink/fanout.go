@@ -0,0 +1,268 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rhuandantas/metrika/internal/models"
+	"github.com/rs/zerolog"
+)
+
+// ErrClosed is returned when publishing to a closed FanOut.
+var ErrClosed = errors.New("event sink closed")
+
+const (
+	// retryBackoff is the wait before retrying a failed batch, doubled on every further retry.
+	retryBackoff = 100 * time.Millisecond
+	// maxRetryBackoff caps the wait between retries.
+	maxRetryBackoff = 30 * time.Second
+)
+
+// Overflow decides what FanOut.Publish does when a target's queue is full.
+type Overflow int
+
+const (
+	// Block waits for room in the queue, slowing ingestion down to the pace of the sink.
+	Block Overflow = iota
+	// Drop discards the batch for that target only and carries on.
+	Drop
+)
+
+// ParseOverflow reads "block" or "drop".
+func ParseOverflow(s string) (Overflow, error) {
+	switch s {
+	case "block":
+		return Block, nil
+	case "drop":
+		return Drop, nil
+	}
+	return 0, fmt.Errorf("unknown overflow policy %q, want block or drop", s)
+}
+
+// Target is a sink fed by a FanOut.
+type Target struct {
+	Name string
+	Sink EventSink
+	// QueueSize is the number of batches buffered for the sink.
+	QueueSize int
+	Overflow  Overflow
+	// Attempts is the number of tries per batch before it is given up, at least 1.
+	Attempts int
+	// Persistent retries a failed batch until the FanOut is closed instead of giving it up after
+	// Attempts, for a sink that must not miss a round. Once closed, it gives up after Attempts.
+	Persistent bool
+}
+
+type job struct {
+	events []models.Event
+	// rollback is set on rollback markers, only handed to the sinks that implement RollbackLogger.
+	rollback  bool
+	lastRound int64
+	// flushed is set on flush markers, the worker answers on it once every earlier batch is handled.
+	flushed chan error
+}
+
+func (j job) String() string {
+	if j.rollback {
+		return fmt.Sprintf("the rollback to round %d", j.lastRound)
+	}
+	return fmt.Sprintf("round %d", j.events[0].Round)
+}
+
+type outlet struct {
+	Target
+	queue   chan job
+	done    chan struct{}
+	dropped atomic.Int64
+	failed  atomic.Int64
+}
+
+// FanOut publishes every batch to several sinks. Each target has its own queue and worker, so a
+// slow or failing sink never holds the others back. A failed batch is retried, then logged and given
+// up, or retried until Close on a persistent target. It never fails the round that produced it. A full queue either blocks Publish or drops the batch
+// for that target, depending on its Overflow. It implements RollbackLogger, forwarding the markers in
+// order with the batches to the targets that implement it.
+type FanOut struct {
+	logger  zerolog.Logger
+	outlets []*outlet
+	mu      sync.RWMutex
+	closed  bool
+	// closing is closed by Close, it stops the persistent retries.
+	closing chan struct{}
+}
+
+func NewFanOut(logger zerolog.Logger, targets ...Target) *FanOut {
+	f := &FanOut{logger: logger, closing: make(chan struct{})}
+	for _, t := range targets {
+		t.Attempts = max(t.Attempts, 1)
+		o := &outlet{Target: t, queue: make(chan job, max(t.QueueSize, 0)), done: make(chan struct{})}
+		f.outlets = append(f.outlets, o)
+		go f.run(o)
+	}
+	return f
+}
+
+// Publish queues the batch on every target. A done ctx queues it nowhere, but a ctx done while waiting
+// for room in a full Block target leaves it queued on the targets before that one only, and returns
+// ctx.Err(). Waiting regardless would hold shutdown up for as long as the sink is stuck.
+func (f *FanOut) Publish(ctx context.Context, events []models.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return f.enqueue(ctx, job{events: events})
+}
+
+func (f *FanOut) RolledBack(ctx context.Context, lastRound int64) error {
+	return f.enqueue(ctx, job{rollback: true, lastRound: lastRound})
+}
+
+// enqueue queues j on every target that handles it.
+func (f *FanOut) enqueue(ctx context.Context, j job) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		return ErrClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, o := range f.outlets {
+		if _, ok := o.Sink.(RollbackLogger); j.rollback && !ok {
+			continue
+		}
+		if o.Overflow == Drop {
+			select {
+			case o.queue <- j:
+			default:
+				n := o.dropped.Add(1)
+				f.logger.Warn().Msgf("Event sink %s is falling behind, dropped %s (%d batches dropped so far)", o.Name, j, n)
+			}
+			continue
+		}
+
+		select {
+		case o.queue <- j:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Flush waits until every target has handled the batches published so far, then flushes it.
+func (f *FanOut) Flush(ctx context.Context) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		return ErrClosed
+	}
+
+	replies := make([]chan error, len(f.outlets))
+	for i, o := range f.outlets {
+		replies[i] = make(chan error, 1)
+		select {
+		case o.queue <- job{flushed: replies[i]}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	var errs []error
+	for i, reply := range replies {
+		select {
+		case err := <-reply:
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", f.outlets[i].Name, err))
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close delivers the queued batches, then closes every sink.
+func (f *FanOut) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	close(f.closing)
+	for _, o := range f.outlets {
+		close(o.queue)
+	}
+	f.mu.Unlock()
+
+	var errs []error
+	for _, o := range f.outlets {
+		<-o.done
+		if err := o.Sink.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", o.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f *FanOut) run(o *outlet) {
+	defer close(o.done)
+	for j := range o.queue {
+		if j.flushed != nil {
+			j.flushed <- o.Sink.Flush(context.Background())
+			continue
+		}
+		if err := f.deliver(o, j); err != nil {
+			n := o.failed.Add(1)
+			f.logger.Error().Msgf("Event sink %s gave up %s after %d attempts (%d batches lost so far): %v", o.Name, j, o.Attempts, n, err)
+		}
+	}
+}
+
+func (f *FanOut) deliver(o *outlet, j job) error {
+	send := func() error { return o.Sink.Publish(context.Background(), j.events) }
+	if j.rollback {
+		send = func() error { return o.Sink.(RollbackLogger).RolledBack(context.Background(), j.lastRound) }
+	}
+	for attempt := 1; ; attempt++ {
+		err := send()
+		if err == nil {
+			return nil
+		}
+		if !o.Persistent {
+			if attempt >= o.Attempts {
+				return err
+			}
+			time.Sleep(backoff(attempt))
+			continue
+		}
+
+		select {
+		case <-f.closing:
+			if attempt >= o.Attempts {
+				return err
+			}
+		default:
+		}
+		if attempt%10 == 0 {
+			f.logger.Warn().Msgf("Event sink %s still failing %s after %d attempts, retrying: %v", o.Name, j, attempt, err)
+		}
+		select {
+		case <-time.After(backoff(attempt)):
+		case <-f.closing:
+		}
+	}
+}
+
+// backoff returns the wait after the given failed attempt.
+func backoff(attempt int) time.Duration {
+	if attempt > 16 {
+		return maxRetryBackoff
+	}
+	return min(retryBackoff<<(attempt-1), maxRetryBackoff)
+}
@@ -0,0 +1,116 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+// EventSink receives the transfer events of every committed round.
+type EventSink interface {
+	// Publish delivers a round's events, in round order across calls.
+	Publish(ctx context.Context, events []models.Event) error
+	// Flush returns once every published batch has been handed over to the destination.
+	Flush(ctx context.Context) error
+	// Close flushes and releases the sink. It must not be used afterwards.
+	Close() error
+}
+
+// RollbackLogger is implemented by the sinks that keep a log of the batches to be replayed, which must
+// record that the rounds after lastRound were rolled back so the replay drops their batches.
+type RollbackLogger interface {
+	RolledBack(ctx context.Context, lastRound int64) error
+}
+
+// Discard drops every event.
+type Discard struct{}
+
+func (Discard) Publish(context.Context, []models.Event) error { return nil }
+func (Discard) Flush(context.Context) error                   { return nil }
+func (Discard) Close() error                                  { return nil }
+
+// Writer writes each batch as a JSON array in the message of a zerolog line, the format of the
+// event log read back by eventlog.Scanner. It implements RollbackLogger.
+type Writer struct {
+	w      io.Writer
+	closer io.Closer
+}
+
+// logLine is a zerolog line holding a message, and the checkpoint left by a rollback on rollback markers.
+type logLine struct {
+	Message      string `json:"message"`
+	RolledBackTo *int64 `json:"rolled_back_to,omitempty"`
+}
+
+// NewWriter writes to w and closes it on Close if it is an io.Closer, such as a lumberjack.Logger.
+func NewWriter(w io.Writer) *Writer {
+	closer, _ := w.(io.Closer)
+	return &Writer{w: w, closer: closer}
+}
+
+// NewStdout writes to the standard output, which is left open on Close.
+func NewStdout() *Writer {
+	return &Writer{w: os.Stdout}
+}
+
+func (s *Writer) Publish(_ context.Context, events []models.Event) error {
+	marshal, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	return s.write(logLine{Message: string(marshal)})
+}
+
+// RolledBack writes a rollback marker. Its message is an empty batch, which readers that do not know
+// about markers skip.
+func (s *Writer) RolledBack(_ context.Context, lastRound int64) error {
+	return s.write(logLine{Message: "[]", RolledBackTo: &lastRound})
+}
+
+func (s *Writer) write(l logLine) error {
+	line, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	// A single write, so that a rotating writer never splits the line.
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+func (s *Writer) Flush(context.Context) error {
+	if syncer, ok := s.w.(interface{ Sync() error }); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
+
+func (s *Writer) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// EventStore persists events, ignoring sigs that are already stored. repository.Repository implements it.
+type EventStore interface {
+	SaveEvents(ctx context.Context, events []models.Event) error
+}
+
+// Store publishes events into an EventStore, typically a repository opened on a separate database.
+type Store struct {
+	store EventStore
+}
+
+func NewStore(store EventStore) *Store {
+	return &Store{store: store}
+}
+
+func (s *Store) Publish(ctx context.Context, events []models.Event) error {
+	return s.store.SaveEvents(ctx, events)
+}
+
+func (s *Store) Flush(context.Context) error { return nil }
+func (s *Store) Close() error                { return nil }
@@ -0,0 +1,268 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rhuandantas/metrika/internal/eventlog"
+	"github.com/rhuandantas/metrika/internal/models"
+	"github.com/rs/zerolog"
+)
+
+func TestSink(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Sink Suite")
+}
+
+// fakeSink records batches. It fails the first failures publishes and waits for gate when it is set.
+type fakeSink struct {
+	mu       sync.Mutex
+	rounds   []int64
+	failures int
+	attempts int
+	gate     chan struct{}
+	flushed  int
+	closed   bool
+}
+
+func (s *fakeSink) Publish(_ context.Context, events []models.Event) error {
+	if s.gate != nil {
+		<-s.gate
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("unavailable")
+	}
+	s.rounds = append(s.rounds, events[0].Round)
+	return nil
+}
+
+func (s *fakeSink) Flush(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushed++
+	return nil
+}
+
+func (s *fakeSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *fakeSink) published() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int64(nil), s.rounds...)
+}
+
+// failingWriter fails every write with err.
+type failingWriter struct {
+	err    error
+	writes atomic.Int32
+}
+
+func (w *failingWriter) Write([]byte) (int, error) {
+	w.writes.Add(1)
+	return 0, w.err
+}
+
+func round(r int64) []models.Event {
+	return []models.Event{{Round: r, Sig: "s", Amount: r}}
+}
+
+var _ = Describe("Writer", func() {
+	It("should write lines the event log scanner reads back", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "events.log")
+		f, err := os.Create(path)
+		Expect(err).To(BeNil())
+		w := NewWriter(f)
+		Expect(w.Publish(context.Background(), round(1))).To(Succeed())
+		Expect(w.Publish(context.Background(), round(2))).To(Succeed())
+		Expect(w.RolledBack(context.Background(), 1)).To(Succeed())
+		Expect(w.Flush(context.Background())).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+
+		sc := eventlog.NewScanner([]string{path})
+		defer sc.Close()
+		for _, want := range []eventlog.Batch{{Events: round(1)}, {Events: round(2)}, {RolledBack: true, LastRound: 1}} {
+			b, err := sc.Next()
+			Expect(err).To(BeNil())
+			Expect(b).To(Equal(want))
+		}
+		_, err = sc.Next()
+		Expect(err).To(MatchError(io.EOF))
+	})
+	It("should fail when the line cannot be written, so that it is retried", func() {
+		full := &failingWriter{err: errors.New("no space left on device")}
+		Expect(NewWriter(full).Publish(context.Background(), round(1))).To(MatchError(full.err))
+
+		f := NewFanOut(zerolog.Nop(), Target{Name: "file", Sink: NewWriter(full), Attempts: 3})
+		Expect(f.Publish(context.Background(), round(2))).To(Succeed())
+		Expect(f.Close()).To(Succeed())
+		Expect(full.writes.Load()).To(Equal(int32(4)))
+	})
+})
+
+var _ = Describe("Webhook", func() {
+	var (
+		status int
+		got    []models.Event
+		srv    *httptest.Server
+	)
+
+	BeforeEach(func() {
+		status = http.StatusNoContent
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(json.NewDecoder(r.Body).Decode(&got)).To(Succeed())
+			w.WriteHeader(status)
+		}))
+		DeferCleanup(srv.Close)
+	})
+
+	It("should post the batch as a JSON array", func() {
+		Expect(NewWebhook(srv.URL, time.Second).Publish(context.Background(), round(7))).To(Succeed())
+		Expect(got).To(Equal(round(7)))
+	})
+	It("should fail on a non-2xx status", func() {
+		status = http.StatusBadGateway
+		Expect(NewWebhook(srv.URL, time.Second).Publish(context.Background(), round(7))).To(MatchError(ContainSubstring("502")))
+	})
+})
+
+var _ = Describe("FanOut", func() {
+	ctx := context.Background()
+
+	It("should deliver every batch to every target in order and flush them", func() {
+		a, b := &fakeSink{}, &fakeSink{}
+		f := NewFanOut(zerolog.Nop(), Target{Name: "a", Sink: a, QueueSize: 4}, Target{Name: "b", Sink: b, QueueSize: 4})
+		for r := int64(1); r <= 3; r++ {
+			Expect(f.Publish(ctx, round(r))).To(Succeed())
+		}
+		Expect(f.Flush(ctx)).To(Succeed())
+		Expect(a.published()).To(Equal([]int64{1, 2, 3}))
+		Expect(b.published()).To(Equal([]int64{1, 2, 3}))
+		Expect(a.flushed).To(Equal(1))
+
+		Expect(f.Close()).To(Succeed())
+		Expect(a.closed && b.closed).To(BeTrue())
+		Expect(f.Publish(ctx, round(4))).To(MatchError(ErrClosed))
+	})
+	It("should retry a failing sink, then give the batch up without holding the others back", func() {
+		failing, healthy := &fakeSink{failures: 3}, &fakeSink{}
+		f := NewFanOut(zerolog.Nop(), Target{Name: "failing", Sink: failing, Attempts: 2}, Target{Name: "healthy", Sink: healthy, QueueSize: 4})
+		Expect(f.Publish(ctx, round(1))).To(Succeed())
+		Expect(f.Publish(ctx, round(2))).To(Succeed())
+		Expect(f.Close()).To(Succeed())
+
+		Expect(healthy.published()).To(Equal([]int64{1, 2}))
+		// Round 1 failed twice and was given up, round 2 failed once then went through.
+		Expect(failing.published()).To(Equal([]int64{2}))
+		Expect(failing.attempts).To(Equal(4))
+	})
+	It("should retry a persistent sink until the batch is delivered", func() {
+		failing := &fakeSink{failures: 4}
+		f := NewFanOut(zerolog.Nop(), Target{Name: "file", Sink: failing, Attempts: 2, Persistent: true})
+		Expect(f.Publish(ctx, round(1))).To(Succeed())
+
+		Eventually(failing.published).WithTimeout(5 * time.Second).Should(Equal([]int64{1}))
+		Expect(f.Close()).To(Succeed())
+		Expect(failing.attempts).To(Equal(5))
+	})
+	It("should give a persistent sink up once closed", func() {
+		failing := &fakeSink{failures: math.MaxInt}
+		f := NewFanOut(zerolog.Nop(), Target{Name: "file", Sink: failing, QueueSize: 1, Attempts: 2, Persistent: true})
+		Expect(f.Publish(ctx, round(1))).To(Succeed())
+		Expect(f.Publish(ctx, round(2))).To(Succeed())
+
+		Expect(f.Close()).To(Succeed())
+		Expect(failing.published()).To(BeEmpty())
+		Expect(failing.attempts).To(BeNumerically("<=", 4))
+	})
+	It("should drop batches for a full target only", func() {
+		slow, fast := &fakeSink{gate: make(chan struct{})}, &fakeSink{}
+		f := NewFanOut(zerolog.Nop(), Target{Name: "slow", Sink: slow, QueueSize: 1, Overflow: Drop}, Target{Name: "fast", Sink: fast, QueueSize: 8})
+		for r := int64(1); r <= 5; r++ {
+			Expect(f.Publish(ctx, round(r))).To(Succeed())
+		}
+		close(slow.gate)
+		Expect(f.Close()).To(Succeed())
+
+		Expect(fast.published()).To(Equal([]int64{1, 2, 3, 4, 5}))
+		// One batch is being delivered and one is queued, the others were dropped.
+		Expect(len(slow.published())).To(BeNumerically("<=", 2))
+		Expect(slow.published()[0]).To(Equal(int64(1)))
+	})
+	It("should forward rollbacks in order to the targets that log them only", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "events.log")
+		file, err := os.Create(path)
+		Expect(err).To(BeNil())
+		other := &fakeSink{}
+		f := NewFanOut(zerolog.Nop(), Target{Name: "file", Sink: NewWriter(file), QueueSize: 4}, Target{Name: "other", Sink: other, QueueSize: 4})
+		Expect(f.Publish(ctx, round(1))).To(Succeed())
+		Expect(f.RolledBack(ctx, 0)).To(Succeed())
+		Expect(f.Publish(ctx, round(1))).To(Succeed())
+		Expect(f.Close()).To(Succeed())
+
+		Expect(other.published()).To(Equal([]int64{1, 1}))
+		sc := eventlog.NewScanner([]string{path})
+		defer sc.Close()
+		for _, want := range []eventlog.Batch{{Events: round(1)}, {RolledBack: true}, {Events: round(1)}} {
+			b, err := sc.Next()
+			Expect(err).To(BeNil())
+			Expect(b).To(Equal(want))
+		}
+	})
+	It("should block on a full target until the context is done", func() {
+		slow := &fakeSink{gate: make(chan struct{})}
+		f := NewFanOut(zerolog.Nop(), Target{Name: "slow", Sink: slow, QueueSize: 1})
+		Expect(f.Publish(ctx, round(1))).To(Succeed())
+
+		timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		var err error
+		for r := int64(2); r <= 3 && err == nil; r++ {
+			err = f.Publish(timeout, round(r))
+		}
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+
+		close(slow.gate)
+		Expect(f.Close()).To(Succeed())
+	})
+	It("should queue nothing once the context is done, and the batch on the targets before a full one otherwise", func() {
+		fast, slow := &fakeSink{}, &fakeSink{gate: make(chan struct{})}
+		f := NewFanOut(zerolog.Nop(), Target{Name: "fast", Sink: fast, QueueSize: 4}, Target{Name: "slow", Sink: slow})
+		// The slow worker takes round 1 and waits on the gate, leaving no room for round 2.
+		Expect(f.Publish(ctx, round(1))).To(Succeed())
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		Expect(f.Publish(canceled, round(2))).To(MatchError(context.Canceled))
+		timeout, cancelTimeout := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancelTimeout()
+		Expect(f.Publish(timeout, round(3))).To(MatchError(context.DeadlineExceeded))
+
+		close(slow.gate)
+		Expect(f.Close()).To(Succeed())
+		Expect(fast.published()).To(Equal([]int64{1, 3}))
+		Expect(slow.published()).To(Equal([]int64{1}))
+	})
+})
@@ -0,0 +1,51 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+// Webhook POSTs each batch as a JSON array of events to a URL. Any status other than 2xx is an error.
+type Webhook struct {
+	url string
+	c   *http.Client
+}
+
+func NewWebhook(url string, timeout time.Duration) *Webhook {
+	return &Webhook{url: url, c: &http.Client{Timeout: timeout}}
+}
+
+func (s *Webhook) Publish(ctx context.Context, events []models.Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("POST %s: status code %d", s.url, resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *Webhook) Flush(context.Context) error { return nil }
+
+func (s *Webhook) Close() error {
+	s.c.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,180 @@
+package smartblox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// ErrCircuitOpen is returned without calling upstream while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("smartblox circuit breaker is open")
+
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState int
+
+const (
+	// StateClosed lets every request through.
+	StateClosed BreakerState = iota
+	// StateOpen rejects every request until the open timeout elapses.
+	StateOpen
+	// StateHalfOpen lets a single probe request through at a time to find out whether upstream is back.
+	StateHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerPolicy controls when a CircuitBreaker opens and closes.
+type BreakerPolicy struct {
+	// FailureThreshold is the number of consecutive transient failures that opens the circuit.
+	FailureThreshold int
+	// OpenTimeout is how long the circuit stays open before a probe is let through.
+	OpenTimeout time.Duration
+	// HalfOpenSuccesses is the number of consecutive successful probes that closes the circuit again.
+	HalfOpenSuccesses int
+}
+
+// Health is a snapshot of a CircuitBreaker.
+type Health struct {
+	State BreakerState
+	// UnavailableSince is when the circuit first opened in the current outage, zero while closed.
+	UnavailableSince time.Time
+	// ConsecutiveFailures counts the transient failures since the last success.
+	ConsecutiveFailures int
+	// LastError is the last transient failure, empty while closed.
+	LastError string
+}
+
+// CircuitBreaker decorates a Client and stops calling upstream once it keeps failing, so a node
+// that is down is probed every OpenTimeout instead of on every request. Only transient errors,
+// as classified by IsTransient, count as failures: a missing round or a bad request still proves
+// the node is answering.
+type CircuitBreaker struct {
+	next   Client
+	policy BreakerPolicy
+	logger zerolog.Logger
+	now    func() time.Time
+
+	mu               sync.Mutex
+	state            BreakerState
+	openedAt         time.Time
+	unavailableSince time.Time
+	failures         int
+	successes        int
+	probing          bool
+	lastErr          error
+}
+
+func NewCircuitBreaker(next Client, policy BreakerPolicy, logger zerolog.Logger) *CircuitBreaker {
+	return &CircuitBreaker{next: next, policy: policy, logger: logger, now: time.Now}
+}
+
+func (b *CircuitBreaker) GetStatus(ctx context.Context) (Status, error) {
+	if err := b.allow(); err != nil {
+		return Status{}, err
+	}
+	s, err := b.next.GetStatus(ctx)
+	b.record(ctx, err)
+	return s, err
+}
+
+func (b *CircuitBreaker) GetBlock(ctx context.Context, round int64) (Block, error) {
+	if err := b.allow(); err != nil {
+		return Block{}, err
+	}
+	blk, err := b.next.GetBlock(ctx, round)
+	b.record(ctx, err)
+	return blk, err
+}
+
+// Health returns the current state of the breaker.
+func (b *CircuitBreaker) Health() Health {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	h := Health{State: b.state, UnavailableSince: b.unavailableSince, ConsecutiveFailures: b.failures}
+	if b.state != StateClosed && b.lastErr != nil {
+		h.LastError = b.lastErr.Error()
+	}
+	// Report the probe as due even if no request has asked for it yet.
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.policy.OpenTimeout {
+		h.State = StateHalfOpen
+	}
+	return h
+}
+
+// allow decides whether a request may go upstream, moving an expired open circuit to half-open.
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen {
+		if b.now().Sub(b.openedAt) < b.policy.OpenTimeout {
+			return ErrCircuitOpen
+		}
+		b.state, b.successes = StateHalfOpen, 0
+		b.logger.Info().Msgf("SmartBlox circuit half-open, probing upstream (unavailable since %s)", b.unavailableSince.Format(time.RFC3339))
+	}
+	if b.state == StateHalfOpen {
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record updates the breaker with the outcome of a request that allow let through with ctx.
+func (b *CircuitBreaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	halfOpen := b.state == StateHalfOpen
+	if halfOpen {
+		b.probing = false
+	}
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// The caller gave up, which says nothing about upstream, unlike a request timing out on its own.
+	case IsTransient(err):
+		b.failures++
+		b.lastErr = err
+		if halfOpen {
+			b.open("probe failed")
+		} else if b.state == StateClosed && b.failures >= b.policy.FailureThreshold {
+			b.open("too many consecutive failures")
+		}
+	default:
+		b.failures = 0
+		if !halfOpen {
+			return
+		}
+		b.successes++
+		if b.successes >= b.policy.HalfOpenSuccesses {
+			b.logger.Info().Msgf("SmartBlox circuit closed, upstream is back after %s", b.now().Sub(b.unavailableSince).Round(time.Second))
+			b.state, b.unavailableSince, b.lastErr = StateClosed, time.Time{}, nil
+		}
+	}
+}
+
+func (b *CircuitBreaker) open(reason string) {
+	b.state, b.openedAt = StateOpen, b.now()
+	if b.unavailableSince.IsZero() {
+		b.unavailableSince = b.openedAt
+	}
+	b.logger.Warn().Msgf("SmartBlox circuit open (%s): upstream unavailable since %s, next probe in %s: %v",
+		reason, b.unavailableSince.Format(time.RFC3339), b.policy.OpenTimeout, b.lastErr)
+}
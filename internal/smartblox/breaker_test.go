@@ -0,0 +1,140 @@
+package smartblox
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+)
+
+// scriptedClient answers GetStatus with the queued errors, then succeeds.
+type scriptedClient struct {
+	errs  []error
+	calls int
+}
+
+func (c *scriptedClient) GetStatus(context.Context) (Status, error) {
+	c.calls++
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return Status{}, err
+	}
+	return Status{LastRound: 1}, nil
+}
+
+func (c *scriptedClient) GetBlock(ctx context.Context, _ int64) (Block, error) {
+	_, err := c.GetStatus(ctx)
+	return Block{}, err
+}
+
+var _ = Describe("CircuitBreaker", func() {
+	var (
+		next *scriptedClient
+		now  time.Time
+		cb   *CircuitBreaker
+		ctx  = context.Background()
+	)
+
+	unavailable := &StatusError{StatusCode: http.StatusServiceUnavailable}
+
+	BeforeEach(func() {
+		next = &scriptedClient{}
+		now = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		cb = NewCircuitBreaker(next, BreakerPolicy{FailureThreshold: 2, OpenTimeout: time.Minute, HalfOpenSuccesses: 2}, zerolog.Nop())
+		cb.now = func() time.Time { return now }
+	})
+
+	It("should open after consecutive transient failures and stop calling upstream", func() {
+		next.errs = []error{unavailable, unavailable}
+		_, _ = cb.GetStatus(ctx)
+		Expect(cb.Health().State).To(Equal(StateClosed))
+		_, _ = cb.GetStatus(ctx)
+
+		h := cb.Health()
+		Expect(h.State).To(Equal(StateOpen))
+		Expect(h.UnavailableSince).To(Equal(now))
+		Expect(h.LastError).To(ContainSubstring("503"))
+
+		_, err := cb.GetBlock(ctx, 1)
+		Expect(err).To(MatchError(ErrCircuitOpen))
+		Expect(next.calls).To(Equal(2))
+	})
+	It("should not count permanent errors and cancellations as failures", func() {
+		next.errs = []error{unavailable, ErrRoundNotFound, unavailable, context.Canceled, errors.New("bad json")}
+		for range 5 {
+			_, _ = cb.GetStatus(ctx)
+		}
+		Expect(cb.Health().State).To(Equal(StateClosed))
+	})
+	It("should count requests timing out as failures", func() {
+		timeout := &url.Error{Op: "Get", URL: "http://node/api/status", Err: context.DeadlineExceeded}
+		next.errs = []error{timeout, timeout}
+		for range 2 {
+			_, _ = cb.GetStatus(ctx)
+		}
+		Expect(cb.Health().State).To(Equal(StateOpen))
+		Expect(cb.Health().LastError).To(ContainSubstring("deadline exceeded"))
+	})
+	It("should not count the failures of a caller that gave up", func() {
+		done, cancel := context.WithCancel(ctx)
+		cancel()
+		next.errs = []error{unavailable, &url.Error{Op: "Get", URL: "http://node/api/status", Err: context.Canceled}}
+		for range 2 {
+			_, _ = cb.GetStatus(done)
+		}
+		h := cb.Health()
+		Expect(h.State).To(Equal(StateClosed))
+		Expect(h.ConsecutiveFailures).To(BeZero())
+	})
+	It("should close after enough successful probes", func() {
+		next.errs = []error{unavailable, unavailable}
+		_, _ = cb.GetStatus(ctx)
+		_, _ = cb.GetStatus(ctx)
+
+		now = now.Add(time.Minute)
+		Expect(cb.Health().State).To(Equal(StateHalfOpen))
+		_, err := cb.GetStatus(ctx)
+		Expect(err).To(BeNil())
+		Expect(cb.Health().State).To(Equal(StateHalfOpen))
+		_, err = cb.GetStatus(ctx)
+		Expect(err).To(BeNil())
+
+		h := cb.Health()
+		Expect(h.State).To(Equal(StateClosed))
+		Expect(h.UnavailableSince.IsZero()).To(BeTrue())
+		Expect(h.LastError).To(BeEmpty())
+	})
+	It("should reopen on a failed probe and keep the start of the outage", func() {
+		start := now
+		next.errs = []error{unavailable, unavailable, unavailable}
+		_, _ = cb.GetStatus(ctx)
+		_, _ = cb.GetStatus(ctx)
+
+		now = now.Add(time.Minute)
+		_, err := cb.GetStatus(ctx)
+		Expect(err).To(MatchError(unavailable))
+
+		h := cb.Health()
+		Expect(h.State).To(Equal(StateOpen))
+		Expect(h.UnavailableSince).To(Equal(start))
+		_, err = cb.GetStatus(ctx)
+		Expect(err).To(MatchError(ErrCircuitOpen))
+	})
+	It("should let a single probe through at a time", func() {
+		next.errs = []error{unavailable, unavailable}
+		_, _ = cb.GetStatus(ctx)
+		_, _ = cb.GetStatus(ctx)
+		now = now.Add(time.Minute)
+
+		Expect(cb.allow()).To(Succeed())
+		Expect(cb.allow()).To(MatchError(ErrCircuitOpen))
+		cb.record(ctx, nil)
+		Expect(cb.allow()).To(Succeed())
+	})
+})
@@ -2,6 +2,8 @@ package smartblox
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -35,6 +37,18 @@ type Block struct {
 	Txs   []TransactionSig `json:"txs"`
 }
 
+// Hash identifies the block's content. The API does not expose a block hash, so it is derived
+// from the round and the ordered transaction signatures; a replaced block yields a different hash.
+func (b Block) Hash() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d", b.Round)
+	for _, tx := range b.Txs {
+		h.Write([]byte{0})
+		h.Write([]byte(tx.Sig))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 // Client defines the interface for a SmartBlox API client.
 type Client interface {
 	// GetStatus fetches the current status from the SmartBlox API.
@@ -52,7 +66,7 @@ type Client interface {
 	//   - round: The block round number to fetch.
 	// Returns:
 	//   - Block: The fetched block data.
-	//   - error: An error if the operation fails.
+	//   - error: An error if the operation fails, matching ErrRoundNotFound if the round does not exist.
 	GetBlock(ctx context.Context, round int64) (Block, error)
 }
 
@@ -60,13 +74,18 @@ type httpClient struct {
 	base             string
 	c                *http.Client
 	mockSmartBloxAPI bool
+	retry            RetryPolicy
 }
 
-func NewHTTPClient(base string, timeout time.Duration, mockSmartBloxAPI bool) Client {
+// NewHTTPClient returns a client for the SmartBlox API at base. Transport errors, server errors and
+// rate limiting are retried according to retry. Errors returned after the last attempt can be
+// classified with IsTransient, and a missing round matches ErrRoundNotFound.
+func NewHTTPClient(base string, timeout time.Duration, mockSmartBloxAPI bool, retry RetryPolicy) Client {
 	return &httpClient{
 		base:             base,
 		c:                &http.Client{Timeout: timeout},
 		mockSmartBloxAPI: mockSmartBloxAPI,
+		retry:            retry,
 	}
 }
 
@@ -75,21 +94,28 @@ func (h *httpClient) GetStatus(ctx context.Context) (Status, error) {
 		return mockGetStatus()
 	}
 
-	url := fmt.Sprintf("%s/api/status", h.base)
-	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	var s Status
+	err := h.retry.do(ctx, func() error {
+		return h.get(ctx, fmt.Sprintf("%s/api/status", h.base), &s)
+	})
+	return s, err
+}
+
+// get fetches url once and decodes the JSON body into out.
+func (h *httpClient) get(ctx context.Context, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
 	resp, err := h.c.Do(req)
 	if err != nil {
-		return Status{}, err
+		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return Status{}, fmt.Errorf("status code %d", resp.StatusCode)
-	}
-	var s Status
-	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
-		return Status{}, err
+		return &StatusError{URL: url, StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
 	}
-	return s, nil
+	return json.NewDecoder(resp.Body).Decode(out)
 }
 
 // mockGetStatus simulates fetching status from the SmartBlox API.
@@ -113,21 +139,11 @@ func (h *httpClient) GetBlock(ctx context.Context, round int64) (Block, error) {
 		return mockGetBlock(round)
 	}
 
-	url := fmt.Sprintf("%s/api/blocks/%d", h.base, round)
-	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	resp, err := h.c.Do(req)
-	if err != nil {
-		return Block{}, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return Block{}, fmt.Errorf("status code %d", resp.StatusCode)
-	}
 	var b Block
-	if err := json.NewDecoder(resp.Body).Decode(&b); err != nil {
-		return Block{}, err
-	}
-	return b, nil
+	err := h.retry.do(ctx, func() error {
+		return h.get(ctx, fmt.Sprintf("%s/api/blocks/%d", h.base, round), &b)
+	})
+	return b, err
 }
 
 // mockGetBlock simulates fetching a block by round from the SmartBlox API.
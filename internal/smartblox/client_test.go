@@ -0,0 +1,149 @@
+package smartblox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestSmartBlox(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "SmartBlox Suite")
+}
+
+var _ = Describe("httpClient", func() {
+	var (
+		calls    atomic.Int32
+		statuses []int
+		headers  http.Header
+		srv      *httptest.Server
+		cli      Client
+	)
+
+	BeforeEach(func() {
+		calls.Store(0)
+		statuses = nil
+		headers = http.Header{}
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := int(calls.Add(1))
+			if n <= len(statuses) {
+				for k, v := range headers {
+					w.Header()[k] = v
+				}
+				w.WriteHeader(statuses[n-1])
+				return
+			}
+			if r.URL.Path == "/api/status" {
+				fmt.Fprint(w, `{"last-round": 42}`)
+				return
+			}
+			fmt.Fprint(w, `{"round": 7, "txs": [{"sig": "s", "tx": {"amount": 5, "sender": 1, "type": "txfer", "receipient": 2}}]}`)
+		}))
+		DeferCleanup(srv.Close)
+		cli = NewHTTPClient(srv.URL, time.Second, false, RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond})
+	})
+
+	It("should decode the status and blocks", func() {
+		s, err := cli.GetStatus(context.Background())
+		Expect(err).To(BeNil())
+		Expect(s.LastRound).To(Equal(int64(42)))
+
+		b, err := cli.GetBlock(context.Background(), 7)
+		Expect(err).To(BeNil())
+		Expect(b.Txs).To(HaveLen(1))
+		Expect(b.Txs[0].Tx.Receipient).To(Equal(int64(2)))
+	})
+	It("should retry server errors and rate limiting", func() {
+		statuses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+		s, err := cli.GetStatus(context.Background())
+		Expect(err).To(BeNil())
+		Expect(s.LastRound).To(Equal(int64(42)))
+		Expect(calls.Load()).To(Equal(int32(3)))
+	})
+	It("should give up after the last attempt with a transient error", func() {
+		statuses = []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}
+		_, err := cli.GetBlock(context.Background(), 7)
+		var statusErr *StatusError
+		Expect(errors.As(err, &statusErr)).To(BeTrue())
+		Expect(statusErr.StatusCode).To(Equal(http.StatusBadGateway))
+		Expect(IsTransient(err)).To(BeTrue())
+		Expect(calls.Load()).To(Equal(int32(3)))
+	})
+	It("should not retry a missing round", func() {
+		statuses = []int{http.StatusNotFound}
+		_, err := cli.GetBlock(context.Background(), 8)
+		Expect(err).To(MatchError(ErrRoundNotFound))
+		Expect(IsTransient(err)).To(BeFalse())
+		Expect(calls.Load()).To(Equal(int32(1)))
+	})
+	It("should wait for Retry-After, capped by the max backoff", func() {
+		statuses = []int{http.StatusTooManyRequests}
+		headers.Set("Retry-After", "1")
+		start := time.Now()
+		_, err := cli.GetStatus(context.Background())
+		Expect(err).To(BeNil())
+		Expect(time.Since(start)).To(BeNumerically(">=", 5*time.Millisecond))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+	It("should retry requests that time out", func() {
+		// The node hangs on the first hangs requests, past the client timeout.
+		var hangs, served atomic.Int32
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if served.Add(1) <= hangs.Load() {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(time.Second):
+				}
+				return
+			}
+			fmt.Fprint(w, `{"last-round": 42}`)
+		}))
+		DeferCleanup(slow.Close)
+		cli = NewHTTPClient(slow.URL, 20*time.Millisecond, false, RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond})
+
+		hangs.Store(2)
+		s, err := cli.GetStatus(context.Background())
+		Expect(err).To(BeNil())
+		Expect(s.LastRound).To(Equal(int64(42)))
+		Expect(served.Load()).To(Equal(int32(3)))
+
+		served.Store(0)
+		hangs.Store(3)
+		_, err = cli.GetStatus(context.Background())
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+		Expect(IsTransient(err)).To(BeTrue())
+		Expect(served.Load()).To(Equal(int32(3)))
+	})
+	It("should stop retrying when the context is canceled", func() {
+		statuses = []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError}
+		cli = NewHTTPClient(srv.URL, time.Second, false, RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Hour, MaxBackoff: time.Hour})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := cli.GetStatus(ctx)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+		Expect(calls.Load()).To(Equal(int32(1)))
+	})
+})
+
+var _ = Describe("RetryPolicy", func() {
+	It("should back off exponentially up to the max", func() {
+		p := RetryPolicy{BaseBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+		Expect(p.backoff(1, 0)).To(Equal(100 * time.Millisecond))
+		Expect(p.backoff(3, 0)).To(Equal(400 * time.Millisecond))
+		Expect(p.backoff(10, 0)).To(Equal(time.Second))
+	})
+	It("should keep jittered backoffs within range", func() {
+		p := RetryPolicy{BaseBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Jitter: 0.5}
+		for range 20 {
+			Expect(p.backoff(1, 0)).To(BeNumerically("~", 75*time.Millisecond, 25*time.Millisecond))
+		}
+	})
+})
@@ -0,0 +1,121 @@
+package smartblox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrRoundNotFound is returned by GetBlock when the node does not serve the round (yet).
+var ErrRoundNotFound = errors.New("round not found")
+
+// StatusError is returned when the API answers with an unexpected HTTP status.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	// RetryAfter is the delay requested by the server through the Retry-After header, if any.
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("GET %s: status code %d", e.URL, e.StatusCode)
+}
+
+// Temporary reports whether the status is worth retrying: server errors and rate limiting.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+}
+
+// Is makes a 404 match ErrRoundNotFound.
+func (e *StatusError) Is(target error) bool {
+	return target == ErrRoundNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// IsTransient reports whether err may go away on its own: network failures, timeouts,
+// server errors and rate limiting. Cancellation and other statuses are permanent. A request
+// timing out wraps context.DeadlineExceeded like the caller's own deadline does, so whether
+// the caller gave up is told by its context, not by err.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) {
+		return false
+	}
+	return netErr.Timeout() || !errors.Is(err, context.Canceled)
+}
+
+// RetryPolicy controls how failed requests are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of tries, 1 disables retries.
+	MaxAttempts int
+	// BaseBackoff is the wait before the first retry, doubled on every further retry up to MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// Jitter is the fraction of each backoff, between 0 and 1, that is randomized to spread out retries.
+	Jitter float64
+}
+
+// backoff returns the wait before the given retry, 1 being the first one.
+func (p RetryPolicy) backoff(retry int, retryAfter time.Duration) time.Duration {
+	d := p.BaseBackoff << (retry - 1)
+	if d > p.MaxBackoff || d <= 0 {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+	// Honor the server's request, but never stall a polling pass for longer than MaxBackoff.
+	if retryAfter > d {
+		d = min(retryAfter, p.MaxBackoff)
+	}
+	return d
+}
+
+// do runs fn until it succeeds, fails permanently, runs out of attempts or ctx is done, waiting between tries.
+func (p RetryPolicy) do(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || ctx.Err() != nil || !IsTransient(err) || attempt >= p.MaxAttempts {
+			return err
+		}
+
+		var retryAfter time.Duration
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			retryAfter = statusErr.RetryAfter
+		}
+
+		timer := time.NewTimer(p.backoff(attempt, retryAfter))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
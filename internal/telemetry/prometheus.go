@@ -0,0 +1,184 @@
+package telemetry
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+const namespace = "metrika"
+
+// scrapeTimeout bounds how long a scrape may wait on the metrics source.
+const scrapeTimeout = 5 * time.Second
+
+// Source provides the live ingestion state exported as gauges.
+type Source interface {
+	CurrentMetrics(ctx context.Context) (models.Metrics, error)
+	HeadRound() int64
+}
+
+// Recorder exports the ingestor's operational counters and histograms.
+// It implements ingest.Recorder.
+type Recorder struct {
+	upstreamErrors  *prometheus.CounterVec
+	persistFailures prometheus.Counter
+	roundDuration   prometheus.Histogram
+	passDuration    prometheus.Histogram
+	reorgs          prometheus.Counter
+	rolledBack      prometheus.Counter
+	invalidAmounts  *prometheus.CounterVec
+	duplicates      prometheus.Counter
+}
+
+func NewRecorder(reg prometheus.Registerer) *Recorder {
+	r := &Recorder{
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "upstream_errors_total",
+			Help:      "Failed SmartBlox API calls by operation.",
+		}, []string{"operation"}),
+		persistFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "persist_failures_total",
+			Help:      "Failed writes to the metrics repository.",
+		}),
+		roundDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "round_processing_seconds",
+			Help:      "Time spent fetching and applying a single round.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		passDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "poll_pass_seconds",
+			Help:      "Time spent on a whole polling pass, from status to the last applied round.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 8),
+		}),
+		reorgs: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reorgs_total",
+			Help:      "Chain reorganizations detected.",
+		}),
+		rolledBack: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rolled_back_rounds_total",
+			Help:      "Committed rounds rolled back because upstream replaced them.",
+		}),
+		invalidAmounts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "invalid_amounts_total",
+			Help:      "Transfers with a negative or too large amount, by action taken.",
+		}, []string{"action"}),
+		duplicates: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "duplicate_transfers_total",
+			Help:      "Transfers left out because their sig was already counted.",
+		}),
+	}
+	// Expose both operations from the first scrape instead of only after the first failure.
+	r.upstreamErrors.WithLabelValues("get_status")
+	r.upstreamErrors.WithLabelValues("get_block")
+	r.invalidAmounts.WithLabelValues("rejected")
+	r.invalidAmounts.WithLabelValues("flagged")
+
+	reg.MustRegister(r.upstreamErrors, r.persistFailures, r.roundDuration, r.passDuration, r.reorgs, r.rolledBack, r.invalidAmounts, r.duplicates)
+	return r
+}
+
+func (r *Recorder) UpstreamError(op string) {
+	r.upstreamErrors.WithLabelValues(op).Inc()
+}
+
+func (r *Recorder) PersistFailure() {
+	r.persistFailures.Inc()
+}
+
+func (r *Recorder) RoundProcessed(elapsed time.Duration) {
+	r.roundDuration.Observe(elapsed.Seconds())
+}
+
+func (r *Recorder) PassCompleted(elapsed time.Duration) {
+	r.passDuration.Observe(elapsed.Seconds())
+}
+
+func (r *Recorder) InvalidAmount(action string) {
+	r.invalidAmounts.WithLabelValues(action).Inc()
+}
+
+func (r *Recorder) DuplicateTransfer() {
+	r.duplicates.Inc()
+}
+
+func (r *Recorder) ReorgDetected(rounds int64) {
+	r.reorgs.Inc()
+	r.rolledBack.Add(float64(rounds))
+}
+
+// Collector exports the transfer aggregates and ingestion progress, read from the source on every scrape.
+type Collector struct {
+	src Source
+
+	count     *prometheus.Desc
+	sum       *prometheus.Desc
+	min       *prometheus.Desc
+	max       *prometheus.Desc
+	average   *prometheus.Desc
+	variance  *prometheus.Desc
+	stddev    *prometheus.Desc
+	lastRound *prometheus.Desc
+	headRound *prometheus.Desc
+	lag       *prometheus.Desc
+}
+
+func NewCollector(src Source) *Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, nil, nil)
+	}
+	return &Collector{
+		src:       src,
+		count:     desc("transfer_count", "Number of transfers ingested."),
+		sum:       desc("transfer_amount_sum", "Sum of all transfer amounts."),
+		min:       desc("transfer_amount_min", "Smallest transfer amount, absent until the first transfer."),
+		max:       desc("transfer_amount_max", "Largest transfer amount, absent until the first transfer."),
+		average:   desc("transfer_amount_average", "Average transfer amount."),
+		variance:  desc("transfer_amount_variance", "Population variance of the transfer amounts."),
+		stddev:    desc("transfer_amount_stddev", "Population standard deviation of the transfer amounts."),
+		lastRound: desc("last_processed_round", "Last round applied to the metrics."),
+		headRound: desc("upstream_head_round", "Last round reported by the SmartBlox node."),
+		lag:       desc("round_lag", "Rounds between the upstream head and the last processed round."),
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{c.count, c.sum, c.min, c.max, c.average, c.variance, c.stddev, c.lastRound, c.headRound, c.lag} {
+		ch <- d
+	}
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+	defer cancel()
+
+	m, err := c.src.CurrentMetrics(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.count, err)
+		return
+	}
+	head := c.src.HeadRound()
+
+	// Count and sum shrink when a reorganization is rolled back, so they are gauges rather than counters.
+	ch <- prometheus.MustNewConstMetric(c.count, prometheus.GaugeValue, float64(m.Count))
+	ch <- prometheus.MustNewConstMetric(c.sum, prometheus.GaugeValue, m.Sum.Float64())
+	if m.Count > 0 {
+		ch <- prometheus.MustNewConstMetric(c.min, prometheus.GaugeValue, float64(m.Min))
+		ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, float64(m.Max))
+	}
+	ch <- prometheus.MustNewConstMetric(c.average, prometheus.GaugeValue, m.Average())
+	ch <- prometheus.MustNewConstMetric(c.variance, prometheus.GaugeValue, m.Variance())
+	ch <- prometheus.MustNewConstMetric(c.stddev, prometheus.GaugeValue, m.StdDev())
+	ch <- prometheus.MustNewConstMetric(c.lastRound, prometheus.GaugeValue, float64(m.LastRound))
+	ch <- prometheus.MustNewConstMetric(c.headRound, prometheus.GaugeValue, float64(head))
+	ch <- prometheus.MustNewConstMetric(c.lag, prometheus.GaugeValue, float64(max(head-m.LastRound, 0)))
+}
@@ -0,0 +1,77 @@
+package telemetry
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rhuandantas/metrika/internal/models"
+)
+
+func TestTelemetry(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Telemetry Suite")
+}
+
+type fakeSource struct {
+	metrics models.Metrics
+	head    int64
+}
+
+func (f *fakeSource) CurrentMetrics(context.Context) (models.Metrics, error) { return f.metrics, nil }
+func (f *fakeSource) HeadRound() int64                                       { return f.head }
+
+var _ = Describe("Collector", func() {
+	It("should export the transfer aggregates and round lag", func() {
+		src := &fakeSource{metrics: models.NewMetrics(), head: 12}
+		src.metrics.Update(10, 9)
+		src.metrics.Update(30, 10)
+
+		expected := `
+# HELP metrika_round_lag Rounds between the upstream head and the last processed round.
+# TYPE metrika_round_lag gauge
+metrika_round_lag 2
+# HELP metrika_transfer_amount_average Average transfer amount.
+# TYPE metrika_transfer_amount_average gauge
+metrika_transfer_amount_average 20
+# HELP metrika_transfer_amount_min Smallest transfer amount, absent until the first transfer.
+# TYPE metrika_transfer_amount_min gauge
+metrika_transfer_amount_min 10
+# HELP metrika_transfer_amount_stddev Population standard deviation of the transfer amounts.
+# TYPE metrika_transfer_amount_stddev gauge
+metrika_transfer_amount_stddev 10
+# HELP metrika_transfer_count Number of transfers ingested.
+# TYPE metrika_transfer_count gauge
+metrika_transfer_count 2
+`
+		Expect(testutil.CollectAndCompare(NewCollector(src), strings.NewReader(expected),
+			"metrika_round_lag", "metrika_transfer_amount_average", "metrika_transfer_amount_min",
+			"metrika_transfer_amount_stddev", "metrika_transfer_count")).To(Succeed())
+	})
+	It("should omit min and max before the first transfer", func() {
+		src := &fakeSource{metrics: models.NewMetrics()}
+		Expect(testutil.CollectAndCount(NewCollector(src), "metrika_transfer_amount_min", "metrika_transfer_amount_max")).To(BeZero())
+	})
+})
+
+var _ = Describe("Recorder", func() {
+	It("should count failures and observe durations", func() {
+		r := NewRecorder(prometheus.NewRegistry())
+		r.UpstreamError("get_block")
+		r.UpstreamError("get_block")
+		r.PersistFailure()
+		r.RoundProcessed(20 * time.Millisecond)
+		r.DuplicateTransfer()
+
+		Expect(testutil.ToFloat64(r.upstreamErrors.WithLabelValues("get_block"))).To(Equal(2.0))
+		Expect(testutil.ToFloat64(r.upstreamErrors.WithLabelValues("get_status"))).To(BeZero())
+		Expect(testutil.ToFloat64(r.persistFailures)).To(Equal(1.0))
+		Expect(testutil.ToFloat64(r.duplicates)).To(Equal(1.0))
+		Expect(testutil.CollectAndCount(r.roundDuration)).To(Equal(1))
+	})
+})
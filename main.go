@@ -2,37 +2,75 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/natefinch/lumberjack"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+	"github.com/rhuandantas/metrika/internal/api"
+	"github.com/rhuandantas/metrika/internal/cache"
+	"github.com/rhuandantas/metrika/internal/config"
+	"github.com/rhuandantas/metrika/internal/eventlog"
 	"github.com/rhuandantas/metrika/internal/ingest"
+	"github.com/rhuandantas/metrika/internal/models"
 	"github.com/rhuandantas/metrika/internal/repository"
+	"github.com/rhuandantas/metrika/internal/sink"
 	client "github.com/rhuandantas/metrika/internal/smartblox"
+	"github.com/rhuandantas/metrika/internal/telemetry"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
 func main() {
-	var (
-		// TODO move to env vars or config file
-		baseURL      = "http://localhost:8080"
-		sqLiteDns    = "file:data/db/metrika.db?cache=shared&_journal=WAL&_busy_timeout=5000"
-		poolEvery    = 5 * time.Second
-		persistEvery = 30 * time.Second
-		timeout      = 60 * time.Second
-	)
+	logger := log.Logger.With().Logger()
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "backfill":
+			backfill(logger, os.Args[2:])
+			return
+		case "rebuild":
+			rebuild(logger, os.Args[2:])
+			return
+		case "migrate":
+			migrate(logger, os.Args[2:])
+			return
+		}
+	}
+	serve(logger, os.Args[1:])
+}
 
-	cli := client.NewHTTPClient(baseURL, timeout, true)
+// serve runs the live ingestor and the HTTP API until SIGINT or SIGTERM.
+func serve(logger zerolog.Logger, args []string) {
+	cfg, err := config.Load(flag.CommandLine, args, os.LookupEnv)
+	if err != nil {
+		logger.Fatal().Msgf("Failed to load configuration: %v", err)
+	}
 
-	logger := log.Logger.With().Logger()
+	cli := newSmartBloxClient(cfg.SmartBlox)
+	var apiOpts []api.Option
+	if b := cfg.SmartBlox.Breaker; b.FailureThreshold > 0 {
+		breaker := client.NewCircuitBreaker(cli, client.BreakerPolicy{
+			FailureThreshold:  b.FailureThreshold,
+			OpenTimeout:       b.OpenTimeout,
+			HalfOpenSuccesses: b.HalfOpenSuccesses,
+		}, logger)
+		cli = breaker
+		apiOpts = append(apiOpts, api.WithUpstream(breaker))
+	}
 
 	ctxParent := logger.WithContext(context.Background())
 	ctx, stop := signal.NotifyContext(ctxParent, syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	repo, err := repository.NewSQLiteMetrics(sqLiteDns)
+	repo, err := openRepository(cfg.Database, logger)
 	if err != nil {
 		logger.Fatal().Msgf("Failed to initialize repository: %v", err)
 	}
@@ -41,25 +79,332 @@ func main() {
 		logger.Fatal().Msgf("Failed to initialize database schema: %v", err)
 	}
 
-	ing := ingest.New(cli, poolEvery, persistEvery, logger, setupEventLogger(), repo)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	events, err := setupEventSink(ctx, cfg, logger)
+	if err != nil {
+		logger.Fatal().Msgf("Failed to initialize event sinks: %v", err)
+	}
+
+	metricsCache, closeCache, err := newCache(cfg.Cache)
+	if err != nil {
+		logger.Fatal().Msgf("Failed to initialize metrics cache: %v", err)
+	}
+	defer closeCache()
+
+	feed := api.NewFeed(cfg.HTTP.StreamBuffer)
+	apiOpts = append(apiOpts, api.WithFeed(feed))
 
+	ing := ingest.New(cli, cfg.Ingest.PollEvery, logger, events, repo,
+		ingest.WithRecorder(telemetry.NewRecorder(reg)),
+		ingest.WithCache(metricsCache),
+		ingest.WithNotifier(feed),
+		ingest.WithConcurrency(cfg.Ingest.Concurrency),
+		ingest.WithReorgDepth(cfg.Ingest.ReorgDepth),
+		ingest.WithSnapshotInterval(cfg.Ingest.SnapshotInterval),
+		windows(cfg.Ingest),
+		amountValidation(cfg.Ingest))
+	reg.MustRegister(telemetry.NewCollector(ing))
+
+	srv := api.New(cfg.HTTP.Addr, ing, repo, logger, apiOpts...)
+	srv.Handle("GET /metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+
+	ingDone := make(chan struct{})
 	go func() {
-		if err := ing.Run(ctx); err != nil {
+		defer close(ingDone)
+		if err := ing.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
 			log.Fatal().Msgf("Server error: %v", err)
 		}
 		stop()
 	}()
 
+	go func() {
+		if err := srv.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			log.Fatal().Msgf("HTTP API error: %v", err)
+		}
+	}()
+
 	<-ctx.Done()
 	log.Info().Msgf("Shutting down server gracefully...")
+	// Deliver the events still queued once the last round is committed.
+	<-ingDone
+	if err := events.Close(); err != nil {
+		logger.Error().Msgf("Error closing event sinks: %v", err)
+	}
 }
 
-func setupEventLogger() zerolog.Logger {
-	logFile := &lumberjack.Logger{
-		Filename: "./data/events.log",
-		MaxAge:   30,
-		Compress: true,
+// openRepository opens the repository the service stores its metrics in, kept in memory in ephemeral mode.
+func openRepository(cfg config.Database, logger zerolog.Logger) (repository.Repository, error) {
+	if cfg.Ephemeral {
+		logger.Warn().Msg("Running in ephemeral mode, the metrics are kept in memory and lost on exit")
+		return repository.NewMemoryMetrics(), nil
 	}
+	return repository.Open(cfg.DSN)
+}
 
-	return zerolog.New(logFile).With().Logger()
+// backfill re-ingests a round range into a named metrics scope, leaving the live checkpoint alone.
+func backfill(logger zerolog.Logger, args []string) {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	from := fs.Int64("from", 0, "first round to backfill")
+	to := fs.Int64("to", 0, "last round to backfill")
+	scope := fs.String("scope", "backfill", "metrics scope the rounds are committed to")
+	reset := fs.Bool("reset", false, "discard the scope's previous content instead of resuming it")
+	cfg, err := config.Load(fs, args, os.LookupEnv)
+	if err != nil {
+		logger.Fatal().Msgf("Failed to load configuration: %v", err)
+	}
+	if *from < 1 || *to < *from {
+		logger.Fatal().Msgf("Invalid round range: -from must be at least 1 and -to at least -from, got %d and %d", *from, *to)
+	}
+
+	ctx, stop := signal.NotifyContext(logger.WithContext(context.Background()), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	repo, err := repository.Open(cfg.Database.DSN)
+	if err != nil {
+		logger.Fatal().Msgf("Failed to initialize repository: %v", err)
+	}
+	if err = repo.Init(ctx); err != nil {
+		logger.Fatal().Msgf("Failed to initialize database schema: %v", err)
+	}
+
+	sc, err := repo.LoadScope(ctx, *scope)
+	switch {
+	case errors.Is(err, repository.ErrScopeNotFound) || (err == nil && *reset):
+		sc, err = repo.ResetScope(ctx, *scope, *from, *to)
+	case err == nil && (sc.FromRound != *from || sc.ToRound != *to):
+		logger.Fatal().Msgf("Scope %q covers rounds %d to %d, pass -reset to replace it", *scope, sc.FromRound, sc.ToRound)
+	}
+	if err != nil {
+		logger.Fatal().Msgf("Failed to prepare scope %q: %v", *scope, err)
+	}
+
+	ing := ingest.New(newSmartBloxClient(cfg.SmartBlox), cfg.Ingest.PollEvery, logger, sink.Discard{}, repo,
+		ingest.WithConcurrency(cfg.Ingest.Concurrency),
+		amountValidation(cfg.Ingest))
+	sc, err = ing.Backfill(ctx, sc)
+	if err != nil {
+		logger.Fatal().Msgf("Backfill stopped after round %d, run it again to resume: %v", sc.Metrics.LastRound, err)
+	}
+	m := sc.Metrics
+	logger.Info().Msgf("Backfill %q done: rounds %d to %d, count %d, sum %s, average %.2f", sc.Name, sc.FromRound, sc.ToRound, m.Count, m.Sum, m.Average())
+}
+
+// rebuild recomputes the metrics from the event log and its rotations, reports how they differ from
+// the stored row and, with -apply, overwrites it. The service must be stopped before applying, since
+// a running ingestor would write its cached totals back with the next round.
+func rebuild(logger zerolog.Logger, args []string) {
+	fs := flag.NewFlagSet("rebuild", flag.ExitOnError)
+	apply := fs.Bool("apply", false, "overwrite the stored metrics with the rebuilt ones")
+	cfg, err := config.Load(fs, args, os.LookupEnv)
+	if err != nil {
+		logger.Fatal().Msgf("Failed to load configuration: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(logger.WithContext(context.Background()), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	repo, err := repository.Open(cfg.Database.DSN)
+	if err != nil {
+		logger.Fatal().Msgf("Failed to initialize repository: %v", err)
+	}
+	if err = repo.Init(ctx); err != nil {
+		logger.Fatal().Msgf("Failed to initialize database schema: %v", err)
+	}
+	stored, err := repo.LoadMetrics(ctx)
+	if err != nil {
+		logger.Fatal().Msgf("Failed to load metrics: %v", err)
+	}
+
+	files, err := eventlog.Files(cfg.EventLog.Path)
+	if err != nil {
+		logger.Fatal().Msgf("Failed to list event logs: %v", err)
+	}
+	if len(files) == 0 {
+		logger.Fatal().Msgf("No event log found at %s", cfg.EventLog.Path)
+	}
+	logger.Info().Msgf("Replaying %d event log files up to round %d", len(files), stored.LastRound)
+
+	sc := eventlog.NewScanner(files)
+	res, err := eventlog.Rebuild(sc, stored.LastRound, cfg.Ingest.ReorgDepth)
+	sc.Close()
+	if err != nil {
+		logger.Fatal().Msgf("Failed to replay the event log: %v", err)
+	}
+	for _, m := range sc.Malformed() {
+		logger.Warn().Msgf("Skipped malformed line %s", m)
+	}
+	logger.Info().Msgf("Replayed %d rounds, %d batches superseded by reorganizations, %d stale, %d beyond the checkpoint",
+		res.Rounds, res.Superseded, res.Stale, res.Beyond)
+
+	rebuilt := res.Metrics
+	rebuilt.LastRound = stored.LastRound
+	diffs := eventlog.Compare(stored, rebuilt)
+	if len(diffs) == 0 {
+		logger.Info().Msgf("Stored metrics match the event log: count %d, sum %s", stored.Count, stored.Sum)
+		return
+	}
+	for _, d := range diffs {
+		logger.Warn().Msgf("Discrepancy in %s", d)
+	}
+	if !*apply {
+		logger.Warn().Msg("Stored metrics differ from the event log, run again with -apply to overwrite them")
+		stop()
+		os.Exit(1)
+	}
+
+	if err := repo.RepairMetrics(ctx, rebuilt); err != nil {
+		logger.Fatal().Msgf("Failed to overwrite the stored metrics, is the service still running? %v", err)
+	}
+	logger.Info().Msgf("Stored metrics overwritten: count %d, sum %s, min %d, max %d", rebuilt.Count, rebuilt.Sum, rebuilt.Min, rebuilt.Max)
+
+	// Processes reading a shared cache would serve the old metrics until they expire.
+	metricsCache, closeCache, err := newCache(cfg.Cache)
+	if err == nil {
+		defer closeCache()
+		err = metricsCache.Invalidate(ctx)
+	}
+	if err != nil {
+		logger.Warn().Msgf("Failed to invalidate the metrics cache, it serves the old metrics until they expire: %v", err)
+	}
+}
+
+// newCache returns the live metrics cache, shared through Redis when a URL is configured, and a function
+// releasing it.
+func newCache(cfg config.Cache) (cache.Cache, func(), error) {
+	if cfg.RedisURL == "" {
+		return cache.NewMemory(cfg.TTL), func() {}, nil
+	}
+	opts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	client := redis.NewClient(opts)
+	return cache.NewRedis(client, cfg.Key, cfg.TTL), func() { _ = client.Close() }, nil
+}
+
+// migrate reports the schema version of the database (status) or migrates it to the latest version (up)
+// or back one version (down), -to picking another one. The service applies every pending migration at
+// startup, down is for going back to an earlier version of metrika.
+func migrate(logger zerolog.Logger, args []string) {
+	const usage = "Usage: metrika migrate status|up|down [-to version]"
+	if len(args) == 0 {
+		logger.Fatal().Msg(usage)
+	}
+	action := args[0]
+	fs := flag.NewFlagSet("migrate "+action, flag.ExitOnError)
+	to := fs.Int("to", -1, "version to migrate to, by default the latest for up and the previous one for down")
+	cfg, err := config.Load(fs, args[1:], os.LookupEnv)
+	if err != nil {
+		logger.Fatal().Msgf("Failed to load configuration: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(logger.WithContext(context.Background()), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	repo, err := repository.Open(cfg.Database.DSN)
+	if err != nil {
+		logger.Fatal().Msgf("Failed to initialize repository: %v", err)
+	}
+	migrator, ok := repo.(repository.Migrator)
+	if !ok {
+		logger.Fatal().Msg("Only SQLite databases have a versioned schema")
+	}
+	migrations, version, err := migrator.Migrations(ctx)
+	if err != nil {
+		logger.Fatal().Msgf("Failed to read the schema version: %v", err)
+	}
+
+	switch action {
+	case "status":
+		for _, m := range migrations {
+			state := "pending"
+			if !m.AppliedAt.IsZero() {
+				state = "applied " + m.AppliedAt.Format(time.RFC3339)
+			}
+			logger.Info().Msgf("%3d %-24s %s", m.Version, m.Name, state)
+		}
+		logger.Info().Msgf("Schema at version %d, the latest is %d", version, len(migrations))
+		return
+	case "up":
+		if *to < 0 {
+			*to = len(migrations)
+		}
+		if *to < version {
+			logger.Fatal().Msgf("The schema is at version %d, up cannot go back to %d", version, *to)
+		}
+	case "down":
+		if *to < 0 {
+			*to = max(version-1, 0)
+		}
+		if *to > version {
+			logger.Fatal().Msgf("The schema is at version %d, down cannot go forward to %d", version, *to)
+		}
+	default:
+		logger.Fatal().Msg(usage)
+	}
+	if err := migrator.MigrateTo(ctx, *to); err != nil {
+		logger.Fatal().Msgf("Failed to migrate the schema: %v", err)
+	}
+	logger.Info().Msgf("Schema migrated from version %d to %d", version, *to)
+}
+
+func newSmartBloxClient(cfg config.SmartBlox) client.Client {
+	return client.NewHTTPClient(cfg.BaseURL, cfg.Timeout, cfg.Mock, client.RetryPolicy{
+		MaxAttempts: cfg.Retry.MaxAttempts,
+		BaseBackoff: cfg.Retry.BaseBackoff,
+		MaxBackoff:  cfg.Retry.MaxBackoff,
+		Jitter:      cfg.Retry.Jitter,
+	})
+}
+
+// amountValidation applies the configured amount limits. The policy was already checked by config.Validate.
+func amountValidation(cfg config.Ingest) ingest.Option {
+	policy, _ := ingest.ParseAmountPolicy(cfg.InvalidAmounts)
+	return ingest.WithAmountValidation(cfg.MaxAmount, policy)
+}
+
+// windows applies the configured sliding windows. They were already checked by config.Validate.
+func windows(cfg config.Ingest) ingest.Option {
+	specs, _ := models.ParseWindowSpecs(cfg.Windows)
+	return ingest.WithWindows(specs...)
+}
+
+// setupEventSink fans the events out to the rotated event log and to the optional sinks.
+func setupEventSink(ctx context.Context, cfg config.Config, logger zerolog.Logger) (sink.EventSink, error) {
+	overflow, err := sink.ParseOverflow(cfg.Sinks.Overflow)
+	if err != nil {
+		return nil, err
+	}
+	target := func(name string, s sink.EventSink) sink.Target {
+		return sink.Target{Name: name, Sink: s, QueueSize: cfg.Sinks.QueueSize, Overflow: overflow, Attempts: cfg.Sinks.Attempts}
+	}
+
+	// The event log is what rebuild recomputes the metrics from, so it never drops a round.
+	file := target("file", sink.NewWriter(&lumberjack.Logger{
+		Filename: cfg.EventLog.Path,
+		MaxAge:   cfg.EventLog.MaxAgeDays,
+		Compress: cfg.EventLog.Compress,
+	}))
+	file.Overflow = sink.Block
+	file.Persistent = true
+	targets := []sink.Target{file}
+	if cfg.Sinks.Stdout {
+		targets = append(targets, target("stdout", sink.NewStdout()))
+	}
+	if cfg.Sinks.SQLiteDSN != "" {
+		store, err := repository.NewSQLiteMetrics(cfg.Sinks.SQLiteDSN)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.Init(ctx); err != nil {
+			return nil, err
+		}
+		targets = append(targets, target("sqlite", sink.NewStore(store)))
+	}
+	if cfg.Sinks.WebhookURL != "" {
+		targets = append(targets, target("webhook", sink.NewWebhook(cfg.Sinks.WebhookURL, cfg.Sinks.WebhookTimeout)))
+	}
+	return sink.NewFanOut(logger, targets...), nil
 }